@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/robfig/cron/v3"
@@ -28,10 +29,15 @@ func main() {
 		log.Fatalf("DB connection failed: %v", err)
 	}
 
+	// Load JWT signing keys
+	if err := utils.InitJWTKeys(); err != nil {
+		log.Fatalf("JWT key initialization failed: %v", err)
+	}
+
 	// Initialize Redis
 	if err := utils.InitRedis(); err != nil {
 		log.Warnf("Redis initialization failed: %v", err)
-		log.Warn("Password reset functionality will be disabled")
+		log.Warn("Password reset and refresh tokens will be disabled")
 	} else {
 		log.Info("Redis connection successful")
 	}
@@ -42,43 +48,70 @@ func main() {
 		log.Warn("Profile picture upload will be disabled")
 	}
 
-	if err := db.AutoMigrate(&models.User{}, &models.Activity{}, &models.Streak{}, &models.TileConfig{}); err != nil {
-		log.Fatalf("AutoMigrate failed: %v", err)
+	// Initialize WebAuthn relying party
+	if err := services.InitWebAuthn(); err != nil {
+		log.Warnf("WebAuthn initialization failed: %v", err)
+		log.Warn("Passkey login will be disabled")
 	}
-	log.Info("DB migrations successful")
 
-	loc, err := time.LoadLocation("Asia/Kolkata")
-	if err != nil {
-		log.Fatalf("failed to load IST timezone: %v", err)
+	// Accounts created before email verification existed get the column as false,
+	// they are marked verified once so they keep getting streak reminders
+	backfillEmailVerified := !db.Migrator().HasColumn(&models.User{}, "EmailVerified")
+
+	if err := db.AutoMigrate(&models.User{}, &models.Activity{}, &models.Streak{}, &models.TileConfig{}, &models.ActivityCategory{}, &models.RecoveryCode{}, &models.PersonalAccessToken{}, &models.UserIdentity{}, &models.Passkey{}, &models.AuditEvent{}, &models.ActivityTimer{}, &models.ActivitySegment{}, &models.ActivityTemplate{}, &models.ActivityTemplateEntry{}, &models.ActivityRevision{}, &models.JobRun{}); err != nil {
+		log.Fatalf("AutoMigrate failed: %v", err)
+	}
+	if backfillEmailVerified {
+		if err := db.Model(&models.User{}).Where("email_verified = ?", false).Update("email_verified", true).Error; err != nil {
+			log.Fatalf("Email verified backfill failed: %v", err)
+		}
 	}
+	log.Info("DB migrations successful")
 
+	// Jobs tick every 15 minutes in UTC; each run handles the timezone buckets
+	// that reached the job's local hour and have not run for their local today
 	c := cron.New(
-		cron.WithLocation(loc),
+		cron.WithLocation(time.UTC),
 		cron.WithSeconds(), // allows specifying seconds in the spec
 	)
-	// Midnight cron job for streak processing
-	_, err = c.AddFunc("0 0 0 * * *", func() {
+	schedule := fmt.Sprintf("0 */%d * * * *", int(services.SchedulerInterval.Minutes()))
+
+	// Local midnight job for streak processing
+	_, err := c.AddFunc(schedule, func() {
 		if err := services.CronJob(context.Background()); err != nil {
 			log.Errorf("Daily job failed: %v", err)
 		} else {
-			log.Info("Daily job completed successfully")
+			log.Debug("Daily job completed successfully")
 		}
 	})
 	if err != nil {
 		log.Fatalf("Failed to add cron job: %v", err)
 	}
 
-	_, err = c.AddFunc("0 0 9 * * *", func() {
+	// Local 9 AM streak reminder emails
+	_, err = c.AddFunc(schedule, func() {
 		if err := services.SendStreakReminderEmails(); err != nil {
 			log.Errorf("Email reminder job failed: %v", err)
 		} else {
-			log.Info("Email reminder job completed successfully")
+			log.Debug("Email reminder job completed successfully")
 		}
 	})
 	if err != nil {
 		log.Fatalf("Failed to add email cron job: %v", err)
 	}
 
+	// Hourly purge of accounts past their deletion grace period
+	_, err = c.AddFunc("0 0 * * * *", func() {
+		if err := services.PurgeDeletedAccounts(context.Background()); err != nil {
+			log.Errorf("Account purge job failed: %v", err)
+		} else {
+			log.Debug("Account purge job completed successfully")
+		}
+	})
+	if err != nil {
+		log.Fatalf("Failed to add account purge cron job: %v", err)
+	}
+
 	c.Start()
 	defer c.Stop()
 
@@ -99,13 +132,40 @@ func main() {
 		log.Debug("Health check endpoint hit")
 		return c.SendString("API is running...")
 	})
-	app.Post("/register", services.RegisterHandler)
+	app.Get("/.well-known/jwks.json", services.JWKSHandler)
+	app.Post("/register", services.IdempotencyMiddleware, services.RegisterHandler)
 	app.Post("/login", services.LoginHandler)
 	app.Post("/users", services.AuthMiddleware, services.GetUsersHandler)
 
-	app.Post("/create-activity", services.AuthMiddleware, services.CreateActivityHandler)
+	app.Post("/create-activity", services.AuthMiddleware, services.IdempotencyMiddleware, services.CreateActivityHandler)
+	app.Post("/activities/batch", services.AuthMiddleware, services.BatchActivityHandler)
+	app.Post("/activities/copy", services.AuthMiddleware, services.CopyActivitiesHandler)
+	app.Patch("/activities/:id", services.AuthMiddleware, services.UpdateActivityHandler)
+	app.Delete("/activities/:id", services.AuthMiddleware, services.DeleteActivityHandler)
+	app.Get("/activities/:id/history", services.AuthMiddleware, services.ActivityHistoryHandler)
+	app.Post("/activities/:id/revert", services.AuthMiddleware, services.RevertActivityHandler)
+	app.Post("/activities/segments", services.AuthMiddleware, services.CreateSegmentHandler)
+	app.Delete("/activities/segments/:id", services.AuthMiddleware, services.DeleteSegmentHandler)
+
+	app.Get("/timer", services.AuthMiddleware, services.GetTimerHandler)
+	app.Post("/timer/start", services.AuthMiddleware, services.StartTimerHandler)
+	app.Post("/timer/stop", services.AuthMiddleware, services.StopTimerHandler)
+	app.Delete("/timer", services.AuthMiddleware, services.DiscardTimerHandler)
+
 	app.Post("/get-activities", services.AuthMiddleware, services.GetActivityHandler)
 
+	app.Get("/activity-categories", services.AuthMiddleware, services.GetCategoriesHandler)
+	app.Post("/activity-categories", services.AuthMiddleware, services.CreateCategoryHandler)
+	app.Patch("/activity-categories/:id", services.AuthMiddleware, services.UpdateCategoryHandler)
+	app.Delete("/activity-categories/:id", services.AuthMiddleware, services.DeleteCategoryHandler)
+
+	app.Get("/activity-templates", services.AuthMiddleware, services.GetTemplatesHandler)
+	app.Post("/activity-templates", services.AuthMiddleware, services.CreateTemplateHandler)
+	app.Put("/activity-templates/:id", services.AuthMiddleware, services.UpdateTemplateHandler)
+	app.Delete("/activity-templates/:id", services.AuthMiddleware, services.DeleteTemplateHandler)
+	app.Post("/activity-templates/:id/apply", services.AuthMiddleware, services.ApplyTemplateHandler)
+	app.Post("/activities/drafts/confirm", services.AuthMiddleware, services.ConfirmDraftsHandler)
+
 	app.Post("/get-streak", services.AuthMiddleware, services.GetStreakHandler)
 
 	app.Get("/tile-config", services.AuthMiddleware, services.GetTileConfigHandler)
@@ -114,18 +174,76 @@ func main() {
 
 	app.Post("/update-username", services.AuthMiddleware, services.UpdateUsernameHandler)
 	app.Post("/update-privacy", services.AuthMiddleware, services.UpdatePrivacyHandler)
+	app.Post("/update-timezone", services.AuthMiddleware, services.UpdateTimezoneHandler)
 	app.Get("/get-privacy", services.AuthMiddleware, services.GetPrivacyHandler)
 	app.Post("/change-password", services.AuthMiddleware, services.ChangePasswordHandler)
+	app.Post("/set-password", services.AuthMiddleware, services.SetPasswordHandler)
+	app.Post("/change-email", services.AuthMiddleware, services.ChangeEmailHandler)
+
+	app.Delete("/account", services.AuthMiddleware, services.DeleteAccountHandler)
+	app.Post("/account/cancel-deletion", services.AuthMiddleware, services.CancelAccountDeletionHandler)
+	app.Get("/account/export", services.AuthMiddleware, services.ExportAccountHandler)
+	app.Get("/account/security-log", services.AuthMiddleware, services.SecurityLogHandler)
+
+	app.Get("/tokens", services.AuthMiddleware, services.ListTokensHandler)
+	app.Post("/tokens", services.AuthMiddleware, services.CreateTokenHandler)
+	app.Delete("/tokens/:id", services.AuthMiddleware, services.DeleteTokenHandler)
+
+	app.Post("/auth/refresh", services.RefreshTokenHandler)
+	app.Post("/auth/logout", services.AuthMiddleware, services.LogoutHandler)
+	app.Post("/auth/logout-all", services.AuthMiddleware, services.LogoutAllHandler)
+
+	app.Post("/auth/2fa/enroll", services.AuthMiddleware, services.EnrollTOTPHandler)
+	app.Post("/auth/2fa/verify", services.AuthMiddleware, services.VerifyTOTPHandler)
+	app.Post("/auth/2fa/disable", services.AuthMiddleware, services.DisableTOTPHandler)
+	app.Post("/auth/2fa/recovery-codes", services.AuthMiddleware, services.RegenerateRecoveryCodesHandler)
+	app.Post("/auth/2fa/login", services.MFALoginHandler)
+
+	app.Get("/sessions", services.AuthMiddleware, services.ListSessionsHandler)
+	app.Delete("/sessions/:id", services.AuthMiddleware, services.RevokeSessionHandler)
+
+	app.Get("/auth/oidc/providers", services.ListOIDCProvidersHandler)
+	app.Post("/auth/oidc/:provider/start", services.StartOIDCLoginHandler)
+	app.Post("/auth/oidc/:provider/link", services.AuthMiddleware, services.StartOIDCLinkHandler)
+	app.Post("/auth/oidc/:provider/callback", services.OIDCCallbackHandler)
+	app.Get("/auth/identities", services.AuthMiddleware, services.ListIdentitiesHandler)
+	app.Delete("/auth/identities/:id", services.AuthMiddleware, services.UnlinkIdentityHandler)
+
+	app.Post("/auth/passkeys/register/begin", services.AuthMiddleware, services.BeginPasskeyRegistrationHandler)
+	app.Post("/auth/passkeys/register/finish", services.AuthMiddleware, services.FinishPasskeyRegistrationHandler)
+	app.Post("/auth/passkeys/login/begin", services.BeginPasskeyLoginHandler)
+	app.Post("/auth/passkeys/login/finish", services.FinishPasskeyLoginHandler)
+	app.Get("/passkeys", services.AuthMiddleware, services.ListPasskeysHandler)
+	app.Delete("/passkeys/:id", services.AuthMiddleware, services.DeletePasskeyHandler)
+
+	app.Post("/auth/verify-email", services.VerifyEmailHandler)
+	app.Post("/auth/resend-verification", services.AuthMiddleware, services.ResendVerificationHandler)
+
+	app.Post("/auth/confirm-email-change", services.ConfirmEmailChangeHandler)
+	app.Post("/auth/revert-email-change", services.RevertEmailChangeHandler)
+
+	app.Post("/auth/magic-link", services.RequestMagicLinkHandler)
+	app.Post("/auth/magic-link/verify", services.VerifyMagicLinkHandler)
 
 	app.Post("/auth/forgot-password", services.ForgotPasswordHandler)
 	app.Post("/auth/reset-password", services.ResetPasswordHandler)
 	app.Get("/auth/reset-password/validate", services.ValidateResetTokenHandler)
 
 	// Profile picture endpoints
-	app.Post("/profile/upload-picture", services.AuthMiddleware, services.UploadProfilePictureHandler)
+	app.Post("/profile/upload-picture", services.AuthMiddleware, services.IdempotencyMiddleware, services.UploadProfilePictureHandler)
 	app.Delete("/profile/picture", services.AuthMiddleware, services.DeleteProfilePictureHandler)
 	app.Get("/profile", services.AuthMiddleware, services.GetProfileHandler)
 
+	// Admin endpoints
+	admin := app.Group("/admin", services.AuthMiddleware, services.RequireRole(models.RoleAdmin))
+	admin.Get("/users", services.AdminListUsersHandler)
+	admin.Get("/users/:id", services.AdminGetUserHandler)
+	admin.Post("/users/:id/disable", services.AdminDisableUserHandler)
+	admin.Post("/users/:id/enable", services.AdminEnableUserHandler)
+	admin.Post("/users/:id/force-password-reset", services.AdminForcePasswordResetHandler)
+	admin.Get("/stats", services.AdminStatsHandler)
+	admin.Get("/audit-events", services.AdminAuditEventsHandler)
+
 	port := utils.GetFromEnv("PORT")
 	if port == "" {
 		port = "8000"
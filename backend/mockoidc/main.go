@@ -0,0 +1,306 @@
+/*
+#Plan: Local Mock OIDC Issuer
+
+A minimal OpenID Connect provider for trying the login and link flows without a
+real identity provider. Never deploy it, it signs in whoever fills in the form.
+
+Run:
+  go run ./mockoidc                      (listens on 127.0.0.1:9400)
+  MOCK_OIDC_ADDR=127.0.0.1:9500 go run ./mockoidc
+
+Backend configuration (plain http is accepted for localhost issuers):
+  OIDC_PROVIDERS=mock
+  OIDC_MOCK_ISSUER=http://localhost:9400
+  OIDC_MOCK_CLIENT_ID=growth-tracker
+  OIDC_MOCK_REDIRECT_URL=http://localhost:5173/oidc/callback
+
+Endpoints:
+1. GET /.well-known/openid-configuration
+2. GET /jwks - one RSA key generated at startup
+3. GET /authorize - form to pick the subject, email and name to log in as
+   POST /authorize - redirects to redirect_uri with ?code=&state=
+   - PKCE is required (S256), codes are single-use and live for a minute
+4. POST /token - exchanges the code, checks redirect_uri, client_id and the
+   code_verifier, returns an RS256 ID token carrying the nonce
+*/
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	keyID       = "mock"
+	codeTTL     = time.Minute
+	idTokenTTL  = 10 * time.Minute
+	defaultAddr = "127.0.0.1:9400"
+)
+
+// authRequest is what an issued code stands for until it is redeemed
+type authRequest struct {
+	ClientID      string
+	RedirectURI   string
+	Nonce         string
+	CodeChallenge string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	ExpiresAt     time.Time
+}
+
+type issuer struct {
+	url string
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authRequest
+}
+
+var authorizeForm = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="UTF-8"><title>Mock OIDC login</title></head>
+<body style="font-family: sans-serif; max-width: 420px; margin: 40px auto;">
+  <h2>Mock OIDC login</h2>
+  <p>Client <strong>{{.ClientID}}</strong> asks who you are.</p>
+  <form method="POST" action="/authorize">
+    {{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+    {{end}}
+    <p><label>Subject<br><input name="sub" value="mock-user-1" required></label></p>
+    <p><label>Email<br><input name="email" value="mock.user@example.com"></label></p>
+    <p><label>Name<br><input name="name" value="Mock User"></label></p>
+    <p><label><input type="checkbox" name="email_verified" value="true" checked> Email verified</label></p>
+    <button type="submit">Log in</button>
+  </form>
+</body>
+</html>
+`))
+
+func main() {
+	addr := os.Getenv("MOCK_OIDC_ADDR")
+	if addr == "" {
+		addr = defaultAddr
+	}
+	host := addr
+	if strings.HasPrefix(host, "127.0.0.1:") {
+		host = "localhost:" + strings.TrimPrefix(host, "127.0.0.1:")
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatalf("Failed to generate signing key: %v", err)
+	}
+	iss := &issuer{url: "http://" + host, key: key, codes: map[string]authRequest{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", iss.discoveryHandler)
+	mux.HandleFunc("/jwks", iss.jwksHandler)
+	mux.HandleFunc("/authorize", iss.authorizeHandler)
+	mux.HandleFunc("/token", iss.tokenHandler)
+
+	log.Printf("Mock OIDC issuer %s listening on %s", iss.url, addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Fatalf("Mock OIDC issuer stopped: %v", err)
+	}
+}
+
+// ==================== Handlers ====================
+
+func (iss *issuer) discoveryHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                iss.url,
+		"authorization_endpoint":                iss.url + "/authorize",
+		"token_endpoint":                        iss.url + "/token",
+		"jwks_uri":                              iss.url + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (iss *issuer) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	pub := iss.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// authorizeHandler shows the login form on GET and issues a code on POST
+func (iss *issuer) authorizeHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	params := map[string]string{}
+	for _, name := range []string{"response_type", "client_id", "redirect_uri", "state", "nonce", "code_challenge", "code_challenge_method"} {
+		params[name] = r.Form.Get(name)
+	}
+	if params["response_type"] != "code" || params["client_id"] == "" || params["redirect_uri"] == "" {
+		http.Error(w, "response_type=code, client_id and redirect_uri are required", http.StatusBadRequest)
+		return
+	}
+	if params["code_challenge"] == "" || params["code_challenge_method"] != "S256" {
+		http.Error(w, "PKCE with code_challenge_method=S256 is required", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(params["redirect_uri"])
+	if err != nil || !redirect.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = authorizeForm.Execute(w, map[string]interface{}{"ClientID": params["client_id"], "Params": params})
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	subject := strings.TrimSpace(r.PostForm.Get("sub"))
+	if subject == "" {
+		http.Error(w, "sub is required", http.StatusBadRequest)
+		return
+	}
+
+	code, err := randomToken()
+	if err != nil {
+		http.Error(w, "failed to issue code", http.StatusInternalServerError)
+		return
+	}
+	iss.mu.Lock()
+	iss.codes[code] = authRequest{
+		ClientID:      params["client_id"],
+		RedirectURI:   params["redirect_uri"],
+		Nonce:         params["nonce"],
+		CodeChallenge: params["code_challenge"],
+		Subject:       subject,
+		Email:         strings.TrimSpace(r.PostForm.Get("email")),
+		EmailVerified: r.PostForm.Get("email_verified") == "true",
+		Name:          strings.TrimSpace(r.PostForm.Get("name")),
+		ExpiresAt:     time.Now().Add(codeTTL),
+	}
+	iss.mu.Unlock()
+
+	query := redirect.Query()
+	query.Set("code", code)
+	if params["state"] != "" {
+		query.Set("state", params["state"])
+	}
+	redirect.RawQuery = query.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// tokenHandler redeems a code for an ID token
+func (iss *issuer) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type")
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	iss.mu.Lock()
+	req, ok := iss.codes[code]
+	delete(iss.codes, code) // single-use, even if the checks below fail
+	iss.mu.Unlock()
+
+	if !ok || time.Now().After(req.ExpiresAt) ||
+		r.PostForm.Get("client_id") != req.ClientID ||
+		r.PostForm.Get("redirect_uri") != req.RedirectURI {
+		tokenError(w, "invalid_grant")
+		return
+	}
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(challenge[:]) != req.CodeChallenge {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	preferred, _, _ := strings.Cut(req.Email, "@")
+	claims := jwt.MapClaims{
+		"iss":                iss.url,
+		"sub":                req.Subject,
+		"aud":                req.ClientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(idTokenTTL).Unix(),
+		"email":              req.Email,
+		"email_verified":     req.EmailVerified,
+		"name":               req.Name,
+		"preferred_username": preferred,
+	}
+	if req.Nonce != "" {
+		claims["nonce"] = req.Nonce
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
+	idToken, err := token.SignedString(iss.key)
+	if err != nil {
+		http.Error(w, "failed to sign id token", http.StatusInternalServerError)
+		return
+	}
+
+	accessToken, err := randomToken()
+	if err != nil {
+		http.Error(w, "failed to issue access token", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(idTokenTTL.Seconds()),
+		"id_token":     idToken,
+	})
+}
+
+// ==================== Helper Functions ====================
+
+func tokenError(w http.ResponseWriter, code string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func randomToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
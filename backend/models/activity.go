@@ -61,27 +61,61 @@ type Activity struct {
 	// Optional note for the activity (max 500 characters)
 	Note *string `gorm:"type:varchar(500)"`
 
+	// Pre-filled from a template and not yet confirmed by the user. Drafts are
+	// hidden from other users and do not count towards streaks.
+	Draft bool `gorm:"not null;default:false"`
+
+	// Bumped on every update so clients can detect writes based on stale data
+	Version uint `gorm:"not null;default:1"`
+
 	CreatedAt    time.Time `gorm:"not null;default:now();autoCreateTime"`
 	UpdatedAt    time.Time `gorm:"not null;default:now();autoUpdateTime"`
 	ActivityDate time.Time `gorm:"type:date;default:CURRENT_DATE;index:idx_activities_user_date"`
+
+	// Set when a change restores an earlier revision, see ActivityRevision
+	RevertOf *uint `gorm:"-"`
+
+	previous *activitySnapshot
 }
 
 func (a *Activity) BeforeSave(tx *gorm.DB) error {
-	return a.Validate()
+	return a.Validate(tx.Session(&gorm.Session{NewDB: true}))
+}
+
+// BeforeUpdate bumps the version and remembers the stored values for the update
+// revision. Bulk updates without a loaded entry are left alone.
+func (a *Activity) BeforeUpdate(tx *gorm.DB) error {
+	if a.ID == 0 {
+		return nil
+	}
+	a.Version++
+	return a.loadPrevious(tx)
 }
 
-func (a *Activity) Validate() error {
+// Validate checks the duration and that Name is either a built-in activity
+// or a category owned by the activity's user. Archived categories are still
+// accepted here so existing entries remain valid.
+func (a *Activity) Validate(tx *gorm.DB) error {
 	if a.DurationHours < 0 || a.DurationHours > 24 {
 		return fmt.Errorf("duration_hours must be between 0 and 24")
 	}
 
-	if !a.Name.IsValid() {
+	if a.Name.IsValid() {
+		return nil
+	}
+
+	category, err := FindUserCategory(tx, a.UserID, a.Name)
+	if err != nil {
+		return err
+	}
+	if category == nil {
 		return fmt.Errorf("invalid activity name: %s", a.Name)
 	}
 
 	return nil
 }
 
+// IsValid reports whether a is one of the built-in activity names
 func (a ActivityName) IsValid() bool {
 	for _, allowed := range ActivityNames {
 		if a == allowed {
@@ -0,0 +1,89 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	categorySlugPattern  = regexp.MustCompile(`^[a-z0-9_]+$`)
+	categoryColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+	categoryIconPattern  = regexp.MustCompile(`^[a-z0-9_-]+$`)
+	slugSeparatorPattern = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// ActivityCategory is a user-defined activity type that extends the built-in ActivityNames.
+// Activities reference a category by its slug through Activity.Name.
+type ActivityCategory struct {
+	ID uint `gorm:"primaryKey"`
+
+	UserID uint `gorm:"not null;uniqueIndex:idx_activity_categories_user_slug"`
+	User   User `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+
+	Name    string `gorm:"type:varchar(50);not null"`
+	Slug    string `gorm:"type:varchar(50);not null;uniqueIndex:idx_activity_categories_user_slug"`
+	Color   string `gorm:"type:varchar(7);not null"`
+	IconKey string `gorm:"type:varchar(50);not null"`
+
+	// Archived categories cannot receive new entries but their history stays readable
+	Archived bool `gorm:"not null;default:false"`
+
+	CreatedAt time.Time `gorm:"not null;default:now();autoCreateTime"`
+	UpdatedAt time.Time `gorm:"not null;default:now();autoUpdateTime"`
+}
+
+func (c *ActivityCategory) BeforeSave(tx *gorm.DB) error {
+	return c.Validate()
+}
+
+func (c *ActivityCategory) Validate() error {
+	if strings.TrimSpace(c.Name) == "" || len(c.Name) > 50 {
+		return fmt.Errorf("name must be between 1 and 50 characters")
+	}
+
+	if len(c.Slug) == 0 || len(c.Slug) > 50 || !categorySlugPattern.MatchString(c.Slug) {
+		return fmt.Errorf("invalid slug: %s", c.Slug)
+	}
+
+	if ActivityName(c.Slug).IsValid() {
+		return fmt.Errorf("slug %s is reserved for a built-in activity", c.Slug)
+	}
+
+	if !categoryColorPattern.MatchString(c.Color) {
+		return fmt.Errorf("color must be a hex value like #1a2b3c")
+	}
+
+	if len(c.IconKey) == 0 || len(c.IconKey) > 50 || !categoryIconPattern.MatchString(c.IconKey) {
+		return fmt.Errorf("invalid icon key: %s", c.IconKey)
+	}
+
+	return nil
+}
+
+// SlugifyCategoryName turns a display name like "Deep Work" into "deep_work"
+func SlugifyCategoryName(name string) string {
+	slug := slugSeparatorPattern.ReplaceAllString(strings.ToLower(strings.TrimSpace(name)), "_")
+	slug = strings.Trim(slug, "_")
+	if len(slug) > 50 {
+		slug = strings.TrimRight(slug[:50], "_")
+	}
+	return slug
+}
+
+// FindUserCategory looks up a category owned by userID by its slug.
+// Returns nil if the user has no such category.
+func FindUserCategory(tx *gorm.DB, userID uint, slug ActivityName) (*ActivityCategory, error) {
+	var category ActivityCategory
+	result := tx.Where("user_id = ? AND slug = ?", userID, string(slug)).Limit(1).Find(&category)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+	return &category, nil
+}
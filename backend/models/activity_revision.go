@@ -0,0 +1,128 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type RevisionAction string
+
+const (
+	RevisionCreate RevisionAction = "create"
+	RevisionUpdate RevisionAction = "update"
+	RevisionDelete RevisionAction = "delete"
+	RevisionRevert RevisionAction = "revert"
+)
+
+// ActivityRevision records one change of an activity entry with the values from
+// before and after it. Rows are only ever inserted, they are written by the
+// Activity hooks below so every write path is covered.
+type ActivityRevision struct {
+	ID uint `gorm:"primaryKey"`
+
+	// Not a foreign key, the history of a deleted entry stays readable
+	ActivityID uint `gorm:"not null;index"`
+
+	UserID uint `gorm:"not null;index"`
+	User   User `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+
+	Action       RevisionAction `gorm:"type:varchar(16);not null"`
+	Name         ActivityName   `gorm:"type:varchar(50);not null"`
+	ActivityDate time.Time      `gorm:"type:date;not null"`
+
+	// Nil before a create and after a delete
+	PreviousHours *float32 `gorm:"type:decimal(4,2)"`
+	PreviousNote  *string  `gorm:"type:varchar(500)"`
+	Hours         *float32 `gorm:"type:decimal(4,2)"`
+	Note          *string  `gorm:"type:varchar(500)"`
+
+	// The revision a revert restored
+	RevertOf *uint
+
+	CreatedAt time.Time `gorm:"not null;default:now();autoCreateTime"`
+}
+
+// activitySnapshot is the part of an entry a revision keeps
+type activitySnapshot struct {
+	DurationHours float32
+	Note          *string
+}
+
+// AfterCreate records the create revision
+func (a *Activity) AfterCreate(tx *gorm.DB) error {
+	return a.recordRevision(tx, RevisionCreate, nil, &activitySnapshot{DurationHours: a.DurationHours, Note: a.Note})
+}
+
+// loadPrevious remembers the stored values for the update revision
+func (a *Activity) loadPrevious(tx *gorm.DB) error {
+	var previous activitySnapshot
+	result := tx.Session(&gorm.Session{NewDB: true}).
+		Model(&Activity{}).
+		Select("duration_hours", "note").
+		Where("id = ?", a.ID).
+		Limit(1).
+		Find(&previous)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		a.previous = &previous
+	}
+	return nil
+}
+
+// AfterUpdate records the update revision if the hours or note changed
+func (a *Activity) AfterUpdate(tx *gorm.DB) error {
+	if a.ID == 0 {
+		return nil
+	}
+	previous := a.previous
+	a.previous = nil
+
+	current := &activitySnapshot{DurationHours: a.DurationHours, Note: a.Note}
+	if previous != nil && a.RevertOf == nil &&
+		previous.DurationHours == current.DurationHours && sameNote(previous.Note, current.Note) {
+		return nil
+	}
+	return a.recordRevision(tx, RevisionUpdate, previous, current)
+}
+
+// AfterDelete records the delete revision. Bulk deletes without a loaded entry
+// are not recorded.
+func (a *Activity) AfterDelete(tx *gorm.DB) error {
+	if a.ID == 0 {
+		return nil
+	}
+	return a.recordRevision(tx, RevisionDelete, &activitySnapshot{DurationHours: a.DurationHours, Note: a.Note}, nil)
+}
+
+func (a *Activity) recordRevision(tx *gorm.DB, action RevisionAction, before, after *activitySnapshot) error {
+	revision := ActivityRevision{
+		ActivityID:   a.ID,
+		UserID:       a.UserID,
+		Action:       action,
+		Name:         a.Name,
+		ActivityDate: a.ActivityDate,
+		RevertOf:     a.RevertOf,
+	}
+	if a.RevertOf != nil {
+		revision.Action = RevisionRevert
+	}
+	if before != nil {
+		revision.PreviousHours = &before.DurationHours
+		revision.PreviousNote = before.Note
+	}
+	if after != nil {
+		revision.Hours = &after.DurationHours
+		revision.Note = after.Note
+	}
+	return tx.Session(&gorm.Session{NewDB: true}).Create(&revision).Error
+}
+
+func sameNote(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
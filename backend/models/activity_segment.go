@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// ActivitySegment is one start/end interval of an activity entry. Entries with
+// segments derive DurationHours from them, a user's segments never overlap.
+type ActivitySegment struct {
+	ID uint `gorm:"primaryKey"`
+
+	ActivityID uint     `gorm:"not null;index"`
+	Activity   Activity `gorm:"foreignKey:ActivityID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+
+	// Denormalized from the activity so overlap checks only need this table
+	UserID uint `gorm:"not null;index:idx_activity_segments_user_start"`
+
+	StartAt time.Time `gorm:"not null;index:idx_activity_segments_user_start"`
+	EndAt   time.Time `gorm:"not null;check:end_at > start_at"`
+
+	CreatedAt time.Time `gorm:"not null;default:now();autoCreateTime"`
+}
+
+// Hours is the segment's length in hours
+func (s *ActivitySegment) Hours() float64 {
+	return s.EndAt.Sub(s.StartAt).Hours()
+}
@@ -0,0 +1,116 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type TemplateRecurrence string
+
+const (
+	RecurrenceDaily    TemplateRecurrence = "daily"
+	RecurrenceWeekdays TemplateRecurrence = "weekdays" // on the days of the week in Weekdays
+	RecurrenceInterval TemplateRecurrence = "interval" // every IntervalDays days from StartDate
+)
+
+var TemplateRecurrences = []TemplateRecurrence{
+	RecurrenceDaily,
+	RecurrenceWeekdays,
+	RecurrenceInterval,
+}
+
+// IsValid reports whether r is a known recurrence rule
+func (r TemplateRecurrence) IsValid() bool {
+	for _, allowed := range TemplateRecurrences {
+		if r == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// ActivityTemplate is a reusable set of entries for days that look the same,
+// e.g. 8h of sleep every day or office hours on weekdays
+type ActivityTemplate struct {
+	ID uint `gorm:"primaryKey"`
+
+	UserID uint `gorm:"not null;index"`
+	User   User `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+
+	Name string `gorm:"type:varchar(50);not null"`
+
+	Recurrence TemplateRecurrence `gorm:"type:varchar(16);not null"`
+	// Bit n is set for time.Weekday(n), only used by RecurrenceWeekdays
+	Weekdays uint8 `gorm:"not null;default:0"`
+	// Only used by RecurrenceInterval
+	IntervalDays int `gorm:"not null;default:0"`
+	// First day the template applies to, anchors RecurrenceInterval
+	StartDate time.Time `gorm:"type:date;not null"`
+
+	// Pre-fill matching days with draft entries in the nightly job
+	AutoFill bool `gorm:"not null;default:false"`
+
+	Entries []ActivityTemplateEntry `gorm:"foreignKey:TemplateID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+
+	CreatedAt time.Time `gorm:"not null;default:now();autoCreateTime"`
+	UpdatedAt time.Time `gorm:"not null;default:now();autoUpdateTime"`
+}
+
+// ActivityTemplateEntry is one activity a template logs
+type ActivityTemplateEntry struct {
+	ID uint `gorm:"primaryKey"`
+
+	TemplateID uint `gorm:"not null;index"`
+
+	Name  ActivityName `gorm:"type:varchar(50);not null"`
+	Hours float32      `gorm:"type:decimal(4,2);not null;check:hours > 0 AND hours <= 24"`
+	Note  *string      `gorm:"type:varchar(500)"`
+}
+
+// Validate checks the name and recurrence rule, entries are checked by the caller
+// since activity names depend on the user's categories
+func (t *ActivityTemplate) Validate() error {
+	if strings.TrimSpace(t.Name) == "" || len(t.Name) > 50 {
+		return fmt.Errorf("name must be between 1 and 50 characters")
+	}
+
+	switch t.Recurrence {
+	case RecurrenceDaily:
+	case RecurrenceWeekdays:
+		if t.Weekdays == 0 || t.Weekdays >= 1<<7 {
+			return fmt.Errorf("weekdays must list at least one day between 0 (Sunday) and 6 (Saturday)")
+		}
+	case RecurrenceInterval:
+		if t.IntervalDays < 1 || t.IntervalDays > 365 {
+			return fmt.Errorf("interval_days must be between 1 and 365")
+		}
+	default:
+		return fmt.Errorf("invalid recurrence: %s", t.Recurrence)
+	}
+
+	if t.StartDate.IsZero() {
+		return fmt.Errorf("start_date is required")
+	}
+	return nil
+}
+
+// Matches reports whether the template applies to date, a calendar day
+func (t *ActivityTemplate) Matches(date time.Time) bool {
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	start := time.Date(t.StartDate.Year(), t.StartDate.Month(), t.StartDate.Day(), 0, 0, 0, 0, time.UTC)
+	if day.Before(start) {
+		return false
+	}
+
+	switch t.Recurrence {
+	case RecurrenceDaily:
+		return true
+	case RecurrenceWeekdays:
+		return t.Weekdays&(1<<uint(day.Weekday())) != 0
+	case RecurrenceInterval:
+		days := int(day.Sub(start).Hours() / 24)
+		return t.IntervalDays > 0 && days%t.IntervalDays == 0
+	}
+	return false
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// ActivityTimer is a running stopwatch for one activity, a user has at most one
+type ActivityTimer struct {
+	ID uint `gorm:"primaryKey"`
+
+	UserID uint `gorm:"not null;uniqueIndex"`
+	User   User `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+
+	Name ActivityName `gorm:"type:varchar(50);not null"`
+	Note *string      `gorm:"type:varchar(500)"` // copied to entries the timer creates
+
+	StartedAt time.Time `gorm:"not null"`
+}
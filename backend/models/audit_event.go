@@ -0,0 +1,100 @@
+package models
+
+import "time"
+
+type AuditEventType string
+
+const (
+	AuditLoginSucceeded           AuditEventType = "login_succeeded"
+	AuditLoginFailed              AuditEventType = "login_failed"
+	AuditPasswordChanged          AuditEventType = "password_changed"
+	AuditPasswordResetRequested   AuditEventType = "password_reset_requested"
+	AuditPasswordResetCompleted   AuditEventType = "password_reset_completed"
+	AuditUsernameChanged          AuditEventType = "username_changed"
+	AuditPrivacyChanged           AuditEventType = "privacy_changed"
+	AuditTimezoneChanged          AuditEventType = "timezone_changed"
+	AuditProfilePictureUpdated    AuditEventType = "profile_picture_updated"
+	AuditProfilePictureDeleted    AuditEventType = "profile_picture_deleted"
+	AuditTwoFactorEnabled         AuditEventType = "two_factor_enabled"
+	AuditTwoFactorDisabled        AuditEventType = "two_factor_disabled"
+	AuditAccountDeletionRequested AuditEventType = "account_deletion_requested"
+	AuditAccountDeletionCancelled AuditEventType = "account_deletion_cancelled"
+	AuditUserDisabled             AuditEventType = "user_disabled"
+	AuditUserEnabled              AuditEventType = "user_enabled"
+	AuditPasswordResetForced      AuditEventType = "password_reset_forced"
+	AuditEmailChangeRequested     AuditEventType = "email_change_requested"
+	AuditEmailChanged             AuditEventType = "email_changed"
+	AuditEmailChangeReverted      AuditEventType = "email_change_reverted"
+	AuditPasskeyAdded             AuditEventType = "passkey_added"
+	AuditPasskeyRemoved           AuditEventType = "passkey_removed"
+	AuditAccessTokenCreated       AuditEventType = "access_token_created"
+	AuditAccessTokenRevoked       AuditEventType = "access_token_revoked"
+	AuditIdentityLinked           AuditEventType = "identity_linked"
+	AuditIdentityUnlinked         AuditEventType = "identity_unlinked"
+	AuditRecoveryCodesRegenerated AuditEventType = "recovery_codes_regenerated"
+	AuditTwoFactorFailed          AuditEventType = "two_factor_failed"
+	AuditLoggedOutEverywhere      AuditEventType = "logged_out_everywhere"
+	AuditSessionRevoked           AuditEventType = "session_revoked"
+)
+
+var AuditEventTypes = []AuditEventType{
+	AuditLoginSucceeded,
+	AuditLoginFailed,
+	AuditPasswordChanged,
+	AuditPasswordResetRequested,
+	AuditPasswordResetCompleted,
+	AuditUsernameChanged,
+	AuditPrivacyChanged,
+	AuditTimezoneChanged,
+	AuditProfilePictureUpdated,
+	AuditProfilePictureDeleted,
+	AuditTwoFactorEnabled,
+	AuditTwoFactorDisabled,
+	AuditAccountDeletionRequested,
+	AuditAccountDeletionCancelled,
+	AuditUserDisabled,
+	AuditUserEnabled,
+	AuditPasswordResetForced,
+	AuditEmailChangeRequested,
+	AuditEmailChanged,
+	AuditEmailChangeReverted,
+	AuditPasskeyAdded,
+	AuditPasskeyRemoved,
+	AuditAccessTokenCreated,
+	AuditAccessTokenRevoked,
+	AuditIdentityLinked,
+	AuditIdentityUnlinked,
+	AuditRecoveryCodesRegenerated,
+	AuditTwoFactorFailed,
+	AuditLoggedOutEverywhere,
+	AuditSessionRevoked,
+}
+
+func (t AuditEventType) IsValid() bool {
+	for _, v := range AuditEventTypes {
+		if t == v {
+			return true
+		}
+	}
+	return false
+}
+
+// AuditEvent is an append-only record of a security relevant change to an account
+type AuditEvent struct {
+	ID uint `gorm:"primaryKey"`
+
+	// Target: the account the event is about
+	UserID uint `gorm:"not null;index:idx_audit_events_user_created"`
+	User   User `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+
+	// Who made the change, nil for unauthenticated requests (login, reset links)
+	ActorID *uint `gorm:"index"`
+
+	EventType AuditEventType `gorm:"type:varchar(50);not null;index"`
+	IP        string         `gorm:"type:varchar(64)"`
+	UserAgent string         `gorm:"type:varchar(255)"`
+	TraceID   string         `gorm:"type:varchar(16)"`
+	Payload   string         `gorm:"type:jsonb;not null;default:'{}'"` // event specific details, never secrets
+
+	CreatedAt time.Time `gorm:"not null;default:now();autoCreateTime;index:idx_audit_events_user_created"`
+}
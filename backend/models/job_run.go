@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// JobRun records the last local date a scheduled job finished for a timezone
+// bucket, so a bucket whose tick was missed is caught up on a later tick
+type JobRun struct {
+	Job         string    `gorm:"type:varchar(32);primaryKey"`
+	Timezone    string    `gorm:"type:varchar(64);primaryKey"`
+	LastRunDate time.Time `gorm:"type:date;not null"`
+	UpdatedAt   time.Time `gorm:"not null;default:now();autoUpdateTime"`
+}
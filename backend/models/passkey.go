@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Passkey is a WebAuthn credential registered by a user
+type Passkey struct {
+	ID uint `gorm:"primaryKey"`
+
+	UserID uint `gorm:"not null;index"`
+	User   User `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+
+	Name         string `gorm:"type:varchar(100);not null"`
+	CredentialID []byte `gorm:"type:bytea;not null;uniqueIndex"`
+	UserHandle   []byte `gorm:"type:bytea;not null;index"` // random per user, shared by all of the user's passkeys
+	PublicKey    []byte `gorm:"type:bytea;not null"`
+
+	AttestationType string `gorm:"type:varchar(32)"`
+	Transports      string `gorm:"type:varchar(255)"`  // space separated, e.g. "internal hybrid"
+	Flags           uint8  `gorm:"not null;default:0"` // raw authenticator flags (UP, UV, BE, BS)
+	AAGUID          []byte `gorm:"type:bytea"`
+	SignCount       int64  `gorm:"not null;default:0"`
+
+	CreatedAt  time.Time  `gorm:"not null;default:now();autoCreateTime"`
+	LastUsedAt *time.Time `gorm:"default:null"`
+}
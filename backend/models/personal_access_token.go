@@ -0,0 +1,73 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+type TokenScope string
+
+const (
+	ScopeActivitiesRead  TokenScope = "activities:read"
+	ScopeActivitiesWrite TokenScope = "activities:write"
+	ScopeProfileRead     TokenScope = "profile:read"
+)
+
+var TokenScopes = []TokenScope{
+	ScopeActivitiesRead,
+	ScopeActivitiesWrite,
+	ScopeProfileRead,
+}
+
+func (s TokenScope) IsValid() bool {
+	for _, v := range TokenScopes {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// PersonalAccessToken lets scripts authenticate without a password, only the SHA-256 hash is stored
+type PersonalAccessToken struct {
+	ID uint `gorm:"primaryKey"`
+
+	UserID uint `gorm:"not null;index"`
+	User   User `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+
+	Name      string `gorm:"type:varchar(100);not null"`
+	TokenHash string `gorm:"type:varchar(64);not null;uniqueIndex"`
+	Prefix    string `gorm:"type:varchar(16);not null"`  // first characters of the token, shown in listings
+	Scopes    string `gorm:"type:varchar(255);not null"` // space separated TokenScope values
+
+	ExpiresAt  *time.Time `gorm:"default:null"` // null means the token never expires
+	LastUsedAt *time.Time `gorm:"default:null"`
+	LastUsedIP *string    `gorm:"type:varchar(64);default:null"`
+
+	CreatedAt time.Time `gorm:"not null;default:now();autoCreateTime"`
+}
+
+// ScopeList returns the token's scopes
+func (t *PersonalAccessToken) ScopeList() []TokenScope {
+	fields := strings.Fields(t.Scopes)
+	scopes := make([]TokenScope, 0, len(fields))
+	for _, f := range fields {
+		scopes = append(scopes, TokenScope(f))
+	}
+	return scopes
+}
+
+// HasScope reports whether the token was granted scope
+func (t *PersonalAccessToken) HasScope(scope TokenScope) bool {
+	for _, s := range t.ScopeList() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IsExpired reports whether the token can no longer be used at now
+func (t *PersonalAccessToken) IsExpired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// RecoveryCode is a single-use 2FA backup code, only its SHA-256 hash is stored
+type RecoveryCode struct {
+	ID uint `gorm:"primaryKey"`
+
+	UserID uint `gorm:"not null;index"`
+	User   User `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+
+	CodeHash string     `gorm:"type:varchar(64);not null;uniqueIndex"`
+	UsedAt   *time.Time `gorm:"default:null"`
+
+	CreatedAt time.Time `gorm:"not null;default:now();autoCreateTime"`
+}
@@ -33,6 +33,7 @@ type TileConfig struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
 	UserID    uint      `gorm:"uniqueIndex;not null" json:"user_id"`
 	Config    JSONB     `gorm:"type:jsonb" json:"config"`
+	Version   uint      `gorm:"not null;default:1" json:"version"` // bumped on every save
 	CreatedAt time.Time `gorm:"not null;default:now();autoCreateTime" json:"created_at"`
 	UpdatedAt time.Time `gorm:"not null;default:now();autoUpdateTime" json:"updated_at"`
 
@@ -2,13 +2,47 @@ package models
 
 import "time"
 
+type Role string
+
+const (
+	RoleUser  Role = "user"
+	RoleAdmin Role = "admin"
+)
+
+var Roles = []Role{
+	RoleUser,
+	RoleAdmin,
+}
+
+func (r Role) IsValid() bool {
+	for _, v := range Roles {
+		if r == v {
+			return true
+		}
+	}
+	return false
+}
+
 type User struct {
-	ID           uint      `gorm:"primaryKey"`
-	Email        string    `gorm:"unique;not null"`
-	Username     string    `gorm:"unique;not null"`
-	PasswordHash string    `gorm:"not null"`
-	ProfilePic   *string   `gorm:"default:null"` // URL to profile picture, null for now
-	IsPrivate    bool      `gorm:"default:false"`
-	CreatedAt    time.Time `gorm:"not null;default:now();autoCreateTime"`
-	UpdatedAt    time.Time `gorm:"not null;default:now();autoUpdateTime"`
+	ID                  uint       `gorm:"primaryKey"`
+	Email               string     `gorm:"unique;not null"`
+	EmailVerified       bool       `gorm:"not null;default:false"`
+	Username            string     `gorm:"unique;not null"`
+	PasswordHash        string     `gorm:"not null"`
+	NoPassword          bool       `gorm:"not null;default:false"` // OIDC sign-up whose random password nobody knows, cleared once the user sets one
+	ProfilePic          *string    `gorm:"default:null"`           // URL to profile picture, null for now
+	IsPrivate           bool       `gorm:"default:false"`
+	Timezone            string     `gorm:"type:varchar(64);not null;default:'Asia/Kolkata'"` // IANA name, decides when the user's day rolls over
+	TOTPSecret          *string    `gorm:"type:varchar(64);default:null"`                    // set on 2FA enrollment, only trusted once TOTPEnabled
+	TOTPEnabled         bool       `gorm:"not null;default:false"`
+	Role                Role       `gorm:"type:varchar(16);not null;default:'user'"`
+	DisabledAt          *time.Time `gorm:"default:null"`       // set by an admin, blocks every login
+	DeletionRequestedAt *time.Time `gorm:"default:null;index"` // set by DELETE /account, purged after the grace period
+	CreatedAt           time.Time  `gorm:"not null;default:now();autoCreateTime"`
+	UpdatedAt           time.Time  `gorm:"not null;default:now();autoUpdateTime"`
+}
+
+// IsDisabled reports whether an admin has disabled the account
+func (u *User) IsDisabled() bool {
+	return u.DisabledAt != nil
 }
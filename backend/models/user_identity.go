@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// UserIdentity links an account to a subject at an external OpenID Connect provider
+type UserIdentity struct {
+	ID uint `gorm:"primaryKey"`
+
+	UserID uint `gorm:"not null;index"`
+	User   User `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+
+	Provider string `gorm:"type:varchar(50);not null;uniqueIndex:idx_user_identities_provider_subject"`
+	Subject  string `gorm:"type:varchar(255);not null;uniqueIndex:idx_user_identities_provider_subject"` // "sub" claim, stable per provider
+	Email    string `gorm:"type:varchar(255)"`                                                           // as reported by the provider at link time
+
+	CreatedAt  time.Time  `gorm:"not null;default:now();autoCreateTime"`
+	LastUsedAt *time.Time `gorm:"default:null"`
+}
@@ -0,0 +1,365 @@
+/*
+#Plan: Account Deletion & Data Export
+
+Endpoints:
+1. DELETE /account (authenticated)
+   - Requires the password, sets users.deletion_requested_at, logs out every session
+     and revokes all personal access tokens
+   - The account stays recoverable for AccountDeletionGracePeriod
+
+2. POST /account/cancel-deletion (authenticated)
+   - Clears the pending request; logging in during the grace period is still allowed
+
+3. GET /account/export (authenticated)
+   - ZIP archive with user.json (no password hash or 2FA secret), activities.json/.csv
+     including notes, streaks.json/.csv, tile_config.json and activity_categories.json
+
+Purge:
+- PurgeDeletedAccounts runs hourly and deletes users past the grace period
+- The profile blob is removed first, then activities, streaks, tile_configs, categories,
+  recovery codes, access tokens, linked identities, passkeys and the user row in one transaction
+- Accounts pending deletion are hidden from user search and get no reminder emails
+*/
+
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aman1117/backend/models"
+	"github.com/aman1117/backend/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+const AccountDeletionGracePeriod = 30 * 24 * time.Hour
+
+// ==================== Export Types ====================
+
+type exportUser struct {
+	ID                  uint       `json:"id"`
+	Email               string     `json:"email"`
+	EmailVerified       bool       `json:"email_verified"`
+	Username            string     `json:"username"`
+	ProfilePic          *string    `json:"profile_pic"`
+	IsPrivate           bool       `json:"is_private"`
+	Timezone            string     `json:"timezone"`
+	TOTPEnabled         bool       `json:"two_factor_enabled"`
+	DeletionRequestedAt *time.Time `json:"deletion_requested_at"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+}
+
+type exportActivity struct {
+	ID            uint      `json:"id"`
+	Name          string    `json:"name"`
+	DurationHours float32   `json:"hours"`
+	Date          string    `json:"date"`
+	Note          *string   `json:"note"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// ==================== Handlers ====================
+
+// DeleteAccountHandler handles DELETE /account
+func DeleteAccountHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	user, err := confirmPassword(c, userID)
+	if err != nil || user == nil {
+		return err
+	}
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithFullContext(traceID, userID, user.Username)
+
+	requestedAt := time.Now()
+	if user.DeletionRequestedAt != nil {
+		requestedAt = *user.DeletionRequestedAt
+	} else if err := RequestAccountDeletion(userID, requestedAt); err != nil {
+		log.Errorw("Account deletion request failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to delete account",
+			"error_code": "UPDATE_FAILED",
+		})
+	}
+
+	revokeUserSessions(userID, "")
+	if err := DeleteAllPersonalAccessTokens(userID); err != nil {
+		log.Errorw("Failed to revoke personal access tokens", "error", err)
+	}
+
+	purgeAt := requestedAt.Add(AccountDeletionGracePeriod)
+	log.Infow("Account deletion requested", "purge_at", purgeAt)
+	recordAuditEvent(c, userID, models.AuditAccountDeletionRequested, fiber.Map{"purge_at": purgeAt})
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success":  true,
+		"message":  "Your account will be deleted. Log in and cancel before the deletion date to keep it.",
+		"purge_at": purgeAt.UTC().Format(time.RFC3339),
+	})
+}
+
+// CancelAccountDeletionHandler handles POST /account/cancel-deletion
+func CancelAccountDeletionHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+
+	cancelled, err := CancelAccountDeletion(userID)
+	if err != nil {
+		log.Errorw("Account deletion cancel failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to cancel account deletion",
+			"error_code": "UPDATE_FAILED",
+		})
+	}
+	if !cancelled {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "No account deletion is pending",
+			"error_code": "NO_DELETION_PENDING",
+		})
+	}
+
+	log.Info("Account deletion cancelled")
+	recordAuditEvent(c, userID, models.AuditAccountDeletionCancelled, nil)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Account deletion cancelled",
+	})
+}
+
+// ExportAccountHandler handles GET /account/export
+// Streams a ZIP archive with all personal data of the user
+func ExportAccountHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+
+	archive, err := buildAccountExport(userID)
+	if err != nil {
+		log.Errorw("Account export failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to export account data",
+			"error_code": "EXPORT_FAILED",
+		})
+	}
+
+	filename := fmt.Sprintf("growth-tracker-export-%s.zip", time.Now().UTC().Format("2006-01-02"))
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+
+	log.Infow("Account data exported", "bytes", len(archive))
+	return c.Status(fiber.StatusOK).Send(archive)
+}
+
+// ==================== Purge Job ====================
+
+// PurgeDeletedAccounts permanently deletes accounts whose grace period has ended
+func PurgeDeletedAccounts(ctx context.Context) error {
+	cutoff := time.Now().Add(-AccountDeletionGracePeriod)
+	users, err := GetUsersDueForPurge(cutoff)
+	if err != nil {
+		return fmt.Errorf("failed to fetch accounts due for purge: %w", err)
+	}
+
+	for _, user := range users {
+		log := utils.LogWithUser(user.ID, user.Username)
+
+		purged, err := PurgeUser(user.ID, cutoff)
+		if err != nil {
+			log.Errorw("Account purge failed", "error", err)
+			continue
+		}
+		if !purged {
+			continue
+		}
+
+		// Only deleted once the purge went through, a cancellation racing it keeps the picture.
+		// A failure leaves an orphaned blob, logged with its URL for manual cleanup.
+		if err := deleteProfilePictureBlob(user.ProfilePic); err != nil {
+			log.Errorw("Failed to delete profile picture of purged account", "profile_pic", *user.ProfilePic, "error", err)
+		}
+
+		if redis := utils.GetRedis(); redis != nil {
+			if err := utils.RevokeAllSessions(ctx, user.ID); err != nil {
+				log.Warnw("Failed to revoke sessions of purged account", "error", err)
+			}
+		}
+		log.Info("Account purged")
+	}
+	return nil
+}
+
+// ==================== Helper Functions ====================
+
+// buildAccountExport collects the user's data and writes it into a ZIP archive
+func buildAccountExport(userID uint) ([]byte, error) {
+	db := utils.GetDB()
+
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+
+	var activities []models.Activity
+	if err := db.Where("user_id = ?", userID).Order("activity_date ASC, name ASC").Find(&activities).Error; err != nil {
+		return nil, err
+	}
+
+	var streaks []models.Streak
+	if err := db.Where("user_id = ?", userID).Order("activity_date ASC").Find(&streaks).Error; err != nil {
+		return nil, err
+	}
+
+	var tileConfigs []models.TileConfig
+	if err := db.Where("user_id = ?", userID).Limit(1).Find(&tileConfigs).Error; err != nil {
+		return nil, err
+	}
+
+	var categories []models.ActivityCategory
+	if err := db.Where("user_id = ?", userID).Order("name ASC").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+
+	exportActivities := make([]exportActivity, 0, len(activities))
+	activityRows := [][]string{{"id", "date", "name", "hours", "note", "created_at", "updated_at"}}
+	for _, a := range activities {
+		exportActivities = append(exportActivities, exportActivity{
+			ID:            a.ID,
+			Name:          string(a.Name),
+			DurationHours: a.DurationHours,
+			Date:          a.ActivityDate.Format("2006-01-02"),
+			Note:          a.Note,
+			CreatedAt:     a.CreatedAt,
+			UpdatedAt:     a.UpdatedAt,
+		})
+		note := ""
+		if a.Note != nil {
+			note = *a.Note
+		}
+		activityRows = append(activityRows, []string{
+			strconv.FormatUint(uint64(a.ID), 10),
+			a.ActivityDate.Format("2006-01-02"),
+			string(a.Name),
+			strconv.FormatFloat(float64(a.DurationHours), 'f', 2, 32),
+			note,
+			a.CreatedAt.UTC().Format(time.RFC3339),
+			a.UpdatedAt.UTC().Format(time.RFC3339),
+		})
+	}
+
+	exportStreaks := make([]StreakDTO, 0, len(streaks))
+	streakRows := [][]string{{"date", "current", "longest"}}
+	for _, s := range streaks {
+		exportStreaks = append(exportStreaks, ToStreakDTOs(s))
+		streakRows = append(streakRows, []string{
+			s.ActivityDate.Format("2006-01-02"),
+			strconv.Itoa(s.Current),
+			strconv.Itoa(s.Longest),
+		})
+	}
+
+	var tileConfig interface{}
+	if len(tileConfigs) > 0 {
+		tileConfig = tileConfigs[0]
+	}
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+
+	jsonFiles := []struct {
+		name string
+		data interface{}
+	}{
+		{"user.json", exportUser{
+			ID:                  user.ID,
+			Email:               user.Email,
+			EmailVerified:       user.EmailVerified,
+			Username:            user.Username,
+			ProfilePic:          user.ProfilePic,
+			IsPrivate:           user.IsPrivate,
+			Timezone:            user.Timezone,
+			TOTPEnabled:         user.TOTPEnabled,
+			DeletionRequestedAt: user.DeletionRequestedAt,
+			CreatedAt:           user.CreatedAt,
+			UpdatedAt:           user.UpdatedAt,
+		}},
+		{"activities.json", exportActivities},
+		{"streaks.json", exportStreaks},
+		{"tile_config.json", tileConfig},
+		{"activity_categories.json", ToCategoryDTOs(categories)},
+	}
+	for _, file := range jsonFiles {
+		if err := writeZipJSON(zw, file.name, file.data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writeZipCSV(zw, "activities.csv", activityRows); err != nil {
+		return nil, err
+	}
+	if err := writeZipCSV(zw, "streaks.csv", streakRows); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeZipJSON(zw *zip.Writer, name string, data interface{}) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(data)
+}
+
+func writeZipCSV(zw *zip.Writer, name string, rows [][]string) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+	return cw.Error()
+}
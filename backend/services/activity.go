@@ -1,19 +1,29 @@
 package services
 
 import (
+	"errors"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/aman1117/backend/models"
 	"github.com/aman1117/backend/utils"
 	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
+const maxActivityNoteLength = 500
+
+var errActivityNotFound = errors.New("activity not found")
+
 type ActivityRequest struct {
 	Username string              `json:"username"`
 	Activity models.ActivityName `json:"activity"`
 	Hours    float32             `json:"hours"`
 	Date     string              `json:"date"`
 	Note     *string             `json:"note,omitempty"`
+	Version  *uint               `json:"version,omitempty"` // see If-Match in version.go
 }
 
 type ActivityDTO struct {
@@ -22,6 +32,17 @@ type ActivityDTO struct {
 	DurationHours float32             `json:"hours"`
 	Date          string              `json:"date"`
 	Note          *string             `json:"note"`
+	Draft         bool                `json:"draft,omitempty"`
+	Version       uint                `json:"version"`
+
+	// Only set for entries logged as time intervals
+	Segments []ActivitySegmentDTO `json:"segments,omitempty"`
+}
+
+type UpdateActivityRequest struct {
+	Hours   *float32 `json:"hours,omitempty"`
+	Note    *string  `json:"note,omitempty"`    // "" clears the note
+	Version *uint    `json:"version,omitempty"` // see If-Match in version.go
 }
 
 type GetActivityRequest struct {
@@ -67,16 +88,26 @@ func CreateActivityHandler(c *fiber.Ctx) error {
 		})
 	}
 
-	if !models.ActivityName(body.Activity).IsValid() {
+	db := utils.GetDB()
+	userID := c.Locals("user_id").(uint)
+
+	if err := checkActivityName(db, userID, body.Activity); err != nil {
+		return activityNameErrorResponse(c, err)
+	}
+
+	loc, err := GetUserLocation(userID)
+	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"success":    false,
-			"error":      "Invalid activity name",
-			"error_code": "INVALID_ACTIVITY",
+			"error":      "Failed to find user",
+			"error_code": "USER_NOT_FOUND",
 		})
 	}
 
+	// Dates are calendar days in the user's timezone so AddStreak compares
+	// against the user's own "today"
 	const layout = "2006-01-02"
-	date, err := time.Parse(layout, body.Date)
+	date, err := time.ParseInLocation(layout, body.Date, loc)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"success":    false,
@@ -84,113 +115,482 @@ func CreateActivityHandler(c *fiber.Ctx) error {
 			"error_code": "INVALID_DATE",
 		})
 	}
-	date = date.Truncate(24 * time.Hour)
 
-	db := utils.GetDB()
-	userID := c.Locals("user_id").(uint)
+	expected, err := expectedVersion(c, body.Version)
+	if err != nil {
+		return invalidVersionResponse(c)
+	}
 
-	var dayActivities []models.Activity
-	result := db.
-		Where("user_id = ? AND activity_date = ?", userID, date).
-		Find(&dayActivities)
-	if result.Error != nil {
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+
+	var (
+		saved       models.Activity
+		existing    *models.Activity
+		fetchFailed bool
+	)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Serializes with the other entry writes so the version check cannot race
+		if err := lockUserForUpdate(tx, userID); err != nil {
+			fetchFailed = true
+			return err
+		}
+
+		var dayActivities []models.Activity
+		if err := tx.
+			Where("user_id = ? AND activity_date = ?", userID, date).
+			Find(&dayActivities).Error; err != nil {
+			fetchFailed = true
+			return err
+		}
+
+		var (
+			totalHours      float32
+			activityNameVal = models.ActivityName(body.Activity)
+		)
+
+		for i := range dayActivities {
+			a := &dayActivities[i]
+			totalHours += a.DurationHours
+
+			if a.Name == activityNameVal {
+				existing = a
+			}
+		}
+
+		if err := checkActivityVersion(expected, existing); err != nil {
+			return err
+		}
+
+		var newTotal float32
+		if existing != nil {
+			newTotal = totalHours - existing.DurationHours + body.Hours
+		} else {
+			newTotal = totalHours + body.Hours
+		}
+
+		if newTotal > 24 {
+			return errDayHoursExceeded
+		}
+
+		if existing == nil {
+			saved = models.Activity{
+				UserID:        userID,
+				Name:          activityNameVal,
+				DurationHours: body.Hours,
+				ActivityDate:  date,
+				Note:          body.Note,
+			}
+			return tx.Create(&saved).Error
+		}
+
+		segmented, err := activityHasSegments(tx, existing.ID)
+		if err != nil {
+			fetchFailed = true
+			return err
+		}
+		if segmented {
+			return errActivityHasSegments
+		}
+
+		saved = *existing
+		saved.DurationHours = body.Hours
+		saved.Note = body.Note
+		saved.Draft = false
+		return tx.Save(&saved).Error
+	})
+
+	switch {
+	case errors.Is(err, errVersionConflict):
+		return activityConflictResponse(c, log, existing)
+	case errors.Is(err, errDayHoursExceeded):
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Total hours cannot be more than 24",
+			"error_code": "HOURS_EXCEEDED",
+		})
+	case errors.Is(err, errActivityHasSegments):
+		return activityHasSegmentsResponse(c)
+	case err != nil && fetchFailed:
+		log.Errorw("Failed to find activities", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"success":    false,
 			"error":      "Failed to find activities",
 			"error_code": "FETCH_FAILED",
 		})
+	case err != nil && existing != nil:
+		log.Errorw("Failed to update activity", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to update activity",
+			"error_code": "UPDATE_FAILED",
+		})
+	case err != nil:
+		log.Errorw("Failed to create activity", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to create activity",
+			"error_code": "CREATE_FAILED",
+		})
 	}
 
-	var (
-		totalHours      float32
-		existing        *models.Activity
-		activityNameVal = models.ActivityName(body.Activity)
-	)
+	if err := SyncDayStreak(userID, date); err != nil {
+		log.Errorw("Failed to add streak", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      err.Error(),
+			"error_code": "STREAK_ERROR",
+		})
+	}
+	if existing != nil {
+		log.Debugw("Activity updated", "activity", body.Activity, "hours", body.Hours, "date", body.Date, "version", saved.Version)
+	} else {
+		log.Debugw("Activity created", "activity", body.Activity, "hours", body.Hours, "date", body.Date)
+	}
 
-	for i := range dayActivities {
-		a := &dayActivities[i]
-		totalHours += a.DurationHours
+	c.Set(fiber.HeaderETag, versionETag(saved.Version))
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Activity updated successfully",
+		"data":    ToActivityDTOs([]models.Activity{saved}, true)[0],
+	})
+}
 
-		if a.Name == activityNameVal {
-			existing = a
-		}
+// UpdateActivityHandler handles PATCH /activities/:id
+// Changes the hours and/or note of one entry, the day must stay within 24 hours
+func UpdateActivityHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
 	}
 
-	var newTotal float32
-	if existing != nil {
-		newTotal = totalHours - existing.DurationHours + body.Hours
-	} else {
-		newTotal = totalHours + body.Hours
+	var body UpdateActivityRequest
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid request body",
+			"error_code": "INVALID_REQUEST",
+		})
 	}
 
-	if newTotal > 24 {
+	if body.Hours == nil && body.Note == nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"success":    false,
-			"error":      "Total hours cannot be more than 24",
-			"error_code": "HOURS_EXCEEDED",
+			"error":      "Nothing to update, send hours and/or note",
+			"error_code": "MISSING_FIELDS",
+		})
+	}
+
+	if body.Hours != nil && (*body.Hours < 0 || *body.Hours > 24) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Hours must be between 0 and 24",
+			"error_code": "INVALID_HOURS",
+		})
+	}
+
+	if body.Note != nil && utf8.RuneCountInString(strings.TrimSpace(*body.Note)) > maxActivityNoteLength {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Note cannot be longer than 500 characters",
+			"error_code": "NOTE_TOO_LONG",
 		})
 	}
 
+	expected, err := expectedVersion(c, body.Version)
+	if err != nil {
+		return invalidVersionResponse(c)
+	}
+
+	activity, date, err := getOwnedActivity(c, userID)
+	if err != nil || activity == nil {
+		return err
+	}
+
 	traceID, _ := c.Locals("trace_id").(string)
 	log := utils.LogWithContext(traceID, userID)
 
-	if existing != nil {
-		existing.DurationHours = body.Hours
-		existing.Note = body.Note
-		if err := db.Save(existing).Error; err != nil {
-			log.Errorw("Failed to update activity", "error", err)
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"success":    false,
-				"error":      "Failed to update activity",
-				"error_code": "UPDATE_FAILED",
-			})
-		}
-
-		err := AddStreak(userID, date, false)
+	var current *models.Activity
+	err = utils.GetDB().Transaction(func(tx *gorm.DB) error {
+		var err error
+		current, err = lockActivityForWrite(tx, userID, activity.ID, expected)
 		if err != nil {
-			log.Errorw("Failed to add streak", "error", err)
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"success":    false,
-				"error":      err.Error(),
-				"error_code": "STREAK_ERROR",
-			})
+			return err
 		}
-		log.Debugw("Activity updated", "activity", body.Activity, "hours", body.Hours, "date", body.Date)
-	} else {
-		activity := models.Activity{
-			UserID:        userID,
-			Name:          activityNameVal,
-			DurationHours: body.Hours,
-			ActivityDate:  date,
-			Note:          body.Note,
+		activity = current
+
+		if body.Hours != nil {
+			segmented, err := activityHasSegments(tx, activity.ID)
+			if err != nil {
+				return err
+			}
+			if segmented {
+				return errActivityHasSegments
+			}
+
+			var otherHours float32
+			if err := tx.Model(&models.Activity{}).
+				Where("user_id = ? AND activity_date = ? AND id <> ?", userID, date, activity.ID).
+				Select("COALESCE(SUM(duration_hours), 0)").
+				Scan(&otherHours).Error; err != nil {
+				return err
+			}
+			if otherHours+*body.Hours > 24 {
+				return errDayHoursExceeded
+			}
+			activity.DurationHours = *body.Hours
 		}
-		if err := db.Create(&activity).Error; err != nil {
-			log.Errorw("Failed to create activity", "error", err)
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"success":    false,
-				"error":      "Failed to create activity",
-				"error_code": "CREATE_FAILED",
-			})
+
+		if body.Note != nil {
+			note := strings.TrimSpace(*body.Note)
+			if note == "" {
+				activity.Note = nil
+			} else {
+				activity.Note = &note
+			}
 		}
+		// Editing a draft confirms it
+		activity.Draft = false
+		return tx.Save(activity).Error
+	})
 
-		err := AddStreak(userID, date, false)
+	switch {
+	case errors.Is(err, errVersionConflict):
+		return activityConflictResponse(c, log, current)
+	case errors.Is(err, errActivityNotFound):
+		return activityNotFoundResponse(c)
+	case errors.Is(err, errActivityHasSegments):
+		return activityHasSegmentsResponse(c)
+	case errors.Is(err, errDayHoursExceeded):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Total hours cannot be more than 24",
+			"error_code": "HOURS_EXCEEDED",
+		})
+	case err != nil:
+		log.Errorw("Failed to update activity", "activity_id", activity.ID, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to update activity",
+			"error_code": "UPDATE_FAILED",
+		})
+	}
+
+	return activityDayResponse(c, userID, date, "Activity updated successfully", activity)
+}
+
+// DeleteActivityHandler handles DELETE /activities/:id
+func DeleteActivityHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	// A delete has no body, only If-Match can make it conditional
+	expected, err := expectedVersion(c, nil)
+	if err != nil {
+		return invalidVersionResponse(c)
+	}
+
+	activity, date, err := getOwnedActivity(c, userID)
+	if err != nil || activity == nil {
+		return err
+	}
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+
+	var current *models.Activity
+	err = utils.GetDB().Transaction(func(tx *gorm.DB) error {
+		var err error
+		current, err = lockActivityForWrite(tx, userID, activity.ID, expected)
 		if err != nil {
-			log.Errorw("Failed to add streak", "error", err)
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"success":    false,
-				"error":      err.Error(),
-				"error_code": "STREAK_ERROR",
-			})
+			return err
 		}
-		log.Debugw("Activity created", "activity", body.Activity, "hours", body.Hours, "date", body.Date)
+		return tx.Delete(current).Error
+	})
+	switch {
+	case errors.Is(err, errVersionConflict):
+		return activityConflictResponse(c, log, current)
+	case errors.Is(err, errActivityNotFound):
+		return activityNotFoundResponse(c)
+	case err != nil:
+		log.Errorw("Failed to delete activity", "activity_id", activity.ID, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to delete activity",
+			"error_code": "DELETE_FAILED",
+		})
 	}
 
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"success": true,
-		"message": "Activity updated successfully",
+	return activityDayResponse(c, userID, date, "Activity deleted", nil)
+}
+
+// activityDayResponse re-syncs the day's streak after a change to one of its
+// entries and answers with the day's new total
+func activityDayResponse(c *fiber.Ctx, userID uint, date time.Time, message string, activity *models.Activity) error {
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+
+	if err := SyncDayStreak(userID, date); err != nil {
+		log.Errorw("Failed to sync streak", "date", date.Format("2006-01-02"), "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      err.Error(),
+			"error_code": "STREAK_ERROR",
+		})
+	}
+
+	total, err := GetDayTotalHours(userID, date, 0)
+	if err != nil {
+		log.Errorw("Failed to sum day hours", "date", date.Format("2006-01-02"), "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to find activities",
+			"error_code": "FETCH_FAILED",
+		})
+	}
+
+	log.Debugw(message, "date", date.Format("2006-01-02"), "day_total_hours", total)
+	response := fiber.Map{
+		"success":         true,
+		"message":         message,
+		"date":            date.Format("2006-01-02"),
+		"day_total_hours": total,
+	}
+	if activity != nil {
+		data := ToActivityDTOs([]models.Activity{*activity}, true)
+		if err := attachSegments(data); err != nil {
+			log.Warnw("Failed to load segments", "activity_id", activity.ID, "error", err)
+		}
+		response["data"] = data[0]
+		c.Set(fiber.HeaderETag, versionETag(activity.Version))
+	}
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+// lockActivityForWrite takes the user row lock and re-reads the entry, checking it
+// against the version the write is based on (nil = unconditional). The entry is
+// returned with errVersionConflict too, nil if it is gone.
+func lockActivityForWrite(tx *gorm.DB, userID, activityID uint, expected *uint) (*models.Activity, error) {
+	if err := lockUserForUpdate(tx, userID); err != nil {
+		return nil, err
+	}
+
+	var activity models.Activity
+	result := tx.Where("id = ? AND user_id = ?", activityID, userID).Limit(1).Find(&activity)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		if expected != nil {
+			return nil, errVersionConflict
+		}
+		return nil, errActivityNotFound
+	}
+	if err := checkActivityVersion(expected, &activity); err != nil {
+		return &activity, err
+	}
+	return &activity, nil
+}
+
+// checkActivityVersion compares the version a write is based on with the entry it
+// applies to, a nil entry has version 0
+func checkActivityVersion(expected *uint, activity *models.Activity) error {
+	if expected == nil {
+		return nil
+	}
+	var current uint
+	if activity != nil {
+		current = activity.Version
+	}
+	if *expected != current {
+		return errVersionConflict
+	}
+	return nil
+}
+
+// activityConflictResponse answers a stale write with the entry as it is now
+func activityConflictResponse(c *fiber.Ctx, log *zap.SugaredLogger, current *models.Activity) error {
+	if current == nil {
+		return versionConflictResponse(c, nil)
+	}
+	dtos := ToActivityDTOs([]models.Activity{*current}, true)
+	if err := attachSegments(dtos); err != nil {
+		log.Warnw("Failed to load segments", "activity_id", current.ID, "error", err)
+	}
+	return versionConflictResponse(c, &dtos[0])
+}
+
+// activityNotFoundResponse answers a write to an entry that no longer exists
+func activityNotFoundResponse(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+		"success":    false,
+		"error":      "Activity not found",
+		"error_code": "ACTIVITY_NOT_FOUND",
 	})
 }
 
+// activityHasSegmentsResponse rejects setting hours directly on a segmented entry
+func activityHasSegmentsResponse(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+		"success":    false,
+		"error":      "This activity is logged as time segments, its hours are derived from them",
+		"error_code": "ACTIVITY_HAS_SEGMENTS",
+	})
+}
+
+// getOwnedActivity loads the activity from the :id route param together with its
+// date as a calendar day in the owner's timezone.
+// If it returns a nil activity, the error response has already been written.
+func getOwnedActivity(c *fiber.Ctx, userID uint) (*models.Activity, time.Time, error) {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return nil, time.Time{}, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid activity id",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	var activity models.Activity
+	result := utils.GetDB().Where("id = ? AND user_id = ?", id, userID).Limit(1).Find(&activity)
+	if result.Error != nil {
+		return nil, time.Time{}, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to find activity",
+			"error_code": "FETCH_FAILED",
+		})
+	}
+	if result.RowsAffected == 0 {
+		return nil, time.Time{}, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Activity not found",
+			"error_code": "ACTIVITY_NOT_FOUND",
+		})
+	}
+
+	loc, err := GetUserLocation(userID)
+	if err != nil {
+		return nil, time.Time{}, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to find user",
+			"error_code": "USER_NOT_FOUND",
+		})
+	}
+	d := activity.ActivityDate
+	date := time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, loc)
+	return &activity, date, nil
+}
+
 func GetActivityHandler(c *fiber.Ctx) error {
 	var body GetActivityRequest
 	if err := c.BodyParser(&body); err != nil {
@@ -258,14 +658,21 @@ func GetActivityHandler(c *fiber.Ctx) error {
 		})
 	}
 
+	// Only include notes and drafts if user is viewing their own activities
+	isOwnProfile := user.ID == currentUserID
+
 	// Fetch activities using activity_date (DATE column)
 	var activities []models.Activity
-	if err := db.Where(
+	query := db.Where(
 		"user_id = ? AND activity_date BETWEEN ? AND ?",
 		user.ID,
 		startDate,
 		endDate,
-	).Find(&activities).Error; err != nil {
+	)
+	if !isOwnProfile {
+		query = query.Where("draft = ?", false)
+	}
+	if err := query.Find(&activities).Error; err != nil {
 		utils.Sugar.Errorw("Activity fetch failed", "user_id", user.ID, "username", body.Username, "error", err)
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"success":    false,
@@ -274,11 +681,18 @@ func GetActivityHandler(c *fiber.Ctx) error {
 		})
 	}
 
-	// Only include notes if user is viewing their own activities
-	isOwnProfile := user.ID == currentUserID
+	data := ToActivityDTOs(activities, isOwnProfile)
+	if err := attachSegments(data); err != nil {
+		utils.Sugar.Errorw("Segment fetch failed", "user_id", user.ID, "username", body.Username, "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to find activities",
+			"error_code": "FETCH_FAILED",
+		})
+	}
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"success": true,
-		"data":    ToActivityDTOs(activities, isOwnProfile),
+		"data":    data,
 	})
 }
 
@@ -290,6 +704,8 @@ func ToActivityDTOs(in []models.Activity, includeNotes bool) []ActivityDTO {
 			Name:          models.ActivityName(a.Name),
 			DurationHours: a.DurationHours,
 			Date:          a.ActivityDate.Format("2006-01-02"),
+			Draft:         a.Draft,
+			Version:       a.Version,
 		}
 		// Only include notes for own profile
 		if includeNotes {
@@ -316,7 +732,8 @@ func GetUsersHandler(c *fiber.Ctx) error {
 	db := utils.GetDB()
 	users := []models.User{}
 	// find user by username with ILIKE (include private users - they'll be marked as private in response)
-	result := db.Where("username ILIKE ?", "%"+body.Username+"%").Find(&users)
+	// accounts pending deletion or disabled by an admin are hidden
+	result := db.Where("username ILIKE ? AND deletion_requested_at IS NULL AND disabled_at IS NULL", "%"+body.Username+"%").Find(&users)
 	if result.Error != nil {
 		utils.LogWithContext(traceID, currentUserID).Errorw("User search failed", "query", body.Username, "error", result.Error)
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
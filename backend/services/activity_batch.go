@@ -0,0 +1,385 @@
+/*
+#Plan: Batch Activity Logging
+
+Endpoint: POST /activities/batch
+- { items: [{ date, activity, hours, note? }, ...] }, at most 200 items
+- Each item works like /create-activity: it sets the hours and note of the
+  (date, activity) entry, creating it if needed
+- The batch is all or nothing:
+  1. Every item is validated on its own (date, activity, hours, note, duplicates)
+  2. Day totals are checked against 24 hours with all items of the day applied
+  3. Everything is written in one transaction that holds the user row lock, so
+     it cannot race with segment or timer writes
+- Any failure answers 400 with "errors": [{ index, error, error_code }], one per
+  failing item, and nothing is saved
+- Streaks are synced once per affected date, oldest first, after the commit
+- Response: the saved entries and the new total of every affected day
+*/
+
+package services
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aman1117/backend/models"
+	"github.com/aman1117/backend/utils"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const maxBatchItems = 200
+
+var errBatchRejected = errors.New("batch rejected")
+
+// ==================== Request/Response Types ====================
+
+type BatchActivityItem struct {
+	Date     string              `json:"date"`
+	Activity models.ActivityName `json:"activity"`
+	Hours    float32             `json:"hours"`
+	Note     *string             `json:"note,omitempty"`
+}
+
+type BatchActivityRequest struct {
+	Items []BatchActivityItem `json:"items"`
+}
+
+type BatchItemError struct {
+	Index     int    `json:"index"`
+	Error     string `json:"error"`
+	ErrorCode string `json:"error_code"`
+}
+
+type DayTotalDTO struct {
+	Date       string  `json:"date"`
+	TotalHours float32 `json:"total_hours"`
+}
+
+// batchItem is a validated item with its date resolved in the user's timezone
+type batchItem struct {
+	index int
+	date  time.Time
+	name  models.ActivityName
+	hours float32
+	note  *string
+}
+
+// ==================== Handlers ====================
+
+// BatchActivityHandler handles POST /activities/batch
+func BatchActivityHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	var body BatchActivityRequest
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid request body",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	if len(body.Items) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "items cannot be empty",
+			"error_code": "MISSING_FIELDS",
+		})
+	}
+	if len(body.Items) > maxBatchItems {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "A batch cannot have more than 200 items",
+			"error_code": "BATCH_TOO_LARGE",
+		})
+	}
+
+	loc, err := GetUserLocation(userID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to find user",
+			"error_code": "USER_NOT_FOUND",
+		})
+	}
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+	db := utils.GetDB()
+
+	items, itemErrors, err := validateBatchItems(db, userID, body.Items, loc)
+	if err != nil {
+		log.Errorw("Failed to validate batch", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to validate activity",
+			"error_code": "FETCH_FAILED",
+		})
+	}
+	if len(itemErrors) > 0 {
+		return batchRejectedResponse(c, itemErrors)
+	}
+
+	var saved []models.Activity
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := lockUserForUpdate(tx, userID); err != nil {
+			return err
+		}
+
+		var writeErrors []BatchItemError
+		saved, writeErrors, err = applyBatchItems(tx, userID, items)
+		if err != nil {
+			return err
+		}
+		if len(writeErrors) > 0 {
+			itemErrors = writeErrors
+			return errBatchRejected
+		}
+		return nil
+	})
+	if errors.Is(err, errBatchRejected) {
+		return batchRejectedResponse(c, itemErrors)
+	}
+	if err != nil {
+		log.Errorw("Failed to save batch", "items", len(items), "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to save activities",
+			"error_code": "CREATE_FAILED",
+		})
+	}
+
+	days, err := dayTotalsAfterWrite(log, userID, batchDates(items), true)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to find activities",
+			"error_code": "FETCH_FAILED",
+		})
+	}
+
+	log.Debugw("Activity batch saved", "items", len(saved), "days", len(days))
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Activities saved successfully",
+		"data": fiber.Map{
+			"activities": ToActivityDTOs(saved, true),
+			"days":       days,
+		},
+	})
+}
+
+// ==================== Helpers ====================
+
+// validateBatchItems checks every item on its own and resolves its date.
+// The error is only set when validation itself failed.
+func validateBatchItems(db *gorm.DB, userID uint, raw []BatchActivityItem, loc *time.Location) ([]batchItem, []BatchItemError, error) {
+	const layout = "2006-01-02"
+
+	var (
+		items      = make([]batchItem, 0, len(raw))
+		itemErrors []BatchItemError
+		names      = map[models.ActivityName]error{}
+		seen       = map[string]int{}
+	)
+	for i, r := range raw {
+		fail := func(message, code string) {
+			itemErrors = append(itemErrors, BatchItemError{Index: i, Error: message, ErrorCode: code})
+		}
+
+		if r.Activity == "" || r.Date == "" {
+			fail("date and activity are required", "MISSING_FIELDS")
+			continue
+		}
+
+		date, err := time.ParseInLocation(layout, r.Date, loc)
+		if err != nil {
+			fail("Invalid date format, use YYYY-MM-DD", "INVALID_DATE")
+			continue
+		}
+
+		if r.Hours < 0 || r.Hours > 24 {
+			fail("Hours must be between 0 and 24", "INVALID_HOURS")
+			continue
+		}
+
+		var note *string
+		if r.Note != nil {
+			trimmed := strings.TrimSpace(*r.Note)
+			if utf8.RuneCountInString(trimmed) > maxActivityNoteLength {
+				fail("Note cannot be longer than 500 characters", "NOTE_TOO_LONG")
+				continue
+			}
+			if trimmed != "" {
+				note = &trimmed
+			}
+		}
+
+		nameErr, checked := names[r.Activity]
+		if !checked {
+			nameErr = checkActivityName(db, userID, r.Activity)
+			names[r.Activity] = nameErr
+		}
+		switch {
+		case errors.Is(nameErr, errInvalidActivity):
+			fail("Invalid activity name", "INVALID_ACTIVITY")
+			continue
+		case errors.Is(nameErr, errCategoryArchived):
+			fail("This activity category is archived", "CATEGORY_ARCHIVED")
+			continue
+		case nameErr != nil:
+			return nil, nil, nameErr
+		}
+
+		key := r.Date + "/" + string(r.Activity)
+		if first, ok := seen[key]; ok {
+			fail("Duplicate of item "+strconv.Itoa(first)+", an activity can only appear once per day", "DUPLICATE_ITEM")
+			continue
+		}
+		seen[key] = i
+
+		items = append(items, batchItem{
+			index: i,
+			date:  date,
+			name:  r.Activity,
+			hours: r.Hours,
+			note:  note,
+		})
+	}
+	return items, itemErrors, nil
+}
+
+// applyBatchItems writes the items inside tx after checking segmented entries
+// and the 24 hour limit of every day with all of the batch applied
+func applyBatchItems(tx *gorm.DB, userID uint, items []batchItem) ([]models.Activity, []BatchItemError, error) {
+	dates := batchDates(items)
+
+	var existing []models.Activity
+	if err := tx.Where("user_id = ? AND activity_date IN ?", userID, dates).Find(&existing).Error; err != nil {
+		return nil, nil, err
+	}
+
+	dayKey := func(date time.Time, name models.ActivityName) string {
+		return date.Format("2006-01-02") + "/" + string(name)
+	}
+	byKey := map[string]*models.Activity{}
+	totals := map[string]float32{}
+	for i := range existing {
+		a := &existing[i]
+		byKey[dayKey(a.ActivityDate, a.Name)] = a
+		totals[a.ActivityDate.Format("2006-01-02")] += a.DurationHours
+	}
+
+	var itemErrors []BatchItemError
+	for _, item := range items {
+		day := item.date.Format("2006-01-02")
+		if a := byKey[dayKey(item.date, item.name)]; a != nil {
+			segmented, err := activityHasSegments(tx, a.ID)
+			if err != nil {
+				return nil, nil, err
+			}
+			if segmented {
+				itemErrors = append(itemErrors, BatchItemError{
+					Index:     item.index,
+					Error:     "This activity is logged as time segments, its hours are derived from them",
+					ErrorCode: "ACTIVITY_HAS_SEGMENTS",
+				})
+				continue
+			}
+			totals[day] -= a.DurationHours
+		}
+		totals[day] += item.hours
+	}
+
+	for _, item := range items {
+		if totals[item.date.Format("2006-01-02")] > 24 {
+			itemErrors = append(itemErrors, BatchItemError{
+				Index:     item.index,
+				Error:     "Total hours of " + item.date.Format("2006-01-02") + " cannot be more than 24",
+				ErrorCode: "HOURS_EXCEEDED",
+			})
+		}
+	}
+	if len(itemErrors) > 0 {
+		sort.Slice(itemErrors, func(i, j int) bool { return itemErrors[i].Index < itemErrors[j].Index })
+		return nil, itemErrors, nil
+	}
+
+	saved := make([]models.Activity, 0, len(items))
+	for _, item := range items {
+		activity := byKey[dayKey(item.date, item.name)]
+		if activity == nil {
+			activity = &models.Activity{
+				UserID:       userID,
+				Name:         item.name,
+				ActivityDate: item.date,
+			}
+		}
+		activity.DurationHours = item.hours
+		activity.Note = item.note
+		activity.Draft = false
+		if err := tx.Save(activity).Error; err != nil {
+			return nil, nil, err
+		}
+		saved = append(saved, *activity)
+	}
+	return saved, nil, nil
+}
+
+// dayTotalsAfterWrite syncs the streak of every date after a committed multi-day
+// write and returns the new day totals. A failed sync is only logged since the
+// entries are already saved, the next write of the day fixes it.
+func dayTotalsAfterWrite(log *zap.SugaredLogger, userID uint, dates []time.Time, syncStreaks bool) ([]DayTotalDTO, error) {
+	days := make([]DayTotalDTO, 0, len(dates))
+	for _, date := range dates {
+		if syncStreaks {
+			if err := SyncDayStreak(userID, date); err != nil {
+				log.Errorw("Failed to sync streak", "date", date.Format("2006-01-02"), "error", err)
+			}
+		}
+		total, err := GetDayTotalHours(userID, date, 0)
+		if err != nil {
+			log.Errorw("Failed to sum day hours", "date", date.Format("2006-01-02"), "error", err)
+			return nil, err
+		}
+		days = append(days, DayTotalDTO{Date: date.Format("2006-01-02"), TotalHours: total})
+	}
+	return days, nil
+}
+
+// batchDates returns the distinct dates of items, oldest first
+func batchDates(items []batchItem) []time.Time {
+	seen := map[string]bool{}
+	var dates []time.Time
+	for _, item := range items {
+		day := item.date.Format("2006-01-02")
+		if !seen[day] {
+			seen[day] = true
+			dates = append(dates, item.date)
+		}
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+	return dates
+}
+
+func batchRejectedResponse(c *fiber.Ctx, itemErrors []BatchItemError) error {
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		"success":    false,
+		"error":      "Some items are invalid, nothing was saved",
+		"error_code": "BATCH_INVALID",
+		"errors":     itemErrors,
+	})
+}
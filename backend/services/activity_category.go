@@ -0,0 +1,410 @@
+package services
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/aman1117/backend/models"
+	"github.com/aman1117/backend/utils"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const maxCategoriesPerUser = 50
+
+var (
+	errInvalidActivity  = errors.New("invalid activity name")
+	errCategoryArchived = errors.New("activity category is archived")
+)
+
+type CreateCategoryRequest struct {
+	Name    string `json:"name"`
+	Slug    string `json:"slug"`
+	Color   string `json:"color"`
+	IconKey string `json:"icon_key"`
+}
+
+type UpdateCategoryRequest struct {
+	Name     *string `json:"name"`
+	Color    *string `json:"color"`
+	IconKey  *string `json:"icon_key"`
+	Archived *bool   `json:"archived"`
+}
+
+type CategoryDTO struct {
+	ID       uint   `json:"id"`
+	Name     string `json:"name"`
+	Slug     string `json:"slug"`
+	Color    string `json:"color"`
+	IconKey  string `json:"icon_key"`
+	Archived bool   `json:"archived"`
+}
+
+// checkActivityName verifies that name can receive new entries for userID:
+// it must be a built-in activity or a non-archived category the user owns.
+func checkActivityName(db *gorm.DB, userID uint, name models.ActivityName) error {
+	if name.IsValid() {
+		return nil
+	}
+
+	category, err := models.FindUserCategory(db, userID, name)
+	if err != nil {
+		return err
+	}
+	if category == nil {
+		return errInvalidActivity
+	}
+	if category.Archived {
+		return errCategoryArchived
+	}
+	return nil
+}
+
+// activityNameErrorResponse writes the response for an error returned by checkActivityName
+func activityNameErrorResponse(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, errInvalidActivity):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid activity name",
+			"error_code": "INVALID_ACTIVITY",
+		})
+	case errors.Is(err, errCategoryArchived):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "This activity category is archived",
+			"error_code": "CATEGORY_ARCHIVED",
+		})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to validate activity",
+			"error_code": "FETCH_FAILED",
+		})
+	}
+}
+
+// GetCategoriesHandler - GET /activity-categories
+func GetCategoriesHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	db := utils.GetDB()
+	var categories []models.ActivityCategory
+	if err := db.Where("user_id = ?", userID).Order("created_at ASC").Find(&categories).Error; err != nil {
+		traceID, _ := c.Locals("trace_id").(string)
+		utils.LogWithContext(traceID, userID).Errorw("Category fetch failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to fetch categories",
+			"error_code": "FETCH_FAILED",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success":  true,
+		"built_in": models.ActivityNames,
+		"data":     ToCategoryDTOs(categories),
+	})
+}
+
+// CreateCategoryHandler - POST /activity-categories
+func CreateCategoryHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	var body CreateCategoryRequest
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid request body",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" || body.Color == "" || body.IconKey == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Name, color and icon_key are required",
+			"error_code": "MISSING_FIELDS",
+		})
+	}
+
+	slug := strings.TrimSpace(body.Slug)
+	if slug == "" {
+		slug = models.SlugifyCategoryName(body.Name)
+	}
+
+	category := models.ActivityCategory{
+		UserID:  userID,
+		Name:    body.Name,
+		Slug:    slug,
+		Color:   body.Color,
+		IconKey: strings.TrimSpace(body.IconKey),
+	}
+	if err := category.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      err.Error(),
+			"error_code": "INVALID_CATEGORY",
+		})
+	}
+
+	db := utils.GetDB()
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+
+	var count int64
+	if err := db.Model(&models.ActivityCategory{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		log.Errorw("Category count failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to create category",
+			"error_code": "CREATE_FAILED",
+		})
+	}
+	if count >= maxCategoriesPerUser {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Category limit reached",
+			"error_code": "CATEGORY_LIMIT_REACHED",
+		})
+	}
+
+	existing, err := models.FindUserCategory(db, userID, models.ActivityName(slug))
+	if err != nil {
+		log.Errorw("Category lookup failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to create category",
+			"error_code": "CREATE_FAILED",
+		})
+	}
+	if existing != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"success":    false,
+			"error":      "A category with this slug already exists",
+			"error_code": "CATEGORY_EXISTS",
+		})
+	}
+
+	if err := db.Create(&category).Error; err != nil {
+		log.Errorw("Category create failed", "slug", slug, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to create category",
+			"error_code": "CREATE_FAILED",
+		})
+	}
+
+	log.Infow("Category created", "slug", slug)
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"data":    ToCategoryDTO(category),
+	})
+}
+
+// UpdateCategoryHandler - PATCH /activity-categories/:id
+// The slug is immutable since existing activities reference it.
+func UpdateCategoryHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	var body UpdateCategoryRequest
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid request body",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	category, err := getOwnedCategory(c, userID)
+	if err != nil || category == nil {
+		return err
+	}
+
+	if body.Name != nil {
+		category.Name = strings.TrimSpace(*body.Name)
+	}
+	if body.Color != nil {
+		category.Color = *body.Color
+	}
+	if body.IconKey != nil {
+		category.IconKey = strings.TrimSpace(*body.IconKey)
+	}
+	if body.Archived != nil {
+		category.Archived = *body.Archived
+	}
+
+	if err := category.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      err.Error(),
+			"error_code": "INVALID_CATEGORY",
+		})
+	}
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+	if err := utils.GetDB().Save(category).Error; err != nil {
+		log.Errorw("Category update failed", "category_id", category.ID, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to update category",
+			"error_code": "UPDATE_FAILED",
+		})
+	}
+
+	log.Infow("Category updated", "category_id", category.ID, "archived", category.Archived)
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    ToCategoryDTO(*category),
+	})
+}
+
+// DeleteCategoryHandler - DELETE /activity-categories/:id
+// Categories that already have entries must be archived instead so their history stays readable.
+// A category with a running timer cannot be deleted until the timer is stopped or discarded.
+func DeleteCategoryHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	category, err := getOwnedCategory(c, userID)
+	if err != nil || category == nil {
+		return err
+	}
+
+	db := utils.GetDB()
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+
+	var entries int64
+	if err := db.Model(&models.Activity{}).
+		Where("user_id = ? AND name = ?", userID, category.Slug).
+		Count(&entries).Error; err != nil {
+		log.Errorw("Category usage check failed", "category_id", category.ID, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to delete category",
+			"error_code": "DELETE_FAILED",
+		})
+	}
+	if entries > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Category has logged activities, archive it instead",
+			"error_code": "CATEGORY_IN_USE",
+		})
+	}
+
+	// The timer's entries are only written on stop, which would fail without the category
+	var timers int64
+	if err := db.Model(&models.ActivityTimer{}).
+		Where("user_id = ? AND name = ?", userID, category.Slug).
+		Count(&timers).Error; err != nil {
+		log.Errorw("Category timer check failed", "category_id", category.ID, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to delete category",
+			"error_code": "DELETE_FAILED",
+		})
+	}
+	if timers > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Category has a running timer, stop or discard it first",
+			"error_code": "CATEGORY_IN_USE",
+		})
+	}
+
+	if err := db.Delete(category).Error; err != nil {
+		log.Errorw("Category delete failed", "category_id", category.ID, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to delete category",
+			"error_code": "DELETE_FAILED",
+		})
+	}
+
+	log.Infow("Category deleted", "category_id", category.ID)
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Category deleted",
+	})
+}
+
+// getOwnedCategory loads the category from the :id route param.
+// If it returns a nil category, the error response has already been written.
+func getOwnedCategory(c *fiber.Ctx, userID uint) (*models.ActivityCategory, error) {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid category id",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	var category models.ActivityCategory
+	result := utils.GetDB().Where("id = ? AND user_id = ?", id, userID).Limit(1).Find(&category)
+	if result.Error != nil {
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to find category",
+			"error_code": "FETCH_FAILED",
+		})
+	}
+	if result.RowsAffected == 0 {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Category not found",
+			"error_code": "CATEGORY_NOT_FOUND",
+		})
+	}
+	return &category, nil
+}
+
+func ToCategoryDTO(in models.ActivityCategory) CategoryDTO {
+	return CategoryDTO{
+		ID:       in.ID,
+		Name:     in.Name,
+		Slug:     in.Slug,
+		Color:    in.Color,
+		IconKey:  in.IconKey,
+		Archived: in.Archived,
+	}
+}
+
+func ToCategoryDTOs(in []models.ActivityCategory) []CategoryDTO {
+	out := make([]CategoryDTO, 0, len(in))
+	for _, category := range in {
+		out = append(out, ToCategoryDTO(category))
+	}
+	return out
+}
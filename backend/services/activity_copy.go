@@ -0,0 +1,318 @@
+/*
+#Plan: Copy Days and Weeks
+
+Endpoint: POST /activities/copy
+- { source, target, unit?, include_notes?, conflict? }
+  - unit "day" (default) copies the source date to the target date
+  - unit "week" copies the 7 days starting at source to the 7 days starting at
+    target, day by day, the two ranges cannot overlap
+  - include_notes copies the notes too, otherwise copies have no note
+  - conflict decides what happens when the target day already has the activity:
+    - skip (default): the existing entry is kept, the copy is reported as skipped
+    - overwrite: the existing entry takes the source hours (and note)
+    - merge: the source hours are added, a missing note is filled in
+- Only confirmed entries are copied, segmented entries are copied as plain hours
+  and segmented target entries are never changed
+- Entries of archived or deleted categories are skipped
+- All target days must stay within 24 hours, otherwise nothing is copied and the
+  response lists the days that would exceed it
+- Response: copied count, skipped entries and the total of every target day
+*/
+
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aman1117/backend/models"
+	"github.com/aman1117/backend/utils"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	copyUnitDay  = "day"
+	copyUnitWeek = "week"
+
+	copyConflictSkip      = "skip"
+	copyConflictOverwrite = "overwrite"
+	copyConflictMerge     = "merge"
+)
+
+var errCopyHoursExceeded = errors.New("copy exceeds 24 hours on a day")
+
+// ==================== Request/Response Types ====================
+
+type CopyActivitiesRequest struct {
+	Source       string `json:"source"`
+	Target       string `json:"target"`
+	Unit         string `json:"unit,omitempty"`
+	IncludeNotes bool   `json:"include_notes"`
+	Conflict     string `json:"conflict,omitempty"`
+}
+
+// ==================== Handlers ====================
+
+// CopyActivitiesHandler handles POST /activities/copy
+func CopyActivitiesHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	var body CopyActivitiesRequest
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid request body",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	if body.Unit == "" {
+		body.Unit = copyUnitDay
+	}
+	if body.Conflict == "" {
+		body.Conflict = copyConflictSkip
+	}
+
+	var days int
+	switch body.Unit {
+	case copyUnitDay:
+		days = 1
+	case copyUnitWeek:
+		days = 7
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "unit must be day or week",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	switch body.Conflict {
+	case copyConflictSkip, copyConflictOverwrite, copyConflictMerge:
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "conflict must be skip, overwrite or merge",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	loc, err := GetUserLocation(userID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to find user",
+			"error_code": "USER_NOT_FOUND",
+		})
+	}
+
+	const layout = "2006-01-02"
+	source, sourceErr := time.ParseInLocation(layout, body.Source, loc)
+	target, targetErr := time.ParseInLocation(layout, body.Target, loc)
+	if sourceErr != nil || targetErr != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid source or target format, use YYYY-MM-DD",
+			"error_code": "INVALID_DATE",
+		})
+	}
+
+	// The ranges overlap when each one starts before the other one ends
+	if target.Before(source.AddDate(0, 0, days)) && source.Before(target.AddDate(0, 0, days)) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Source and target cannot overlap",
+			"error_code": "INVALID_DATE_RANGE",
+		})
+	}
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+
+	var (
+		copied   int
+		skipped  = []SkippedEntryDTO{}
+		exceeded []DayTotalDTO
+	)
+	err = utils.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := lockUserForUpdate(tx, userID); err != nil {
+			return err
+		}
+
+		var sourceActivities, targetActivities []models.Activity
+		if err := tx.
+			Where("user_id = ? AND activity_date BETWEEN ? AND ? AND draft = ?", userID, source, source.AddDate(0, 0, days-1), false).
+			Order("activity_date ASC, name ASC").
+			Find(&sourceActivities).Error; err != nil {
+			return err
+		}
+		if err := tx.
+			Where("user_id = ? AND activity_date BETWEEN ? AND ?", userID, target, target.AddDate(0, 0, days-1)).
+			Find(&targetActivities).Error; err != nil {
+			return err
+		}
+
+		existing := map[string]*models.Activity{}
+		totals := map[string]float32{}
+		for i := range targetActivities {
+			a := &targetActivities[i]
+			day := a.ActivityDate.Format(layout)
+			existing[day+"/"+string(a.Name)] = a
+			totals[day] += a.DurationHours
+		}
+
+		names := map[models.ActivityName]error{}
+		for _, src := range sourceActivities {
+			offset := calendarDaysBetween(source, src.ActivityDate)
+			date := target.AddDate(0, 0, offset)
+			day := date.Format(layout)
+			skip := func(reason string) {
+				skipped = append(skipped, SkippedEntryDTO{Date: day, Activity: src.Name, Reason: reason})
+			}
+
+			nameErr, checked := names[src.Name]
+			if !checked {
+				nameErr = checkActivityName(tx, userID, src.Name)
+				names[src.Name] = nameErr
+			}
+			switch {
+			case errors.Is(nameErr, errCategoryArchived):
+				skip(skipCategoryArchived)
+				continue
+			case errors.Is(nameErr, errInvalidActivity):
+				skip(skipInvalidActivity)
+				continue
+			case nameErr != nil:
+				return nameErr
+			}
+
+			var note *string
+			if body.IncludeNotes {
+				note = src.Note
+			}
+
+			activity := existing[day+"/"+string(src.Name)]
+			if activity == nil {
+				activity = &models.Activity{
+					UserID:        userID,
+					Name:          src.Name,
+					DurationHours: src.DurationHours,
+					ActivityDate:  date,
+					Note:          note,
+				}
+				if err := tx.Create(activity).Error; err != nil {
+					return err
+				}
+				existing[day+"/"+string(src.Name)] = activity
+				totals[day] += src.DurationHours
+				copied++
+				continue
+			}
+
+			if body.Conflict == copyConflictSkip {
+				skip(skipActivityExists)
+				continue
+			}
+			segmented, err := activityHasSegments(tx, activity.ID)
+			if err != nil {
+				return err
+			}
+			if segmented {
+				skip(skipHasSegments)
+				continue
+			}
+
+			totals[day] -= activity.DurationHours
+			if body.Conflict == copyConflictOverwrite {
+				activity.DurationHours = src.DurationHours
+				if body.IncludeNotes {
+					activity.Note = note
+				}
+			} else {
+				activity.DurationHours += src.DurationHours
+				if activity.Note == nil {
+					activity.Note = note
+				}
+			}
+			totals[day] += activity.DurationHours
+			activity.Draft = false
+
+			// Such an entry cannot be saved and always exceeds the day limit checked below
+			if activity.DurationHours > 24 {
+				continue
+			}
+			if err := tx.Save(activity).Error; err != nil {
+				return err
+			}
+			copied++
+		}
+
+		for offset := 0; offset < days; offset++ {
+			day := target.AddDate(0, 0, offset).Format(layout)
+			if totals[day] > 24 {
+				exceeded = append(exceeded, DayTotalDTO{Date: day, TotalHours: totals[day]})
+			}
+		}
+		if len(exceeded) > 0 {
+			return errCopyHoursExceeded
+		}
+		return nil
+	})
+	if errors.Is(err, errCopyHoursExceeded) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Total hours cannot be more than 24, nothing was copied",
+			"error_code": "HOURS_EXCEEDED",
+			"days":       exceeded,
+		})
+	}
+	if err != nil {
+		log.Errorw("Failed to copy activities", "source", body.Source, "target", body.Target, "unit", body.Unit, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to copy activities",
+			"error_code": "COPY_FAILED",
+		})
+	}
+
+	dates := make([]time.Time, 0, days)
+	for offset := 0; offset < days; offset++ {
+		dates = append(dates, target.AddDate(0, 0, offset))
+	}
+	totals, err := dayTotalsAfterWrite(log, userID, dates, copied > 0)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to find activities",
+			"error_code": "FETCH_FAILED",
+		})
+	}
+
+	log.Infow("Activities copied", "source", body.Source, "target", body.Target, "unit", body.Unit, "conflict", body.Conflict, "copied", copied, "skipped", len(skipped))
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Activities copied",
+		"data": fiber.Map{
+			"copied":  copied,
+			"skipped": skipped,
+			"days":    totals,
+		},
+	})
+}
+
+// ==================== Helpers ====================
+
+// calendarDaysBetween counts the calendar days from a to b, ignoring time of day and zone
+func calendarDaysBetween(a, b time.Time) int {
+	from := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	to := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+	return int(to.Sub(from).Hours() / 24)
+}
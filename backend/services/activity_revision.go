@@ -0,0 +1,306 @@
+/*
+#Plan: Activity Edit History and Undo
+
+Recording:
+- Every create, update and delete of an activity entry appends an ActivityRevision
+  with the hours and note from before and after the change
+- The rows are written by the Activity GORM hooks inside the same transaction as
+  the change, so /create-activity, batch, copy, templates, segments and the timer
+  are all covered without touching them
+- Updates that change neither hours nor note (e.g. confirming a draft) are not recorded
+- Revisions are never updated or deleted, they go away with the user's account
+
+Endpoints:
+1. GET /activities/:id/history
+   - The entry's revisions, newest first, paginated with page and page_size
+   - Also works after the entry was deleted
+
+2. POST /activities/:id/revert
+   - { revision_id } restores the hours and note the entry had right after that revision
+   - A deleted entry is re-created, unless the day has a new entry for the activity
+   - The 24 hour day limit is checked again, segmented entries cannot be reverted
+   - Honors If-Match / "version" against the entry (0 when it was deleted)
+   - The revert itself is recorded as a "revert" revision
+*/
+
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aman1117/backend/models"
+	"github.com/aman1117/backend/utils"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+var (
+	errRevisionNotFound = errors.New("revision not found")
+	errRevisionDeleted  = errors.New("revision deleted the entry")
+	errActivityReplaced = errors.New("day has a new entry for the activity")
+)
+
+// ==================== Request/Response Types ====================
+
+type RevertActivityRequest struct {
+	RevisionID uint  `json:"revision_id"`
+	Version    *uint `json:"version,omitempty"` // see If-Match in version.go, 0 for a deleted entry
+}
+
+type ActivityRevisionDTO struct {
+	ID            uint                  `json:"id"`
+	Action        models.RevisionAction `json:"action"`
+	Name          models.ActivityName   `json:"name"`
+	Date          string                `json:"date"`
+	PreviousHours *float32              `json:"previous_hours"`
+	PreviousNote  *string               `json:"previous_note"`
+	Hours         *float32              `json:"hours"`
+	Note          *string               `json:"note"`
+	RevertOf      *uint                 `json:"revert_of,omitempty"`
+	CreatedAt     time.Time             `json:"created_at"`
+}
+
+// ==================== Handlers ====================
+
+// ActivityHistoryHandler handles GET /activities/:id/history
+func ActivityHistoryHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	activityID, err := c.ParamsInt("id")
+	if err != nil || activityID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid activity id",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	page, pageSize := parsePagination(c)
+	revisions, total, err := ListActivityRevisions(userID, uint(activityID), (page-1)*pageSize, pageSize)
+	if err != nil {
+		traceID, _ := c.Locals("trace_id").(string)
+		utils.LogWithContext(traceID, userID).Errorw("Failed to load activity history", "activity_id", activityID, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to load activity history",
+			"error_code": "FETCH_FAILED",
+		})
+	}
+	if total == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Activity not found",
+			"error_code": "ACTIVITY_NOT_FOUND",
+		})
+	}
+
+	data := make([]ActivityRevisionDTO, 0, len(revisions))
+	for _, r := range revisions {
+		data = append(data, ToActivityRevisionDTO(r))
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success":   true,
+		"data":      data,
+		"page":      page,
+		"page_size": pageSize,
+		"total":     total,
+	})
+}
+
+// RevertActivityHandler handles POST /activities/:id/revert
+func RevertActivityHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	activityID, err := c.ParamsInt("id")
+	if err != nil || activityID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid activity id",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	var body RevertActivityRequest
+	if err := c.BodyParser(&body); err != nil || body.RevisionID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "revision_id is required",
+			"error_code": "MISSING_FIELDS",
+		})
+	}
+
+	expected, err := expectedVersion(c, body.Version)
+	if err != nil {
+		return invalidVersionResponse(c)
+	}
+
+	loc, err := GetUserLocation(userID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to find user",
+			"error_code": "USER_NOT_FOUND",
+		})
+	}
+
+	var (
+		activity models.Activity
+		current  *models.Activity
+		date     time.Time
+	)
+	err = utils.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := lockUserForUpdate(tx, userID); err != nil {
+			return err
+		}
+
+		var revision models.ActivityRevision
+		result := tx.Where("id = ? AND activity_id = ? AND user_id = ?", body.RevisionID, activityID, userID).Limit(1).Find(&revision)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errRevisionNotFound
+		}
+		if revision.Hours == nil {
+			return errRevisionDeleted
+		}
+		d := revision.ActivityDate
+		date = time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, loc)
+
+		result = tx.Where("id = ? AND user_id = ?", activityID, userID).Limit(1).Find(&activity)
+		if result.Error != nil {
+			return result.Error
+		}
+		exists := result.RowsAffected > 0
+		if exists {
+			current = &activity
+		}
+		if err := checkActivityVersion(expected, current); err != nil {
+			return err
+		}
+
+		if exists {
+			segmented, err := activityHasSegments(tx, activity.ID)
+			if err != nil {
+				return err
+			}
+			if segmented {
+				return errActivityHasSegments
+			}
+		} else {
+			var replaced int64
+			if err := tx.Model(&models.Activity{}).
+				Where("user_id = ? AND activity_date = ? AND name = ?", userID, date, revision.Name).
+				Count(&replaced).Error; err != nil {
+				return err
+			}
+			if replaced > 0 {
+				return errActivityReplaced
+			}
+			if err := checkActivityName(tx, userID, revision.Name); err != nil {
+				return err
+			}
+		}
+
+		var otherHours float32
+		if err := tx.Model(&models.Activity{}).
+			Where("user_id = ? AND activity_date = ? AND id <> ?", userID, date, activityID).
+			Select("COALESCE(SUM(duration_hours), 0)").
+			Scan(&otherHours).Error; err != nil {
+			return err
+		}
+		if otherHours+*revision.Hours > 24 {
+			return errDayHoursExceeded
+		}
+
+		activity.DurationHours = *revision.Hours
+		activity.Note = revision.Note
+		activity.Draft = false
+		activity.RevertOf = &revision.ID
+		if exists {
+			return tx.Save(&activity).Error
+		}
+		activity.ID = revision.ActivityID
+		activity.UserID = userID
+		activity.Name = revision.Name
+		activity.ActivityDate = date
+		return tx.Create(&activity).Error
+	})
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+
+	switch {
+	case errors.Is(err, errVersionConflict):
+		return activityConflictResponse(c, log, current)
+	case errors.Is(err, errRevisionNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Revision not found",
+			"error_code": "REVISION_NOT_FOUND",
+		})
+	case errors.Is(err, errRevisionDeleted):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "This revision deleted the entry, revert to an earlier revision to restore it",
+			"error_code": "INVALID_REVISION",
+		})
+	case errors.Is(err, errActivityHasSegments):
+		return activityHasSegmentsResponse(c)
+	case errors.Is(err, errActivityReplaced):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"success":    false,
+			"error":      "The day already has a new entry for this activity",
+			"error_code": "ACTIVITY_EXISTS",
+		})
+	case errors.Is(err, errInvalidActivity), errors.Is(err, errCategoryArchived):
+		return activityNameErrorResponse(c, err)
+	case errors.Is(err, errDayHoursExceeded):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Total hours cannot be more than 24",
+			"error_code": "HOURS_EXCEEDED",
+		})
+	case err != nil:
+		log.Errorw("Failed to revert activity", "activity_id", activityID, "revision_id", body.RevisionID, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to revert activity",
+			"error_code": "REVERT_FAILED",
+		})
+	}
+
+	return activityDayResponse(c, userID, date, "Activity reverted", &activity)
+}
+
+// ==================== Helpers ====================
+
+func ToActivityRevisionDTO(r models.ActivityRevision) ActivityRevisionDTO {
+	return ActivityRevisionDTO{
+		ID:            r.ID,
+		Action:        r.Action,
+		Name:          r.Name,
+		Date:          r.ActivityDate.Format("2006-01-02"),
+		PreviousHours: r.PreviousHours,
+		PreviousNote:  r.PreviousNote,
+		Hours:         r.Hours,
+		Note:          r.Note,
+		RevertOf:      r.RevertOf,
+		CreatedAt:     r.CreatedAt,
+	}
+}
@@ -0,0 +1,447 @@
+/*
+#Plan: Time-Interval Activity Segments
+
+Model:
+- An activity entry (user, date, activity) can optionally be made of segments with
+  start and end timestamps, its DurationHours is then the sum of the segments
+- Entries logged as plain hours stay as they are, the two kinds are not mixed:
+  hours of a segmented entry cannot be set directly (409 ACTIVITY_HAS_SEGMENTS)
+  and segments cannot be added to an entry that already has plain hours
+  (409 ACTIVITY_NOT_SEGMENTED)
+- A segment lies within one calendar day in the user's timezone (it may end at midnight)
+- Segments never overlap any other segment of the same user, whatever the activity
+- Writes lock the user row, so concurrent requests cannot both pass the overlap check
+- Both writes honor the entry's version (If-Match or "version"), see version.go
+
+Endpoints:
+1. POST /activities/segments
+   - { activity, start_at, end_at, note? } with RFC 3339 timestamps
+   - Creates the day's entry if needed, re-derives its hours, checks the 24 hour day limit
+
+2. DELETE /activities/segments/:id
+   - Re-derives the entry's hours, the entry is deleted with its last segment
+
+3. POST /get-activities returns the segments of every entry, ordered by start
+*/
+
+package services
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aman1117/backend/models"
+	"github.com/aman1117/backend/utils"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errSegmentOverlap   = errors.New("segment overlaps another segment")
+	errActivityHasHours = errors.New("activity was logged as plain hours")
+	errDayHoursExceeded = errors.New("total hours cannot be more than 24")
+	errSegmentNotFound  = errors.New("segment not found")
+
+	errActivityHasSegments = errors.New("activity hours are derived from segments")
+)
+
+// ==================== Request/Response Types ====================
+
+type CreateSegmentRequest struct {
+	Activity models.ActivityName `json:"activity"`
+	StartAt  time.Time           `json:"start_at"`
+	EndAt    time.Time           `json:"end_at"`
+	Note     *string             `json:"note,omitempty"`
+	Version  *uint               `json:"version,omitempty"` // of the day's entry, see If-Match in version.go
+}
+
+type ActivitySegmentDTO struct {
+	ID      uint      `json:"id"`
+	StartAt time.Time `json:"start_at"`
+	EndAt   time.Time `json:"end_at"`
+	Hours   float64   `json:"hours"`
+}
+
+// ==================== Handlers ====================
+
+// CreateSegmentHandler handles POST /activities/segments
+func CreateSegmentHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	var body CreateSegmentRequest
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid request body, start_at and end_at must be RFC 3339 timestamps",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	if body.Activity == "" || body.StartAt.IsZero() || body.EndAt.IsZero() {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "activity, start_at and end_at are required",
+			"error_code": "MISSING_FIELDS",
+		})
+	}
+
+	if !body.EndAt.After(body.StartAt) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "end_at must be after start_at",
+			"error_code": "INVALID_SEGMENT",
+		})
+	}
+
+	var note *string
+	if body.Note != nil {
+		trimmed := strings.TrimSpace(*body.Note)
+		if utf8.RuneCountInString(trimmed) > maxActivityNoteLength {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"error":      "Note cannot be longer than 500 characters",
+				"error_code": "NOTE_TOO_LONG",
+			})
+		}
+		if trimmed != "" {
+			note = &trimmed
+		}
+	}
+
+	db := utils.GetDB()
+	if err := checkActivityName(db, userID, body.Activity); err != nil {
+		return activityNameErrorResponse(c, err)
+	}
+
+	loc, err := GetUserLocation(userID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to find user",
+			"error_code": "USER_NOT_FOUND",
+		})
+	}
+
+	date := utils.StartOfDay(body.StartAt, loc)
+	if body.EndAt.After(date.AddDate(0, 0, 1)) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "A segment cannot span midnight, split it into one segment per day",
+			"error_code": "INVALID_SEGMENT",
+		})
+	}
+
+	expected, err := expectedVersion(c, body.Version)
+	if err != nil {
+		return invalidVersionResponse(c)
+	}
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+
+	var (
+		activity models.Activity
+		current  *models.Activity
+		segment  models.ActivitySegment
+		conflict *models.ActivitySegment
+	)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := lockUserForUpdate(tx, userID); err != nil {
+			return err
+		}
+
+		result := tx.Where("user_id = ? AND activity_date = ? AND name = ?", userID, date, body.Activity).Limit(1).Find(&activity)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			current = &activity
+		}
+		if err := checkActivityVersion(expected, current); err != nil {
+			return err
+		}
+		if result.RowsAffected == 0 {
+			activity = models.Activity{
+				UserID:       userID,
+				Name:         body.Activity,
+				ActivityDate: date,
+				Note:         note,
+			}
+			if err := tx.Create(&activity).Error; err != nil {
+				return err
+			}
+		} else {
+			segmented, err := activityHasSegments(tx, activity.ID)
+			if err != nil {
+				return err
+			}
+			if !segmented && activity.DurationHours > 0 {
+				return errActivityHasHours
+			}
+			if note != nil {
+				activity.Note = note
+			}
+		}
+
+		var err error
+		segment, conflict, err = insertSegment(tx, &activity, body.StartAt.UTC(), body.EndAt.UTC())
+		if err != nil {
+			return err
+		}
+		return deriveActivityHours(tx, &activity, date)
+	})
+
+	switch {
+	case errors.Is(err, errVersionConflict):
+		return activityConflictResponse(c, log, current)
+	case errors.Is(err, errActivityHasHours):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"success":    false,
+			"error":      "This activity was logged as plain hours for the day, set its hours to 0 or delete it before adding segments",
+			"error_code": "ACTIVITY_NOT_SEGMENTED",
+		})
+	case errors.Is(err, errSegmentOverlap):
+		response := fiber.Map{
+			"success":    false,
+			"error":      "The segment overlaps another entry",
+			"error_code": "SEGMENT_OVERLAP",
+		}
+		if conflict != nil {
+			response["conflict"] = ToActivitySegmentDTO(*conflict)
+		}
+		return c.Status(fiber.StatusConflict).JSON(response)
+	case errors.Is(err, errDayHoursExceeded):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Total hours cannot be more than 24",
+			"error_code": "HOURS_EXCEEDED",
+		})
+	case err != nil:
+		log.Errorw("Failed to create segment", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to create segment",
+			"error_code": "CREATE_FAILED",
+		})
+	}
+
+	log.Debugw("Segment created", "activity_id", activity.ID, "segment_id", segment.ID)
+	return activityDayResponse(c, userID, date, "Segment created", &activity)
+}
+
+// DeleteSegmentHandler handles DELETE /activities/segments/:id
+func DeleteSegmentHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	segmentID, err := c.ParamsInt("id")
+	if err != nil || segmentID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid segment id",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	// A delete has no body, only If-Match can make it conditional
+	expected, err := expectedVersion(c, nil)
+	if err != nil {
+		return invalidVersionResponse(c)
+	}
+
+	loc, err := GetUserLocation(userID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to find user",
+			"error_code": "USER_NOT_FOUND",
+		})
+	}
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+
+	var (
+		activity models.Activity
+		date     time.Time
+		remains  bool
+	)
+	err = utils.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := lockUserForUpdate(tx, userID); err != nil {
+			return err
+		}
+
+		var segment models.ActivitySegment
+		result := tx.Where("id = ? AND user_id = ?", segmentID, userID).Limit(1).Find(&segment)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errSegmentNotFound
+		}
+		if err := tx.First(&activity, segment.ActivityID).Error; err != nil {
+			return err
+		}
+		if err := checkActivityVersion(expected, &activity); err != nil {
+			return err
+		}
+		d := activity.ActivityDate
+		date = time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, loc)
+
+		if err := tx.Delete(&segment).Error; err != nil {
+			return err
+		}
+
+		remains, err = activityHasSegments(tx, activity.ID)
+		if err != nil {
+			return err
+		}
+		if !remains {
+			return tx.Delete(&activity).Error
+		}
+		return deriveActivityHours(tx, &activity, date)
+	})
+	if errors.Is(err, errVersionConflict) {
+		return activityConflictResponse(c, log, &activity)
+	}
+	if errors.Is(err, errSegmentNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Segment not found",
+			"error_code": "SEGMENT_NOT_FOUND",
+		})
+	}
+	if err != nil {
+		log.Errorw("Failed to delete segment", "segment_id", segmentID, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to delete segment",
+			"error_code": "DELETE_FAILED",
+		})
+	}
+
+	if !remains {
+		return activityDayResponse(c, userID, date, "Segment deleted", nil)
+	}
+	return activityDayResponse(c, userID, date, "Segment deleted", &activity)
+}
+
+// ==================== Helpers ====================
+
+// lockUserForUpdate serializes writes that have to see all of a user's segments
+func lockUserForUpdate(tx *gorm.DB, userID uint) error {
+	var user models.User
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").Where("id = ?", userID).Find(&user).Error
+}
+
+// activityHasSegments reports whether the entry's hours are derived from segments
+func activityHasSegments(tx *gorm.DB, activityID uint) (bool, error) {
+	var count int64
+	err := tx.Model(&models.ActivitySegment{}).Where("activity_id = ?", activityID).Count(&count).Error
+	return count > 0, err
+}
+
+// insertSegment adds [start, end) to the activity unless it overlaps another segment
+// of the user, in which case errSegmentOverlap and the conflicting segment are returned
+func insertSegment(tx *gorm.DB, activity *models.Activity, start, end time.Time) (models.ActivitySegment, *models.ActivitySegment, error) {
+	var existing models.ActivitySegment
+	result := tx.
+		Where("user_id = ? AND start_at < ? AND end_at > ?", activity.UserID, end, start).
+		Order("start_at").
+		Limit(1).
+		Find(&existing)
+	if result.Error != nil {
+		return models.ActivitySegment{}, nil, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return models.ActivitySegment{}, &existing, errSegmentOverlap
+	}
+
+	segment := models.ActivitySegment{
+		ActivityID: activity.ID,
+		UserID:     activity.UserID,
+		StartAt:    start,
+		EndAt:      end,
+	}
+	if err := tx.Create(&segment).Error; err != nil {
+		return models.ActivitySegment{}, nil, err
+	}
+	return segment, nil, nil
+}
+
+// deriveActivityHours sets the entry's hours to the sum of its segments and
+// checks that the day stays within 24 hours
+func deriveActivityHours(tx *gorm.DB, activity *models.Activity, date time.Time) error {
+	var segments []models.ActivitySegment
+	if err := tx.Where("activity_id = ?", activity.ID).Find(&segments).Error; err != nil {
+		return err
+	}
+	var hours float64
+	for i := range segments {
+		hours += segments[i].Hours()
+	}
+	activity.DurationHours = float32(math.Round(hours*100) / 100)
+
+	var otherHours float32
+	if err := tx.Model(&models.Activity{}).
+		Where("user_id = ? AND activity_date = ? AND id <> ?", activity.UserID, date, activity.ID).
+		Select("COALESCE(SUM(duration_hours), 0)").
+		Scan(&otherHours).Error; err != nil {
+		return err
+	}
+	if otherHours+activity.DurationHours > 24 {
+		return errDayHoursExceeded
+	}
+
+	return tx.Save(activity).Error
+}
+
+// attachSegments fills in the segments of every segmented entry in dtos
+func attachSegments(dtos []ActivityDTO) error {
+	if len(dtos) == 0 {
+		return nil
+	}
+	ids := make([]uint, 0, len(dtos))
+	for _, dto := range dtos {
+		ids = append(ids, dto.ID)
+	}
+
+	segments, err := GetActivitySegments(ids)
+	if err != nil {
+		return err
+	}
+	byActivity := map[uint][]ActivitySegmentDTO{}
+	for _, s := range segments {
+		byActivity[s.ActivityID] = append(byActivity[s.ActivityID], ToActivitySegmentDTO(s))
+	}
+	for i := range dtos {
+		dtos[i].Segments = byActivity[dtos[i].ID]
+	}
+	return nil
+}
+
+func ToActivitySegmentDTO(s models.ActivitySegment) ActivitySegmentDTO {
+	return ActivitySegmentDTO{
+		ID:      s.ID,
+		StartAt: s.StartAt,
+		EndAt:   s.EndAt,
+		Hours:   roundHours(s.Hours()),
+	}
+}
@@ -0,0 +1,745 @@
+/*
+#Plan: Recurring Activity Templates
+
+Model:
+- A template is a named set of (activity, hours, note) entries with a recurrence rule:
+  - daily
+  - weekdays: on the listed days of the week (0 = Sunday ... 6 = Saturday)
+  - interval: every interval_days days counted from start_date
+- A template never applies before its start_date (defaults to the user's today)
+- Filling a day skips entries whose activity is already logged that day and entries
+  that would take the day past 24 hours, the skipped entries are reported back
+
+Endpoints:
+1. GET /activity-templates
+2. POST /activity-templates
+   - { name, recurrence, weekdays?, interval_days?, start_date?, auto_fill?, entries: [...] }
+3. PUT /activity-templates/:id - replaces the template, entries included
+4. DELETE /activity-templates/:id
+5. POST /activity-templates/:id/apply
+   - { start_date, end_date, draft? }, at most 92 days
+   - Fills every matching day in one transaction, streaks are synced afterwards
+6. POST /activities/drafts/confirm
+   - { date } confirms every draft of the day, PATCH /activities/:id confirms one
+
+Auto-fill:
+- RunDailyJob pre-fills the new day with draft entries from every template that
+  has auto_fill set, right after the day's streak row is created
+- Drafts are only visible to their owner and do not count towards streaks until
+  they are confirmed, edited or overwritten through /create-activity
+*/
+
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aman1117/backend/models"
+	"github.com/aman1117/backend/utils"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	maxTemplatesPerUser  = 20
+	maxTemplateEntries   = 20
+	maxTemplateApplyDays = 92
+	templateDateLayout   = "2006-01-02"
+)
+
+// Reasons reported for entries that were not added to a day
+const (
+	skipActivityExists   = "ACTIVITY_EXISTS"
+	skipHoursExceeded    = "HOURS_EXCEEDED"
+	skipCategoryArchived = "CATEGORY_ARCHIVED"
+	skipInvalidActivity  = "INVALID_ACTIVITY"
+	skipHasSegments      = "ACTIVITY_HAS_SEGMENTS"
+)
+
+// ==================== Request/Response Types ====================
+
+type TemplateEntryRequest struct {
+	Activity models.ActivityName `json:"activity"`
+	Hours    float32             `json:"hours"`
+	Note     *string             `json:"note,omitempty"`
+}
+
+type TemplateRequest struct {
+	Name         string                    `json:"name"`
+	Recurrence   models.TemplateRecurrence `json:"recurrence"`
+	Weekdays     []int                     `json:"weekdays,omitempty"`
+	IntervalDays int                       `json:"interval_days,omitempty"`
+	StartDate    string                    `json:"start_date,omitempty"`
+	AutoFill     bool                      `json:"auto_fill"`
+	Entries      []TemplateEntryRequest    `json:"entries"`
+}
+
+type ApplyTemplateRequest struct {
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	Draft     bool   `json:"draft"`
+}
+
+type ConfirmDraftsRequest struct {
+	Date string `json:"date"`
+}
+
+type TemplateEntryDTO struct {
+	Activity models.ActivityName `json:"activity"`
+	Hours    float32             `json:"hours"`
+	Note     *string             `json:"note"`
+}
+
+type TemplateDTO struct {
+	ID           uint                      `json:"id"`
+	Name         string                    `json:"name"`
+	Recurrence   models.TemplateRecurrence `json:"recurrence"`
+	Weekdays     []int                     `json:"weekdays,omitempty"`
+	IntervalDays int                       `json:"interval_days,omitempty"`
+	StartDate    string                    `json:"start_date"`
+	AutoFill     bool                      `json:"auto_fill"`
+	Entries      []TemplateEntryDTO        `json:"entries"`
+}
+
+type SkippedEntryDTO struct {
+	Date     string              `json:"date"`
+	Activity models.ActivityName `json:"activity"`
+	Reason   string              `json:"reason"`
+}
+
+// ==================== Handlers ====================
+
+// GetTemplatesHandler - GET /activity-templates
+func GetTemplatesHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	var templates []models.ActivityTemplate
+	if err := utils.GetDB().Preload("Entries").Where("user_id = ?", userID).Order("created_at ASC").Find(&templates).Error; err != nil {
+		traceID, _ := c.Locals("trace_id").(string)
+		utils.LogWithContext(traceID, userID).Errorw("Template fetch failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to fetch templates",
+			"error_code": "FETCH_FAILED",
+		})
+	}
+
+	data := make([]TemplateDTO, 0, len(templates))
+	for _, t := range templates {
+		data = append(data, ToTemplateDTO(t))
+	}
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    data,
+	})
+}
+
+// CreateTemplateHandler - POST /activity-templates
+func CreateTemplateHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	template, err := parseTemplateRequest(c, userID)
+	if err != nil || template == nil {
+		return err
+	}
+
+	db := utils.GetDB()
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+
+	var count int64
+	if err := db.Model(&models.ActivityTemplate{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		log.Errorw("Template count failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to create template",
+			"error_code": "CREATE_FAILED",
+		})
+	}
+	if count >= maxTemplatesPerUser {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Template limit reached",
+			"error_code": "TEMPLATE_LIMIT_REACHED",
+		})
+	}
+
+	if err := db.Create(template).Error; err != nil {
+		log.Errorw("Template create failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to create template",
+			"error_code": "CREATE_FAILED",
+		})
+	}
+
+	log.Infow("Template created", "template_id", template.ID, "recurrence", template.Recurrence)
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"data":    ToTemplateDTO(*template),
+	})
+}
+
+// UpdateTemplateHandler - PUT /activity-templates/:id
+// Replaces the whole template including its entries.
+func UpdateTemplateHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	existing, err := getOwnedTemplate(c, userID)
+	if err != nil || existing == nil {
+		return err
+	}
+
+	template, err := parseTemplateRequest(c, userID)
+	if err != nil || template == nil {
+		return err
+	}
+	template.ID = existing.ID
+	template.CreatedAt = existing.CreatedAt
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+
+	err = utils.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("template_id = ?", template.ID).Delete(&models.ActivityTemplateEntry{}).Error; err != nil {
+			return err
+		}
+		return tx.Session(&gorm.Session{FullSaveAssociations: true}).Save(template).Error
+	})
+	if err != nil {
+		log.Errorw("Template update failed", "template_id", template.ID, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to update template",
+			"error_code": "UPDATE_FAILED",
+		})
+	}
+
+	log.Infow("Template updated", "template_id", template.ID)
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    ToTemplateDTO(*template),
+	})
+}
+
+// DeleteTemplateHandler - DELETE /activity-templates/:id
+// Entries created from the template are kept.
+func DeleteTemplateHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	template, err := getOwnedTemplate(c, userID)
+	if err != nil || template == nil {
+		return err
+	}
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+	if err := utils.GetDB().Delete(template).Error; err != nil {
+		log.Errorw("Template delete failed", "template_id", template.ID, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to delete template",
+			"error_code": "DELETE_FAILED",
+		})
+	}
+
+	log.Infow("Template deleted", "template_id", template.ID)
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Template deleted",
+	})
+}
+
+// ApplyTemplateHandler - POST /activity-templates/:id/apply
+func ApplyTemplateHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	var body ApplyTemplateRequest
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid request body",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	template, err := getOwnedTemplate(c, userID)
+	if err != nil || template == nil {
+		return err
+	}
+
+	loc, err := GetUserLocation(userID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to find user",
+			"error_code": "USER_NOT_FOUND",
+		})
+	}
+
+	startDate, startErr := time.ParseInLocation(templateDateLayout, body.StartDate, loc)
+	endDate, endErr := time.ParseInLocation(templateDateLayout, body.EndDate, loc)
+	if startErr != nil || endErr != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid start_date or end_date format, use YYYY-MM-DD",
+			"error_code": "INVALID_DATE",
+		})
+	}
+	if startDate.After(endDate) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Start date must be before end date",
+			"error_code": "INVALID_DATE_RANGE",
+		})
+	}
+	if endDate.After(startDate.AddDate(0, 0, maxTemplateApplyDays-1)) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "A template can be applied to at most 92 days at once",
+			"error_code": "INVALID_DATE_RANGE",
+		})
+	}
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+
+	var (
+		filled  []time.Time
+		created int
+		skipped = []SkippedEntryDTO{}
+	)
+	err = utils.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := lockUserForUpdate(tx, userID); err != nil {
+			return err
+		}
+		for date := startDate; !date.After(endDate); date = date.AddDate(0, 0, 1) {
+			if !template.Matches(date) {
+				continue
+			}
+			n, daySkipped, err := fillTemplateDay(tx, template, date, body.Draft)
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				filled = append(filled, date)
+				created += n
+			}
+			skipped = append(skipped, daySkipped...)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorw("Template apply failed", "template_id", template.ID, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to apply template",
+			"error_code": "APPLY_FAILED",
+		})
+	}
+
+	// Drafts do not count towards streaks, so there is nothing to sync for them
+	days, err := dayTotalsAfterWrite(log, userID, filled, !body.Draft)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to find activities",
+			"error_code": "FETCH_FAILED",
+		})
+	}
+
+	log.Infow("Template applied", "template_id", template.ID, "created", created, "skipped", len(skipped), "draft", body.Draft)
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Template applied",
+		"data": fiber.Map{
+			"created": created,
+			"skipped": skipped,
+			"days":    days,
+		},
+	})
+}
+
+// ConfirmDraftsHandler - POST /activities/drafts/confirm
+func ConfirmDraftsHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	var body ConfirmDraftsRequest
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid request body",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	loc, err := GetUserLocation(userID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to find user",
+			"error_code": "USER_NOT_FOUND",
+		})
+	}
+
+	date, err := time.ParseInLocation(templateDateLayout, body.Date, loc)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid date format, use YYYY-MM-DD",
+			"error_code": "INVALID_DATE",
+		})
+	}
+
+	// UpdateColumns skips the entry hooks, confirming changes neither hours nor note
+	result := utils.GetDB().Model(&models.Activity{}).
+		Where("user_id = ? AND activity_date = ? AND draft = ?", userID, date, true).
+		UpdateColumns(map[string]interface{}{
+			"draft":   false,
+			"version": gorm.Expr("version + 1"),
+		})
+	if result.Error != nil {
+		traceID, _ := c.Locals("trace_id").(string)
+		utils.LogWithContext(traceID, userID).Errorw("Failed to confirm drafts", "date", body.Date, "error", result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to confirm drafts",
+			"error_code": "UPDATE_FAILED",
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success":    false,
+			"error":      "No drafts on this day",
+			"error_code": "NO_DRAFTS",
+		})
+	}
+
+	return activityDayResponse(c, userID, date, "Drafts confirmed", nil)
+}
+
+// ==================== Nightly Auto-Fill ====================
+
+// PrefillTemplateDrafts adds draft entries for date from the auto-fill templates of
+// every active user in timezone. A failing template is logged and skipped.
+func PrefillTemplateDrafts(ctx context.Context, timezone string, date time.Time) error {
+	db := utils.GetDB().WithContext(ctx)
+
+	var templates []models.ActivityTemplate
+	if err := db.
+		Preload("Entries").
+		Select("activity_templates.*").
+		Joins("JOIN users ON users.id = activity_templates.user_id").
+		Where("activity_templates.auto_fill = ? AND users.timezone = ? AND users.disabled_at IS NULL AND users.deletion_requested_at IS NULL", true, timezone).
+		Order("activity_templates.id ASC").
+		Find(&templates).Error; err != nil {
+		return err
+	}
+
+	created := 0
+	for i := range templates {
+		template := &templates[i]
+		if !template.Matches(date) {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := lockUserForUpdate(tx, template.UserID); err != nil {
+				return err
+			}
+			n, _, err := fillTemplateDay(tx, template, date, true)
+			created += n
+			return err
+		})
+		if err != nil {
+			utils.Sugar.Errorw("Template auto-fill failed", "template_id", template.ID, "user_id", template.UserID, "error", err)
+		}
+	}
+
+	utils.Sugar.Debugw("Template drafts pre-filled", "timezone", timezone, "date", date, "templates", len(templates), "entries", created)
+	return nil
+}
+
+// ==================== Helpers ====================
+
+// fillTemplateDay creates the template's entries on date inside tx and reports the
+// entries it had to skip. The caller holds the user row lock.
+func fillTemplateDay(tx *gorm.DB, template *models.ActivityTemplate, date time.Time, draft bool) (int, []SkippedEntryDTO, error) {
+	var dayActivities []models.Activity
+	if err := tx.Where("user_id = ? AND activity_date = ?", template.UserID, date).Find(&dayActivities).Error; err != nil {
+		return 0, nil, err
+	}
+
+	var total float32
+	logged := map[models.ActivityName]bool{}
+	for _, a := range dayActivities {
+		total += a.DurationHours
+		logged[a.Name] = true
+	}
+
+	created := 0
+	var skipped []SkippedEntryDTO
+	skip := func(entry models.ActivityTemplateEntry, reason string) {
+		skipped = append(skipped, SkippedEntryDTO{
+			Date:     date.Format(templateDateLayout),
+			Activity: entry.Name,
+			Reason:   reason,
+		})
+	}
+	for _, entry := range template.Entries {
+		if logged[entry.Name] {
+			skip(entry, skipActivityExists)
+			continue
+		}
+		if total+entry.Hours > 24 {
+			skip(entry, skipHoursExceeded)
+			continue
+		}
+		if err := checkActivityName(tx, template.UserID, entry.Name); err != nil {
+			switch {
+			case errors.Is(err, errCategoryArchived):
+				skip(entry, skipCategoryArchived)
+				continue
+			case errors.Is(err, errInvalidActivity):
+				skip(entry, skipInvalidActivity)
+				continue
+			default:
+				return 0, nil, err
+			}
+		}
+
+		activity := models.Activity{
+			UserID:        template.UserID,
+			Name:          entry.Name,
+			DurationHours: entry.Hours,
+			ActivityDate:  date,
+			Note:          entry.Note,
+			Draft:         draft,
+		}
+		if err := tx.Create(&activity).Error; err != nil {
+			return 0, nil, err
+		}
+		total += entry.Hours
+		logged[entry.Name] = true
+		created++
+	}
+	return created, skipped, nil
+}
+
+// parseTemplateRequest builds a template for userID from the request body.
+// If it returns a nil template, the error response has already been written.
+func parseTemplateRequest(c *fiber.Ctx, userID uint) (*models.ActivityTemplate, error) {
+	invalid := func(message string) (*models.ActivityTemplate, error) {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      message,
+			"error_code": "INVALID_TEMPLATE",
+		})
+	}
+
+	var body TemplateRequest
+	if err := c.BodyParser(&body); err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid request body",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	loc, err := GetUserLocation(userID)
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to find user",
+			"error_code": "USER_NOT_FOUND",
+		})
+	}
+
+	template := models.ActivityTemplate{
+		UserID:       userID,
+		Name:         strings.TrimSpace(body.Name),
+		Recurrence:   body.Recurrence,
+		IntervalDays: body.IntervalDays,
+		AutoFill:     body.AutoFill,
+	}
+
+	for _, day := range body.Weekdays {
+		if day < 0 || day > 6 {
+			return invalid("weekdays must be between 0 (Sunday) and 6 (Saturday)")
+		}
+		template.Weekdays |= 1 << uint(day)
+	}
+
+	if body.StartDate == "" {
+		template.StartDate = utils.StartOfDay(time.Now(), loc)
+	} else {
+		template.StartDate, err = time.ParseInLocation(templateDateLayout, body.StartDate, loc)
+		if err != nil {
+			return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"error":      "Invalid start_date format, use YYYY-MM-DD",
+				"error_code": "INVALID_DATE",
+			})
+		}
+	}
+
+	if err := template.Validate(); err != nil {
+		return invalid(err.Error())
+	}
+	// Keep only the fields of the chosen rule
+	if template.Recurrence != models.RecurrenceWeekdays {
+		template.Weekdays = 0
+	}
+	if template.Recurrence != models.RecurrenceInterval {
+		template.IntervalDays = 0
+	}
+
+	if len(body.Entries) == 0 || len(body.Entries) > maxTemplateEntries {
+		return invalid("A template needs between 1 and 20 entries")
+	}
+
+	db := utils.GetDB()
+	var total float32
+	seen := map[models.ActivityName]bool{}
+	for _, e := range body.Entries {
+		if e.Hours <= 0 || e.Hours > 24 {
+			return invalid("Entry hours must be more than 0 and at most 24")
+		}
+		if seen[e.Activity] {
+			return invalid("An activity can only appear once in a template")
+		}
+		seen[e.Activity] = true
+		total += e.Hours
+
+		if err := checkActivityName(db, userID, e.Activity); err != nil {
+			return nil, activityNameErrorResponse(c, err)
+		}
+
+		var note *string
+		if e.Note != nil {
+			trimmed := strings.TrimSpace(*e.Note)
+			if utf8.RuneCountInString(trimmed) > maxActivityNoteLength {
+				return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"success":    false,
+					"error":      "Note cannot be longer than 500 characters",
+					"error_code": "NOTE_TOO_LONG",
+				})
+			}
+			if trimmed != "" {
+				note = &trimmed
+			}
+		}
+
+		template.Entries = append(template.Entries, models.ActivityTemplateEntry{
+			Name:  e.Activity,
+			Hours: e.Hours,
+			Note:  note,
+		})
+	}
+	if total > 24 {
+		return invalid("Total hours of a template cannot be more than 24")
+	}
+
+	return &template, nil
+}
+
+// getOwnedTemplate loads the template from the :id route param with its entries.
+// If it returns a nil template, the error response has already been written.
+func getOwnedTemplate(c *fiber.Ctx, userID uint) (*models.ActivityTemplate, error) {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid template id",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	var template models.ActivityTemplate
+	result := utils.GetDB().Preload("Entries").Where("id = ? AND user_id = ?", id, userID).Limit(1).Find(&template)
+	if result.Error != nil {
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to find template",
+			"error_code": "FETCH_FAILED",
+		})
+	}
+	if result.RowsAffected == 0 {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Template not found",
+			"error_code": "TEMPLATE_NOT_FOUND",
+		})
+	}
+	return &template, nil
+}
+
+func ToTemplateDTO(in models.ActivityTemplate) TemplateDTO {
+	dto := TemplateDTO{
+		ID:           in.ID,
+		Name:         in.Name,
+		Recurrence:   in.Recurrence,
+		IntervalDays: in.IntervalDays,
+		StartDate:    in.StartDate.Format(templateDateLayout),
+		AutoFill:     in.AutoFill,
+		Entries:      make([]TemplateEntryDTO, 0, len(in.Entries)),
+	}
+	for day := 0; day < 7; day++ {
+		if in.Weekdays&(1<<uint(day)) != 0 {
+			dto.Weekdays = append(dto.Weekdays, day)
+		}
+	}
+	for _, e := range in.Entries {
+		dto.Entries = append(dto.Entries, TemplateEntryDTO{
+			Activity: e.Name,
+			Hours:    e.Hours,
+			Note:     e.Note,
+		})
+	}
+	return dto
+}
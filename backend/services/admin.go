@@ -0,0 +1,365 @@
+/*
+#Plan: Roles and Admin API
+
+Roles:
+- users.role is "user" (default) or "admin" and is copied into the access token claims
+- RequireRole(models.RoleAdmin) guards the /admin group, it runs after AuthMiddleware
+- There is no endpoint that grants roles, the first admin is promoted in SQL:
+    UPDATE users SET role = 'admin' WHERE email = 'someone@example.com';
+  the role is picked up with the next login or token refresh
+
+Endpoints (all under /admin):
+1. GET /admin/users?q=&status=&role=&page=&page_size=
+   - q matches username or email, status is active, disabled or pending_deletion
+   - Newest first, page_size defaults to 20 (max 100)
+
+2. GET /admin/users/:id
+
+3. POST /admin/users/:id/disable
+   - Sets users.disabled_at and logs out every session
+   - Login (every method), refresh and personal access tokens answer 403 ACCOUNT_DISABLED
+   - Disabled users are hidden from search and get no reminder emails
+   - Admins cannot disable themselves
+
+4. POST /admin/users/:id/enable
+
+5. POST /admin/users/:id/force-password-reset
+   - Replaces the password with a random one, logs out every session
+     and emails the user a regular password reset link
+
+6. GET /admin/stats
+   - User counts and activity totals
+
+7. GET /admin/audit-events (see audit.go)
+*/
+
+package services
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/aman1117/backend/models"
+	"github.com/aman1117/backend/utils"
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// ==================== Request/Response Types ====================
+
+type AdminUserDTO struct {
+	ID                  uint        `json:"id"`
+	Username            string      `json:"username"`
+	Email               string      `json:"email"`
+	EmailVerified       bool        `json:"email_verified"`
+	Role                models.Role `json:"role"`
+	IsPrivate           bool        `json:"is_private"`
+	Timezone            string      `json:"timezone"`
+	TOTPEnabled         bool        `json:"totp_enabled"`
+	DisabledAt          *time.Time  `json:"disabled_at"`
+	DeletionRequestedAt *time.Time  `json:"deletion_requested_at"`
+	CreatedAt           time.Time   `json:"created_at"`
+}
+
+type AdminStatsDTO struct {
+	TotalUsers           int64   `json:"total_users" gorm:"column:total_users"`
+	VerifiedUsers        int64   `json:"verified_users" gorm:"column:verified_users"`
+	TwoFactorUsers       int64   `json:"two_factor_users" gorm:"column:two_factor_users"`
+	AdminUsers           int64   `json:"admin_users" gorm:"column:admin_users"`
+	DisabledUsers        int64   `json:"disabled_users" gorm:"column:disabled_users"`
+	PendingDeletionUsers int64   `json:"pending_deletion_users" gorm:"column:pending_deletion_users"`
+	NewUsers7d           int64   `json:"new_users_7d" gorm:"column:new_users_7d"`
+	NewUsers30d          int64   `json:"new_users_30d" gorm:"column:new_users_30d"`
+	ActiveUsers7d        int64   `json:"active_users_7d" gorm:"column:active_users_7d"`
+	TotalActivities      int64   `json:"total_activities" gorm:"column:total_activities"`
+	TotalHours           float64 `json:"total_hours" gorm:"column:total_hours"`
+}
+
+// ==================== Handlers ====================
+
+// AdminListUsersHandler handles GET /admin/users
+func AdminListUsersHandler(c *fiber.Ctx) error {
+	traceID, _ := c.Locals("trace_id").(string)
+	adminID, _ := c.Locals("user_id").(uint)
+
+	status := c.Query("status")
+	if status != "" && status != "active" && status != "disabled" && status != "pending_deletion" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "status must be active, disabled or pending_deletion",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	role := models.Role(c.Query("role"))
+	if role != "" && !role.IsValid() {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid role",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	page, pageSize := parsePagination(c)
+	users, total, err := SearchUsers(strings.TrimSpace(c.Query("q")), status, role, (page-1)*pageSize, pageSize)
+	if err != nil {
+		utils.LogWithContext(traceID, adminID).Errorw("Admin user search failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to find users",
+			"error_code": "FETCH_FAILED",
+		})
+	}
+
+	data := make([]AdminUserDTO, 0, len(users))
+	for _, user := range users {
+		data = append(data, ToAdminUserDTO(user))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success":   true,
+		"data":      data,
+		"page":      page,
+		"page_size": pageSize,
+		"total":     total,
+	})
+}
+
+// AdminGetUserHandler handles GET /admin/users/:id
+func AdminGetUserHandler(c *fiber.Ctx) error {
+	user, err := getAdminTargetUser(c)
+	if err != nil || user == nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"data":    ToAdminUserDTO(*user),
+	})
+}
+
+// AdminDisableUserHandler handles POST /admin/users/:id/disable
+func AdminDisableUserHandler(c *fiber.Ctx) error {
+	traceID, _ := c.Locals("trace_id").(string)
+	adminID, _ := c.Locals("user_id").(uint)
+
+	user, err := getAdminTargetUser(c)
+	if err != nil || user == nil {
+		return err
+	}
+
+	if user.ID == adminID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "You cannot disable your own account",
+			"error_code": "CANNOT_DISABLE_SELF",
+		})
+	}
+
+	now := time.Now()
+	changed, err := SetUserDisabled(user.ID, &now)
+	if err != nil {
+		utils.LogWithContext(traceID, adminID).Errorw("Failed to disable user", "target_user_id", user.ID, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to disable user",
+			"error_code": "UPDATE_FAILED",
+		})
+	}
+	if !changed {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"success":    false,
+			"error":      "User is already disabled",
+			"error_code": "ALREADY_DISABLED",
+		})
+	}
+
+	revokeUserSessions(user.ID, "")
+
+	utils.LogWithContext(traceID, adminID).Infow("User disabled by admin", "target_user_id", user.ID)
+	recordAuditEvent(c, user.ID, models.AuditUserDisabled, nil)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "User disabled",
+	})
+}
+
+// AdminEnableUserHandler handles POST /admin/users/:id/enable
+func AdminEnableUserHandler(c *fiber.Ctx) error {
+	traceID, _ := c.Locals("trace_id").(string)
+	adminID, _ := c.Locals("user_id").(uint)
+
+	user, err := getAdminTargetUser(c)
+	if err != nil || user == nil {
+		return err
+	}
+
+	changed, err := SetUserDisabled(user.ID, nil)
+	if err != nil {
+		utils.LogWithContext(traceID, adminID).Errorw("Failed to enable user", "target_user_id", user.ID, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to enable user",
+			"error_code": "UPDATE_FAILED",
+		})
+	}
+	if !changed {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"success":    false,
+			"error":      "User is not disabled",
+			"error_code": "NOT_DISABLED",
+		})
+	}
+
+	utils.LogWithContext(traceID, adminID).Infow("User enabled by admin", "target_user_id", user.ID)
+	recordAuditEvent(c, user.ID, models.AuditUserEnabled, nil)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "User enabled",
+	})
+}
+
+// AdminForcePasswordResetHandler handles POST /admin/users/:id/force-password-reset
+func AdminForcePasswordResetHandler(c *fiber.Ctx) error {
+	traceID, _ := c.Locals("trace_id").(string)
+	adminID, _ := c.Locals("user_id").(uint)
+	log := utils.LogWithContext(traceID, adminID)
+
+	user, err := getAdminTargetUser(c)
+	if err != nil || user == nil {
+		return err
+	}
+
+	// The old password stops working right away, a random one nobody knows takes its place
+	randomPassword, _, err := utils.GenerateResetToken()
+	if err == nil {
+		var hash []byte
+		hash, err = bcrypt.GenerateFromPassword([]byte(randomPassword), bcrypt.DefaultCost)
+		if err == nil {
+			err = UpdateUserPassword(user.ID, string(hash))
+		}
+	}
+	if err != nil {
+		log.Errorw("Failed to reset password", "target_user_id", user.ID, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to reset password",
+			"error_code": "UPDATE_FAILED",
+		})
+	}
+
+	revokeUserSessions(user.ID, "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	emailSent := false
+	rawToken, tokenHash, err := utils.GenerateResetToken()
+	if err == nil {
+		err = utils.StoreResetToken(ctx, tokenHash, user.ID)
+	}
+	if err == nil {
+		err = sendPasswordResetEmail(user.Email, user.Username, rawToken)
+	}
+	if err != nil {
+		log.Errorw("Failed to send forced password reset email", "target_user_id", user.ID, "error", err)
+	} else {
+		emailSent = true
+	}
+
+	log.Infow("Password reset forced by admin", "target_user_id", user.ID)
+	recordAuditEvent(c, user.ID, models.AuditPasswordResetForced, fiber.Map{"email_sent": emailSent})
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success":    true,
+		"message":    "Password reset, the user has been logged out",
+		"email_sent": emailSent,
+	})
+}
+
+// AdminStatsHandler handles GET /admin/stats
+func AdminStatsHandler(c *fiber.Ctx) error {
+	traceID, _ := c.Locals("trace_id").(string)
+	adminID, _ := c.Locals("user_id").(uint)
+
+	stats, err := GetAdminStats(time.Now())
+	if err != nil {
+		utils.LogWithContext(traceID, adminID).Errorw("Failed to load admin stats", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to load stats",
+			"error_code": "FETCH_FAILED",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"data":    stats,
+	})
+}
+
+// ==================== Helpers ====================
+
+// parsePagination reads the page (1-based) and page_size query params
+func parsePagination(c *fiber.Ctx) (int, int) {
+	page := c.QueryInt("page", 1)
+	if page < 1 {
+		page = 1
+	}
+	pageSize := c.QueryInt("page_size", defaultPageSize)
+	if pageSize < 1 || pageSize > maxPageSize {
+		pageSize = defaultPageSize
+	}
+	return page, pageSize
+}
+
+// getAdminTargetUser loads the user from the :id route param.
+// If it returns a nil user, the error response has already been written.
+func getAdminTargetUser(c *fiber.Ctx) (*models.User, error) {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid user id",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	user, err := GetUserByID(uint(id))
+	if err != nil {
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to find user",
+			"error_code": "FETCH_FAILED",
+		})
+	}
+	if user == nil {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success":    false,
+			"error":      "User not found",
+			"error_code": "USER_NOT_FOUND",
+		})
+	}
+	return user, nil
+}
+
+func ToAdminUserDTO(user models.User) AdminUserDTO {
+	return AdminUserDTO{
+		ID:                  user.ID,
+		Username:            user.Username,
+		Email:               user.Email,
+		EmailVerified:       user.EmailVerified,
+		Role:                user.Role,
+		IsPrivate:           user.IsPrivate,
+		Timezone:            user.Timezone,
+		TOTPEnabled:         user.TOTPEnabled,
+		DisabledAt:          user.DisabledAt,
+		DeletionRequestedAt: user.DeletionRequestedAt,
+		CreatedAt:           user.CreatedAt,
+	}
+}
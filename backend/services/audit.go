@@ -0,0 +1,222 @@
+/*
+#Plan: Security Audit Log
+
+Storage:
+- Every security relevant account change appends a row to audit_events:
+  target user, actor (nil for unauthenticated requests), event type, IP,
+  user agent, trace ID and a small JSON payload (old/new values, never secrets)
+- Rows are never updated, they are only removed together with the account
+- Writing is best-effort: a failed insert is logged, the change itself already happened
+
+Recorded in:
+- auth.go: login succeeded/failed, password, username, privacy and timezone changes
+- password_reset.go: reset requested and reset completed (token consumed)
+- blob.go: profile picture uploaded/deleted
+- email_change.go: change requested, confirmed and reverted
+- passkey.go, personal_access_token.go, oidc.go: passkeys, access tokens and linked
+  identities added or removed
+- session.go: logout-all and single session revocation
+- two_factor.go, account.go, admin.go: 2FA (including failed codes and recovery code
+  regeneration), account deletion and admin actions
+
+Endpoints:
+1. GET /account/security-log?page=&page_size=
+   - The caller's own events, newest first
+
+2. GET /admin/audit-events?user_id=&actor_id=&event_type=&ip=&from=&to=&page=&page_size=
+   - from/to are RFC 3339 timestamps or YYYY-MM-DD dates (to is exclusive)
+*/
+
+package services
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/aman1117/backend/models"
+	"github.com/aman1117/backend/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ==================== Request/Response Types ====================
+
+type AuditEventFilter struct {
+	UserID    uint
+	ActorID   uint
+	EventType models.AuditEventType
+	IP        string
+	From      *time.Time
+	To        *time.Time
+}
+
+type AuditEventDTO struct {
+	ID        uint                  `json:"id"`
+	UserID    uint                  `json:"user_id"`
+	ActorID   *uint                 `json:"actor_id"`
+	EventType models.AuditEventType `json:"event_type"`
+	IP        string                `json:"ip"`
+	UserAgent string                `json:"user_agent"`
+	TraceID   string                `json:"trace_id"`
+	Payload   json.RawMessage       `json:"payload"`
+	CreatedAt time.Time             `json:"created_at"`
+}
+
+// ==================== Handlers ====================
+
+// SecurityLogHandler handles GET /account/security-log
+func SecurityLogHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	page, pageSize := parsePagination(c)
+	events, total, err := ListAuditEvents(AuditEventFilter{UserID: userID}, (page-1)*pageSize, pageSize)
+	if err != nil {
+		traceID, _ := c.Locals("trace_id").(string)
+		utils.LogWithContext(traceID, userID).Errorw("Failed to load security log", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to load security log",
+			"error_code": "FETCH_FAILED",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success":   true,
+		"data":      ToAuditEventDTOs(events),
+		"page":      page,
+		"page_size": pageSize,
+		"total":     total,
+	})
+}
+
+// AdminAuditEventsHandler handles GET /admin/audit-events
+func AdminAuditEventsHandler(c *fiber.Ctx) error {
+	traceID, _ := c.Locals("trace_id").(string)
+	adminID, _ := c.Locals("user_id").(uint)
+
+	userID := c.QueryInt("user_id", 0)
+	actorID := c.QueryInt("actor_id", 0)
+	if userID < 0 || actorID < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid user id",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	filter := AuditEventFilter{
+		UserID:    uint(userID),
+		ActorID:   uint(actorID),
+		EventType: models.AuditEventType(c.Query("event_type")),
+		IP:        c.Query("ip"),
+	}
+	if filter.EventType != "" && !filter.EventType.IsValid() {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid event type",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	for _, bound := range []struct {
+		param string
+		dest  **time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		raw := c.Query(bound.param)
+		if raw == "" {
+			continue
+		}
+		t, err := parseAuditTime(raw)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"error":      bound.param + " must be an RFC 3339 timestamp or a YYYY-MM-DD date",
+				"error_code": "INVALID_REQUEST",
+			})
+		}
+		*bound.dest = &t
+	}
+
+	page, pageSize := parsePagination(c)
+	events, total, err := ListAuditEvents(filter, (page-1)*pageSize, pageSize)
+	if err != nil {
+		utils.LogWithContext(traceID, adminID).Errorw("Failed to query audit events", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to load audit events",
+			"error_code": "FETCH_FAILED",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success":   true,
+		"data":      ToAuditEventDTOs(events),
+		"page":      page,
+		"page_size": pageSize,
+		"total":     total,
+	})
+}
+
+// ==================== Helpers ====================
+
+// recordAuditEvent appends an event about userID to the audit log.
+// The actor is the authenticated user of the request, if any.
+func recordAuditEvent(c *fiber.Ctx, userID uint, eventType models.AuditEventType, payload fiber.Map) {
+	traceID, _ := c.Locals("trace_id").(string)
+
+	event := &models.AuditEvent{
+		UserID:    userID,
+		EventType: eventType,
+		IP:        c.IP(),
+		UserAgent: truncate(c.Get("User-Agent"), 255),
+		TraceID:   traceID,
+		Payload:   "{}",
+	}
+	if actorID, ok := c.Locals("user_id").(uint); ok {
+		event.ActorID = &actorID
+	}
+	if len(payload) > 0 {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			utils.LogWithContext(traceID, userID).Errorw("Failed to encode audit payload", "event_type", eventType, "error", err)
+		} else {
+			event.Payload = string(data)
+		}
+	}
+
+	if err := CreateAuditEvent(event); err != nil {
+		utils.LogWithContext(traceID, userID).Errorw("Failed to record audit event", "event_type", eventType, "error", err)
+	}
+}
+
+// parseAuditTime accepts an RFC 3339 timestamp or a plain date (midnight UTC)
+func parseAuditTime(raw string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", raw)
+}
+
+func ToAuditEventDTOs(in []models.AuditEvent) []AuditEventDTO {
+	out := make([]AuditEventDTO, 0, len(in))
+	for _, e := range in {
+		out = append(out, AuditEventDTO{
+			ID:        e.ID,
+			UserID:    e.UserID,
+			ActorID:   e.ActorID,
+			EventType: e.EventType,
+			IP:        e.IP,
+			UserAgent: e.UserAgent,
+			TraceID:   e.TraceID,
+			Payload:   json.RawMessage(e.Payload),
+			CreatedAt: e.CreatedAt,
+		})
+	}
+	return out
+}
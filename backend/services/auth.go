@@ -1,11 +1,12 @@
 package services
 
 import (
+	"context"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
+	"github.com/aman1117/backend/models"
 	"github.com/aman1117/backend/utils"
 	"github.com/gofiber/fiber/v2"
 	"golang.org/x/crypto/bcrypt"
@@ -18,6 +19,7 @@ type registerRequest struct {
 	Email    string `json:"email"`
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Timezone string `json:"timezone,omitempty"`
 }
 
 type loginRequest struct {
@@ -70,22 +72,55 @@ func RegisterHandler(c *fiber.Ctx) error {
 		})
 	}
 
+	body.Timezone = strings.TrimSpace(body.Timezone)
+	if body.Timezone == "" {
+		body.Timezone = utils.DefaultTimezone
+	} else if err := utils.ValidateTimezone(body.Timezone); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      err.Error(),
+			"error_code": "INVALID_TIMEZONE",
+		})
+	}
+
 	body.Email = strings.TrimSpace(body.Email)
 	body.Username = strings.ToLower(strings.TrimSpace(body.Username))
 	body.Password = strings.TrimSpace(body.Password)
-	if err := CreateUser(body.Email, body.Username, body.Password); err != nil {
-		utils.Sugar.Warnw("Registration failed", "email", body.Email, "username", body.Username, "error", err)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+	if err := CreateUser(body.Email, body.Username, body.Password, body.Timezone); err != nil {
+		// Only a taken email or username is final, anything else is worth a retry
+		emailTaken, emailErr := EmailExists(body.Email)
+		usernameTaken, usernameErr := UsernameExists(body.Username)
+		if emailErr == nil && usernameErr == nil && (emailTaken || usernameTaken) {
+			utils.Sugar.Warnw("Registration failed", "email", body.Email, "username", body.Username, "error", err)
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"error":      "Could not create user (maybe email/username already used)",
+				"error_code": "USER_EXISTS",
+			})
+		}
+		utils.Sugar.Errorw("Registration failed", "email", body.Email, "username", body.Username, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"success":    false,
-			"error":      "Could not create user (maybe email/username already used)",
-			"error_code": "USER_EXISTS",
+			"error":      "Could not create user, please try again",
+			"error_code": "CREATE_FAILED",
 		})
 	}
 
 	utils.Sugar.Infow("New user registered", "username", body.Username)
+
+	// The account works right away, the verification email is best-effort
+	// and can be re-sent from /auth/resend-verification
+	if user, err := GetUserByIdentifier(body.Email); err == nil && user != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := startEmailVerification(ctx, user); err != nil {
+			utils.LogWithUserID(user.ID).Errorw("Error sending verification email", "error", err)
+		}
+	}
+
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
 		"success": true,
-		"message": "User created successfully.",
+		"message": "User created successfully. Please check your email to verify your address.",
 	})
 
 }
@@ -110,6 +145,19 @@ func LoginHandler(c *fiber.Ctx) error {
 	}
 	body.Password = strings.TrimSpace(body.Password)
 	body.Identifier = strings.TrimSpace(body.Identifier)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Locked or backing off identifiers and IPs are rejected before the password is checked
+	if wait := checkThrottles(ctx,
+		throttleCheck{utils.LoginIdentifierThrottle, utils.ThrottleKey(body.Identifier)},
+		throttleCheck{utils.LoginIPThrottle, c.IP()},
+	); wait > 0 {
+		utils.Sugar.Warnw("Throttled login attempt", "identifier", body.Identifier, "ip", c.IP())
+		return tooManyAttemptsResponse(c, wait)
+	}
+
 	user, err := GetUserByIdentifier(body.Identifier)
 	if err != nil {
 		utils.Sugar.Errorw("Database error during login", "identifier", body.Identifier, "error", err)
@@ -120,8 +168,19 @@ func LoginHandler(c *fiber.Ctx) error {
 		})
 	}
 
+	// Unknown users and wrong passwords get the same response and take the same time
+	var passwordErr error
 	if user == nil {
-		utils.Sugar.Warnw("Login attempt with non-existent user", "identifier", body.Identifier)
+		compareDummyPassword(body.Password)
+	} else {
+		passwordErr = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(body.Password))
+	}
+	if user == nil || passwordErr != nil {
+		utils.Sugar.Warnw("Failed login attempt", "identifier", body.Identifier, "ip", c.IP(), "known_user", user != nil)
+		recordLoginFailure(ctx, body.Identifier, c.IP(), user)
+		if user != nil {
+			recordAuditEvent(c, user.ID, models.AuditLoginFailed, nil)
+		}
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"success":    false,
 			"error":      "Invalid credentials",
@@ -129,47 +188,91 @@ func LoginHandler(c *fiber.Ctx) error {
 		})
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(body.Password)); err != nil {
-		utils.Sugar.Warnw("Invalid password attempt", "identifier", body.Identifier)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success":    false,
-			"error":      "Invalid password",
-			"error_code": "INVALID_PASSWORD",
+	// With 2FA the login is not done yet, the failures are reset once the code is right
+	if !user.TOTPEnabled {
+		resetLoginFailures(ctx, body.Identifier)
+	}
+	return completeLogin(c, user)
+}
+
+// issueTokens starts a new session for user and writes the login token response
+func issueTokens(c *fiber.Ctx, user *models.User) error {
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithFullContext(traceID, user.ID, user.Username)
+
+	// Every login method ends here, so disabled accounts are stopped in one place
+	if user.IsDisabled() {
+		log.Warnw("Login attempt on disabled account", "ip", c.IP())
+		return accountDisabledResponse(c)
+	}
+
+	// Without Redis there is nowhere to keep sessions, so only a plain
+	// access token is issued and it cannot be refreshed or revoked
+	var sessionID, refreshToken string
+	if utils.GetRedis() != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		var err error
+		sessionID, refreshToken, err = utils.CreateSession(ctx, user.ID, utils.SessionInfo{
+			Device:    describeDevice(c.Get("User-Agent")),
+			UserAgent: truncate(c.Get("User-Agent"), 255),
+			IP:        c.IP(),
 		})
+		if err != nil {
+			log.Errorw("Session creation failed", "error", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"error":      "Failed to create session",
+				"error_code": "SESSION_CREATION_FAILED",
+			})
+		}
 	}
 
-	token, exp, err := utils.GenerateToken(user)
+	log.Info("User logged in")
+	recordAuditEvent(c, user.ID, models.AuditLoginSucceeded, nil)
+	return writeTokenResponse(c, user, sessionID, refreshToken)
+}
+
+// writeTokenResponse signs an access token for the session and writes it
+// together with the refresh token (if any)
+func writeTokenResponse(c *fiber.Ctx, user *models.User, sessionID, refreshToken string) error {
+	token, exp, err := utils.GenerateToken(user, sessionID)
 
 	traceID, _ := c.Locals("trace_id").(string)
 	log := utils.LogWithFullContext(traceID, user.ID, user.Username)
 
 	if err != nil {
 		log.Errorw("Token generation failed", "error", err)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"success":    false,
 			"error":      "Failed to generate token",
 			"error_code": "TOKEN_GENERATION_FAILED",
 		})
 	}
-	ttl, err := strconv.Atoi(utils.GetFromEnv("TTL_ACCESS_TOKEN"))
+	ttl, err := utils.AccessTokenTTL()
 	if err != nil {
 		utils.Sugar.Error("TTL_ACCESS_TOKEN env var is invalid")
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"success":    false,
 			"error":      "Failed to generate token",
 			"error_code": "TOKEN_GENERATION_FAILED",
 		})
 	}
 
-	log.Info("User logged in")
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+	response := fiber.Map{
 		"success":      true,
 		"access_token": token,
 		"token_type":   "Bearer",
 		"expires_at":   exp.UTC().Format(time.RFC3339),
-		"expires_in":   int(ttl * 60),
-	})
+		"expires_in":   int(ttl.Seconds()),
+	}
+	if refreshToken != "" {
+		response["refresh_token"] = refreshToken
+		response["refresh_expires_in"] = int(utils.RefreshTokenTTL().Seconds())
+	}
 
+	return c.Status(fiber.StatusOK).JSON(response)
 }
 
 func AuthMiddleware(c *fiber.Ctx) error {
@@ -193,6 +296,10 @@ func AuthMiddleware(c *fiber.Ctx) error {
 
 	tokenStr := strings.TrimSpace(authHeader[len(prefix):])
 
+	if utils.IsPersonalAccessToken(tokenStr) {
+		return authenticatePersonalAccessToken(c, tokenStr)
+	}
+
 	claims, err := utils.ParseToken(tokenStr)
 
 	if err != nil {
@@ -203,13 +310,119 @@ func AuthMiddleware(c *fiber.Ctx) error {
 		})
 	}
 
+	// Tokens tied to a session die with it (logout, logout-all, refresh token reuse)
+	if claims.SessionID != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		active, err := utils.TouchSession(ctx, claims.UserID, claims.SessionID, c.IP())
+		if err != nil {
+			utils.LogWithUserID(claims.UserID).Errorw("Session check failed", "error", err)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"success":    false,
+				"error":      "Could not verify session",
+				"error_code": "INVALID_TOKEN",
+			})
+		}
+		if !active {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"success":    false,
+				"error":      "Session has been revoked",
+				"error_code": "SESSION_REVOKED",
+			})
+		}
+	} else {
+		// Without a session nothing revokes the token, so disabling has to be checked here
+		user, err := GetUserByID(claims.UserID)
+		if err != nil {
+			utils.LogWithUserID(claims.UserID).Errorw("User check failed", "error", err)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"success":    false,
+				"error":      "Could not verify user",
+				"error_code": "INVALID_TOKEN",
+			})
+		}
+		if user == nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"success":    false,
+				"error":      "Invalid token",
+				"error_code": "INVALID_TOKEN",
+			})
+		}
+		if user.IsDisabled() {
+			return accountDisabledResponse(c)
+		}
+	}
+
 	c.Locals("user_id", claims.UserID)
 	c.Locals("username", claims.Username)
+	c.Locals("session_id", claims.SessionID)
+	c.Locals("role", models.Role(claims.Role))
 
 	return c.Next()
 
 }
 
+// RequireRole only lets requests through from enabled users who currently hold one of roles
+// Must run after AuthMiddleware. A token without the role is rejected right away, a
+// token with it is re-checked against the DB so a demotion or disable applies at once.
+// A promotion takes effect with the next token refresh. Personal access tokens never
+// carry a role.
+func RequireRole(roles ...models.Role) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		traceID, _ := c.Locals("trace_id").(string)
+		userID, _ := c.Locals("user_id").(uint)
+		role, _ := c.Locals("role").(models.Role)
+
+		if hasRole(role, roles) {
+			user, err := GetUserByID(userID)
+			if err != nil {
+				utils.LogWithContext(traceID, userID).Errorw("Role check failed", "error", err)
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"success":    false,
+					"error":      "An error occurred. Please try again.",
+					"error_code": "SERVER_ERROR",
+				})
+			}
+			if user != nil && user.IsDisabled() {
+				return accountDisabledResponse(c)
+			}
+			if user != nil && hasRole(user.Role, roles) {
+				c.Locals("role", user.Role)
+				return c.Next()
+			}
+			if user != nil {
+				role = user.Role
+			}
+		}
+
+		utils.LogWithContext(traceID, userID).Warnw("Forbidden by role", "path", c.Path(), "role", role)
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"success":    false,
+			"error":      "You do not have permission to access this resource",
+			"error_code": "FORBIDDEN",
+		})
+	}
+}
+
+func hasRole(role models.Role, roles []models.Role) bool {
+	for _, allowed := range roles {
+		if role == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// accountDisabledResponse rejects logins and refreshes of accounts disabled by an admin
+func accountDisabledResponse(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+		"success":    false,
+		"error":      "This account has been disabled",
+		"error_code": "ACCOUNT_DISABLED",
+	})
+}
+
 func ProtectedHandler(c *fiber.Ctx) error {
 	username, _ := c.Locals("username").(string)
 	userID, _ := c.Locals("user_id").(uint)
@@ -220,6 +433,23 @@ func ProtectedHandler(c *fiber.Ctx) error {
 	})
 }
 
+// JWKSHandler handles GET /.well-known/jwks.json
+// The body is a plain RFC 7517 key set so standard JWT libraries can consume it
+func JWKSHandler(c *fiber.Ctx) error {
+	keys, err := utils.PublicJWKS()
+	if err != nil {
+		utils.Sugar.Errorw("Failed to build JWKS", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to load signing keys",
+			"error_code": "SERVER_ERROR",
+		})
+	}
+
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"keys": keys})
+}
+
 type updateUsernameRequest struct {
 	NewUsername string `json:"new_username"`
 }
@@ -273,7 +503,8 @@ func UpdateUsernameHandler(c *fiber.Ctx) error {
 	// Update username in database
 	traceID, _ := c.Locals("trace_id").(string)
 	log := utils.LogWithContext(traceID, userID)
-	if err := UpdateUsername(userID, newUsername); err != nil {
+	oldUsername, err := UpdateUsername(userID, newUsername)
+	if err != nil {
 		log.Warnw("Username update failed", "new_username", newUsername, "error", err)
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"success":    false,
@@ -283,6 +514,7 @@ func UpdateUsernameHandler(c *fiber.Ctx) error {
 	}
 
 	log.Infow("Username updated", "new_username", newUsername)
+	recordAuditEvent(c, userID, models.AuditUsernameChanged, fiber.Map{"old_username": oldUsername, "new_username": newUsername})
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"success":      true,
 		"message":      "Username updated successfully",
@@ -325,6 +557,7 @@ func UpdatePrivacyHandler(c *fiber.Ctx) error {
 	}
 
 	log.Infow("Privacy updated", "is_private", body.IsPrivate)
+	recordAuditEvent(c, userID, models.AuditPrivacyChanged, fiber.Map{"is_private": body.IsPrivate})
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"success":    true,
 		"message":    "Privacy setting updated",
@@ -332,6 +565,58 @@ func UpdatePrivacyHandler(c *fiber.Ctx) error {
 	})
 }
 
+type updateTimezoneRequest struct {
+	Timezone string `json:"timezone"`
+}
+
+func UpdateTimezoneHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	var body updateTimezoneRequest
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid request body",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	timezone := strings.TrimSpace(body.Timezone)
+	if err := utils.ValidateTimezone(timezone); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      err.Error(),
+			"error_code": "INVALID_TIMEZONE",
+		})
+	}
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+	if err := UpdateTimezone(userID, timezone); err != nil {
+		log.Errorw("Timezone update failed", "timezone", timezone, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to update timezone",
+			"error_code": "UPDATE_FAILED",
+		})
+	}
+
+	log.Infow("Timezone updated", "timezone", timezone)
+	recordAuditEvent(c, userID, models.AuditTimezoneChanged, fiber.Map{"timezone": timezone})
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success":  true,
+		"message":  "Timezone updated",
+		"timezone": timezone,
+	})
+}
+
 func GetPrivacyHandler(c *fiber.Ctx) error {
 	userID, ok := c.Locals("user_id").(uint)
 	if !ok {
@@ -415,6 +700,10 @@ func ChangePasswordHandler(c *fiber.Ctx) error {
 	traceID, _ := c.Locals("trace_id").(string)
 	log := utils.LogWithFullContext(traceID, userID, user.Username)
 
+	if user.NoPassword {
+		return passwordNotSetResponse(c)
+	}
+
 	// Verify current password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(body.CurrentPassword)); err != nil {
 		log.Warn("Invalid current password for password change")
@@ -445,9 +734,98 @@ func ChangePasswordHandler(c *fiber.Ctx) error {
 		})
 	}
 
+	// Keep the device that changed the password signed in, log out everything else
+	currentSessionID, _ := c.Locals("session_id").(string)
+	revokeUserSessions(userID, currentSessionID)
+
 	log.Info("Password changed successfully")
+	recordAuditEvent(c, userID, models.AuditPasswordChanged, nil)
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"success": true,
 		"message": "Password changed successfully",
 	})
 }
+
+type setPasswordRequest struct {
+	NewPassword string `json:"new_password"`
+}
+
+// SetPasswordHandler handles POST /set-password
+// Sets the first password of an account created through an OIDC login, so it can
+// confirm sensitive actions like every other account. Accounts that already have
+// a password use /change-password.
+func SetPasswordHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	var body setPasswordRequest
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid request body",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	body.NewPassword = strings.TrimSpace(body.NewPassword)
+	if len(body.NewPassword) < 8 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Password must be at least 8 characters",
+			"error_code": "PASSWORD_TOO_SHORT",
+		})
+	}
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(body.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		log.Errorw("Failed to hash new password", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to process password",
+			"error_code": "HASH_FAILED",
+		})
+	}
+
+	set, err := SetInitialPassword(userID, string(hashedPassword))
+	if err != nil {
+		log.Errorw("Failed to set password in DB", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to update password",
+			"error_code": "UPDATE_FAILED",
+		})
+	}
+	if !set {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"success":    false,
+			"error":      "This account already has a password, use change password instead",
+			"error_code": "PASSWORD_ALREADY_SET",
+		})
+	}
+
+	log.Info("Password set")
+	recordAuditEvent(c, userID, models.AuditPasswordChanged, nil)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Password set successfully",
+	})
+}
+
+// passwordNotSetResponse answers a password check for an account that has no
+// password of its own yet
+func passwordNotSetResponse(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		"success":    false,
+		"error":      "This account has no password yet, set one first",
+		"error_code": "PASSWORD_NOT_SET",
+	})
+}
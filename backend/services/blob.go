@@ -156,6 +156,7 @@ func UploadProfilePictureHandler(c *fiber.Ctx) error {
 	}
 
 	utils.Sugar.Infow("Profile picture uploaded", "userID", userID, "url", imageURL)
+	recordAuditEvent(c, userID, models.AuditProfilePictureUpdated, fiber.Map{"profile_pic": imageURL})
 
 	return c.JSON(fiber.Map{
 		"success":     true,
@@ -204,6 +205,7 @@ func DeleteProfilePictureHandler(c *fiber.Ctx) error {
 	}
 
 	utils.Sugar.Infow("Profile picture deleted", "userID", userID)
+	recordAuditEvent(c, userID, models.AuditProfilePictureDeleted, nil)
 
 	return c.JSON(fiber.Map{
 		"success": true,
@@ -230,13 +232,39 @@ func GetProfileHandler(c *fiber.Ctx) error {
 	}
 
 	return c.JSON(fiber.Map{
-		"success":     true,
-		"profile_pic": user.ProfilePic,
-		"username":    user.Username,
-		"email":       user.Email,
+		"success":        true,
+		"profile_pic":    user.ProfilePic,
+		"username":       user.Username,
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+		"timezone":       user.Timezone,
+		"role":           user.Role,
+		"has_password":   !user.NoPassword,
+		// Non-null while the account is in its deletion grace period
+		"deletion_requested_at": user.DeletionRequestedAt,
 	})
 }
 
+// deleteProfilePictureBlob removes the blob behind a profile picture URL, if any
+func deleteProfilePictureBlob(profilePic *string) error {
+	if blobClient == nil || profilePic == nil || *profilePic == "" {
+		return nil
+	}
+
+	blobName := extractBlobName(*profilePic)
+	if blobName == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, err := blobClient.DeleteBlob(ctx, containerName, blobName, nil)
+	if err != nil && strings.Contains(err.Error(), "BlobNotFound") {
+		return nil
+	}
+	return err
+}
+
 // Helper function to extract blob name from URL
 func extractBlobName(url string) string {
 	// URL format: https://{account}.blob.core.windows.net/{container}/{blobName}
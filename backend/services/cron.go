@@ -2,37 +2,148 @@ package services
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/aman1117/backend/models"
 	"github.com/aman1117/backend/utils"
+	"gorm.io/gorm/clause"
 )
 
+// SchedulerInterval is how often the timezone bucket jobs are ticked.
+// Every IANA UTC offset is a multiple of 15 minutes, so each bucket's local
+// target time falls inside exactly one tick window.
+const SchedulerInterval = 15 * time.Minute
+
+const (
+	dailyJobHour    = 0 // local midnight closes the user's day
+	reminderJobHour = 9 // local 9 AM sends streak reminders
+)
+
+// Job names the last run of each timezone bucket is recorded under
+const (
+	dailyJobName    = "daily"
+	reminderJobName = "reminder"
+)
+
+const jobRunDateLayout = "2006-01-02"
+
+// GetUserTimezones returns the distinct timezones users have configured
+func GetUserTimezones(ctx context.Context) ([]string, error) {
+	db := utils.GetDB().WithContext(ctx)
+	var timezones []string
+	if err := db.Model(&models.User{}).Distinct("timezone").Pluck("timezone", &timezones).Error; err != nil {
+		return nil, err
+	}
+	return timezones, nil
+}
+
+// dueTimezones returns the timezone buckets whose local clock passed hour:00 today
+// and that have not finished job for their local today yet. A missed tick is caught
+// up on the next one. A bucket without any recorded run only counts as due in its
+// regular tick window, so a fresh deploy does not repeat a run it cannot see.
+func dueTimezones(ctx context.Context, now time.Time, job string, hour int) ([]string, error) {
+	timezones, err := GetUserTimezones(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []models.JobRun
+	if err := utils.GetDB().WithContext(ctx).Where("job = ?", job).Find(&runs).Error; err != nil {
+		return nil, err
+	}
+	lastRun := make(map[string]string, len(runs))
+	for _, run := range runs {
+		lastRun[run.Timezone] = run.LastRunDate.Format(jobRunDateLayout)
+	}
+
+	var due []string
+	for _, tz := range timezones {
+		loc := utils.LoadUserLocation(tz)
+		local := now.In(loc)
+		target := time.Date(local.Year(), local.Month(), local.Day(), hour, 0, 0, 0, loc)
+		if now.Before(target) {
+			continue
+		}
+		last, ok := lastRun[tz]
+		if !ok {
+			if now.Sub(target) < SchedulerInterval {
+				due = append(due, tz)
+			}
+			continue
+		}
+		if last < local.Format(jobRunDateLayout) {
+			due = append(due, tz)
+		}
+	}
+	return due, nil
+}
+
+// markJobRun records that job finished for the timezone bucket on its local today
+func markJobRun(ctx context.Context, job, timezone string, now time.Time) error {
+	local := now.In(utils.LoadUserLocation(timezone))
+	run := models.JobRun{
+		Job:         job,
+		Timezone:    timezone,
+		LastRunDate: time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC),
+	}
+	return utils.GetDB().WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "job"}, {Name: "timezone"}},
+		DoUpdates: clause.AssignmentColumns([]string{"last_run_date", "updated_at"}),
+	}).Create(&run).Error
+}
+
+// RunDailyJob closes the day for every timezone bucket that reached local midnight.
+// A failing bucket is logged and not marked as run, so the next tick retries it.
 func RunDailyJob(ctx context.Context) error {
+	now := time.Now()
+	timezones, err := dueTimezones(ctx, now, dailyJobName, dailyJobHour)
+	if err != nil {
+		return err
+	}
+
+	for _, tz := range timezones {
+		if err := RunDailyJobForTimezone(ctx, tz); err != nil {
+			utils.Sugar.Errorw("Daily job failed for timezone", "timezone", tz, "error", err)
+			continue
+		}
+		if err := markJobRun(ctx, dailyJobName, tz, now); err != nil {
+			utils.Sugar.Errorw("Failed to record daily job run", "timezone", tz, "error", err)
+		}
+	}
+	return nil
+}
+
+// RunDailyJobForTimezone starts a new streak day for all users in the given timezone
+// and pre-fills it with drafts from their auto-fill templates. Running it again for
+// the same day is safe, users that fail are logged and reported in the error.
+func RunDailyJobForTimezone(ctx context.Context, timezone string) error {
 	db := utils.GetDB().WithContext(ctx)
 
 	var users []models.User
-	if err := db.Find(&users).Error; err != nil {
+	if err := db.Where("timezone = ?", timezone).Find(&users).Error; err != nil {
 		return err
 	}
 
-	// Compute today's date in IST once
-	loc, _ := time.LoadLocation("Asia/Kolkata")
-	nowIST := time.Now().In(loc)
-	todayIST := time.Date(
-		nowIST.Year(),
-		nowIST.Month(),
-		nowIST.Day(),
-		0, 0, 0, 0,
-		loc,
-	)
-	utils.Sugar.Debugw("Running daily job", "date", todayIST)
+	loc := utils.LoadUserLocation(timezone)
+	today := utils.StartOfDay(time.Now(), loc)
+	utils.Sugar.Debugw("Running daily job", "timezone", timezone, "date", today, "users", len(users))
+	failed := 0
 	for _, user := range users {
-		if err := AddStreak(user.ID, todayIST, true); err != nil {
-			return err
+		if err := AddStreak(user.ID, today, true); err != nil {
+			utils.Sugar.Errorw("Failed to start streak day", "user_id", user.ID, "timezone", timezone, "error", err)
+			failed++
 		}
 	}
 
+	// Drafts are a convenience, a failure must not hold up closing the day
+	if err := PrefillTemplateDrafts(ctx, timezone, today); err != nil {
+		utils.Sugar.Errorw("Template auto-fill failed", "timezone", timezone, "error", err)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to start the streak day for %d of %d users", failed, len(users))
+	}
 	return nil
 }
 
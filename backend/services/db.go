@@ -2,20 +2,22 @@ package services
 
 import (
 	"errors"
+	"time"
 
 	"github.com/aman1117/backend/models"
 	"github.com/aman1117/backend/utils"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-func CreateUser(email, username, password string) error {
+func CreateUser(email, username, password, timezone string) error {
 	db := utils.GetDB()
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return err
 	}
-	result := db.Exec("INSERT INTO users (email, username, password_hash) VALUES ($1, $2, $3)", email, username, string(hash))
+	result := db.Exec("INSERT INTO users (email, username, password_hash, timezone) VALUES ($1, $2, $3, $4)", email, username, string(hash), timezone)
 	return result.Error
 }
 
@@ -32,10 +34,19 @@ func GetUserByIdentifier(identifier string) (*models.User, error) {
 	return user, nil
 }
 
-func UpdateUsername(userID uint, newUsername string) error {
+// UpdateUsername renames the user and returns the username it replaced
+func UpdateUsername(userID uint, newUsername string) (string, error) {
 	db := utils.GetDB()
-	result := db.Model(&models.User{}).Where("id = ?", userID).Update("username", newUsername)
-	return result.Error
+	var previous string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "username").Where("id = ?", userID).First(&user).Error; err != nil {
+			return err
+		}
+		previous = user.Username
+		return tx.Model(&models.User{}).Where("id = ?", userID).Update("username", newUsername).Error
+	})
+	return previous, err
 }
 
 func UpdatePrivacy(userID uint, isPrivate bool) error {
@@ -44,6 +55,47 @@ func UpdatePrivacy(userID uint, isPrivate bool) error {
 	return result.Error
 }
 
+// EmailExists reports whether any account uses the address (case-insensitive)
+func EmailExists(email string) (bool, error) {
+	db := utils.GetDB()
+	var count int64
+	err := db.Model(&models.User{}).Where("LOWER(email) = LOWER(?)", email).Count(&count).Error
+	return count > 0, err
+}
+
+// UpdateEmail switches the user's address, verified tells whether the address has
+// been proven (a confirmation link) or restored with its previous state (a revert)
+func UpdateEmail(userID uint, email string, verified bool) error {
+	db := utils.GetDB()
+	result := db.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"email":          email,
+		"email_verified": verified,
+	})
+	return result.Error
+}
+
+func SetEmailVerified(userID uint, verified bool) error {
+	db := utils.GetDB()
+	result := db.Model(&models.User{}).Where("id = ?", userID).Update("email_verified", verified)
+	return result.Error
+}
+
+func UpdateTimezone(userID uint, timezone string) error {
+	db := utils.GetDB()
+	result := db.Model(&models.User{}).Where("id = ?", userID).Update("timezone", timezone)
+	return result.Error
+}
+
+// GetUserLocation returns the location of the user's configured timezone
+func GetUserLocation(userID uint) (*time.Location, error) {
+	db := utils.GetDB()
+	var timezone string
+	if err := db.Model(&models.User{}).Where("id = ?", userID).Select("timezone").Scan(&timezone).Error; err != nil {
+		return nil, err
+	}
+	return utils.LoadUserLocation(timezone), nil
+}
+
 func GetUserPrivacy(userID uint) (bool, error) {
 	db := utils.GetDB()
 	var user models.User
@@ -70,3 +122,455 @@ func UpdateUserPassword(userID uint, hashedPassword string) error {
 	result := db.Model(&models.User{}).Where("id = ?", userID).Update("password_hash", hashedPassword)
 	return result.Error
 }
+
+// SetInitialPassword sets the first password of an account created without one
+// Returns false if the account already has a password
+func SetInitialPassword(userID uint, hashedPassword string) (bool, error) {
+	db := utils.GetDB()
+	result := db.Model(&models.User{}).Where("id = ? AND no_password = ?", userID, true).Updates(map[string]interface{}{
+		"password_hash": hashedPassword,
+		"no_password":   false,
+	})
+	return result.RowsAffected == 1, result.Error
+}
+
+// SetPendingTOTPSecret stores a freshly generated secret that is not enabled yet
+func SetPendingTOTPSecret(userID uint, secret string) error {
+	db := utils.GetDB()
+	result := db.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"totp_secret":  secret,
+		"totp_enabled": false,
+	})
+	return result.Error
+}
+
+// EnableTOTP turns on 2FA and replaces the user's recovery codes in one transaction
+func EnableTOTP(userID uint, recoveryCodeHashes []string) error {
+	db := utils.GetDB()
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", userID).Update("totp_enabled", true).Error; err != nil {
+			return err
+		}
+		return replaceRecoveryCodes(tx, userID, recoveryCodeHashes)
+	})
+}
+
+// DisableTOTP turns off 2FA, clears the secret and deletes all recovery codes
+func DisableTOTP(userID uint) error {
+	db := utils.GetDB()
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"totp_secret":  nil,
+			"totp_enabled": false,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+	})
+}
+
+// ReplaceRecoveryCodes invalidates all existing recovery codes and stores new ones
+func ReplaceRecoveryCodes(userID uint, recoveryCodeHashes []string) error {
+	db := utils.GetDB()
+	return db.Transaction(func(tx *gorm.DB) error {
+		return replaceRecoveryCodes(tx, userID, recoveryCodeHashes)
+	})
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uint, recoveryCodeHashes []string) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	codes := make([]models.RecoveryCode, 0, len(recoveryCodeHashes))
+	for _, hash := range recoveryCodeHashes {
+		codes = append(codes, models.RecoveryCode{UserID: userID, CodeHash: hash})
+	}
+	return tx.Create(&codes).Error
+}
+
+// RecoveryCodeUnused reports whether the user has the code and has not used it yet
+func RecoveryCodeUnused(userID uint, codeHash string) (bool, error) {
+	db := utils.GetDB()
+	var count int64
+	err := db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// ConsumeRecoveryCode marks an unused recovery code as used
+// Returns false if the code does not exist or was already used
+func ConsumeRecoveryCode(userID uint, codeHash string) (bool, error) {
+	db := utils.GetDB()
+	result := db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// CountUnusedRecoveryCodes returns how many recovery codes the user has left
+func CountUnusedRecoveryCodes(userID uint) (int64, error) {
+	db := utils.GetDB()
+	var count int64
+	err := db.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+// RequestAccountDeletion starts the deletion grace period for the user
+func RequestAccountDeletion(userID uint, requestedAt time.Time) error {
+	db := utils.GetDB()
+	result := db.Model(&models.User{}).Where("id = ?", userID).Update("deletion_requested_at", requestedAt)
+	return result.Error
+}
+
+// CancelAccountDeletion clears a pending deletion request
+// Returns false if no deletion was pending
+func CancelAccountDeletion(userID uint) (bool, error) {
+	db := utils.GetDB()
+	result := db.Model(&models.User{}).
+		Where("id = ? AND deletion_requested_at IS NOT NULL", userID).
+		Update("deletion_requested_at", nil)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// GetUsersDueForPurge returns users whose deletion was requested before cutoff
+func GetUsersDueForPurge(cutoff time.Time) ([]models.User, error) {
+	db := utils.GetDB()
+	var users []models.User
+	err := db.Where("deletion_requested_at IS NOT NULL AND deletion_requested_at <= ?", cutoff).Find(&users).Error
+	return users, err
+}
+
+// PurgeUser deletes the user and every row that belongs to them in one transaction
+// The deletion request is re-checked so a cancellation racing the purge wins
+func PurgeUser(userID uint, cutoff time.Time) (bool, error) {
+	db := utils.GetDB()
+	purged := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Where("id = ? AND deletion_requested_at IS NOT NULL AND deletion_requested_at <= ?", userID, cutoff).
+			First(&user).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		owned := []interface{}{
+			&models.ActivitySegment{},
+			&models.Activity{},
+			&models.Streak{},
+			&models.TileConfig{},
+			&models.ActivityCategory{},
+			&models.RecoveryCode{},
+			&models.PersonalAccessToken{},
+			&models.UserIdentity{},
+			&models.Passkey{},
+			&models.AuditEvent{},
+			&models.ActivityTimer{},
+			&models.ActivityTemplate{},
+			&models.ActivityRevision{},
+		}
+		for _, model := range owned {
+			if err := tx.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Delete(&models.User{}, userID).Error; err != nil {
+			return err
+		}
+		purged = true
+		return nil
+	})
+	return purged, err
+}
+
+// GetPersonalAccessTokenByHash returns the token with its user, or nil if unknown
+func GetPersonalAccessTokenByHash(tokenHash string) (*models.PersonalAccessToken, error) {
+	db := utils.GetDB()
+	var token models.PersonalAccessToken
+	if err := db.Preload("User").Where("token_hash = ?", tokenHash).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+// TouchPersonalAccessToken records a use of the token, at most once per interval
+func TouchPersonalAccessToken(tokenID uint, ip string, interval time.Duration) error {
+	db := utils.GetDB()
+	now := time.Now()
+	result := db.Model(&models.PersonalAccessToken{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", tokenID, now.Add(-interval)).
+		Updates(map[string]interface{}{
+			"last_used_at": now,
+			"last_used_ip": ip,
+		})
+	return result.Error
+}
+
+// DeletePersonalAccessToken deletes one of the user's tokens
+// Returns false if the token does not exist or belongs to someone else
+func DeletePersonalAccessToken(userID, tokenID uint) (bool, error) {
+	db := utils.GetDB()
+	result := db.Where("id = ? AND user_id = ?", tokenID, userID).Delete(&models.PersonalAccessToken{})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// DeleteAllPersonalAccessTokens revokes every token of the user
+func DeleteAllPersonalAccessTokens(userID uint) error {
+	db := utils.GetDB()
+	return db.Where("user_id = ?", userID).Delete(&models.PersonalAccessToken{}).Error
+}
+
+// UsernameExists reports whether the username is taken
+func UsernameExists(username string) (bool, error) {
+	db := utils.GetDB()
+	var count int64
+	err := db.Model(&models.User{}).Where("username = ?", username).Count(&count).Error
+	return count > 0, err
+}
+
+// GetUserIdentity returns the identity for a provider subject with its user, or nil if not linked
+func GetUserIdentity(provider, subject string) (*models.UserIdentity, error) {
+	db := utils.GetDB()
+	var identity models.UserIdentity
+	if err := db.Preload("User").Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// TouchUserIdentity records a login through the identity
+func TouchUserIdentity(identityID uint) error {
+	db := utils.GetDB()
+	return db.Model(&models.UserIdentity{}).Where("id = ?", identityID).Update("last_used_at", time.Now()).Error
+}
+
+// CreateUserIdentity links a provider subject to an existing user
+func CreateUserIdentity(identity *models.UserIdentity) error {
+	db := utils.GetDB()
+	return db.Create(identity).Error
+}
+
+// CreateOIDCUser creates an account for a first-time OIDC login together with its identity
+// The password is random and marked as unset, the user can set one later through
+// POST /set-password or the password reset flow
+func CreateOIDCUser(user *models.User, identity *models.UserIdentity) error {
+	raw, _, err := utils.GenerateResetToken()
+	if err != nil {
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(raw), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	user.PasswordHash = string(hash)
+	user.NoPassword = true
+
+	db := utils.GetDB()
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		identity.UserID = user.ID
+		return tx.Create(identity).Error
+	})
+}
+
+// SearchUsers returns one page of users for the admin listing, newest first, and the total match count
+// status is "", "active", "disabled" or "pending_deletion"
+func SearchUsers(query, status string, role models.Role, offset, limit int) ([]models.User, int64, error) {
+	db := utils.GetDB()
+	q := db.Model(&models.User{})
+	if query != "" {
+		q = q.Where("username ILIKE ? OR email ILIKE ?", "%"+query+"%", "%"+query+"%")
+	}
+	switch status {
+	case "active":
+		q = q.Where("disabled_at IS NULL AND deletion_requested_at IS NULL")
+	case "disabled":
+		q = q.Where("disabled_at IS NOT NULL")
+	case "pending_deletion":
+		q = q.Where("deletion_requested_at IS NOT NULL")
+	}
+	if role != "" {
+		q = q.Where("role = ?", role)
+	}
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var users []models.User
+	err := q.Order("created_at DESC, id DESC").Offset(offset).Limit(limit).Find(&users).Error
+	return users, total, err
+}
+
+// SetUserDisabled disables (disabledAt set) or re-enables (nil) the account
+// Returns false if the user does not exist or already was in that state
+func SetUserDisabled(userID uint, disabledAt *time.Time) (bool, error) {
+	db := utils.GetDB()
+	q := db.Model(&models.User{}).Where("id = ?", userID)
+	if disabledAt != nil {
+		q = q.Where("disabled_at IS NULL")
+	} else {
+		q = q.Where("disabled_at IS NOT NULL")
+	}
+	result := q.Update("disabled_at", disabledAt)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// GetAdminStats aggregates user and activity numbers for GET /admin/stats
+func GetAdminStats(now time.Time) (*AdminStatsDTO, error) {
+	db := utils.GetDB()
+	stats := &AdminStatsDTO{}
+
+	err := db.Model(&models.User{}).Select(`
+		COUNT(*) AS total_users,
+		COUNT(*) FILTER (WHERE email_verified) AS verified_users,
+		COUNT(*) FILTER (WHERE totp_enabled) AS two_factor_users,
+		COUNT(*) FILTER (WHERE role = ?) AS admin_users,
+		COUNT(*) FILTER (WHERE disabled_at IS NOT NULL) AS disabled_users,
+		COUNT(*) FILTER (WHERE deletion_requested_at IS NOT NULL) AS pending_deletion_users,
+		COUNT(*) FILTER (WHERE created_at >= ?) AS new_users_7d,
+		COUNT(*) FILTER (WHERE created_at >= ?) AS new_users_30d`,
+		models.RoleAdmin, now.AddDate(0, 0, -7), now.AddDate(0, 0, -30),
+	).Scan(stats).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Model(&models.Activity{}).Select(`
+		COUNT(*) AS total_activities,
+		COALESCE(SUM(duration_hours), 0) AS total_hours,
+		COUNT(DISTINCT user_id) FILTER (WHERE activity_date >= ?) AS active_users_7d`,
+		now.AddDate(0, 0, -7).Format("2006-01-02"),
+	).Scan(stats).Error
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// CreateAuditEvent appends an event to the audit log
+func CreateAuditEvent(event *models.AuditEvent) error {
+	db := utils.GetDB()
+	return db.Create(event).Error
+}
+
+// ListAuditEvents returns one page of audit events matching filter, newest first, and the total match count
+func ListAuditEvents(filter AuditEventFilter, offset, limit int) ([]models.AuditEvent, int64, error) {
+	db := utils.GetDB()
+	q := db.Model(&models.AuditEvent{})
+	if filter.UserID != 0 {
+		q = q.Where("user_id = ?", filter.UserID)
+	}
+	if filter.ActorID != 0 {
+		q = q.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.EventType != "" {
+		q = q.Where("event_type = ?", filter.EventType)
+	}
+	if filter.IP != "" {
+		q = q.Where("ip = ?", filter.IP)
+	}
+	if filter.From != nil {
+		q = q.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		q = q.Where("created_at < ?", *filter.To)
+	}
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var events []models.AuditEvent
+	err := q.Order("created_at DESC, id DESC").Offset(offset).Limit(limit).Find(&events).Error
+	return events, total, err
+}
+
+// GetDayTotalHours sums the user's hours on date, leaving out the activity excludeID (0 = none)
+func GetDayTotalHours(userID uint, date time.Time, excludeID uint) (float32, error) {
+	db := utils.GetDB()
+	q := db.Model(&models.Activity{}).Where("user_id = ? AND activity_date = ?", userID, date)
+	if excludeID != 0 {
+		q = q.Where("id <> ?", excludeID)
+	}
+	var total float32
+	err := q.Select("COALESCE(SUM(duration_hours), 0)").Scan(&total).Error
+	return total, err
+}
+
+// GetConfirmedDayHours sums the hours of the user's non-draft entries on date
+func GetConfirmedDayHours(userID uint, date time.Time) (float32, error) {
+	db := utils.GetDB()
+	var total float32
+	err := db.Model(&models.Activity{}).
+		Where("user_id = ? AND activity_date = ? AND draft = ?", userID, date, false).
+		Select("COALESCE(SUM(duration_hours), 0)").
+		Scan(&total).Error
+	return total, err
+}
+
+// ListActivityRevisions returns a page of the entry's revisions, newest first
+func ListActivityRevisions(userID, activityID uint, offset, limit int) ([]models.ActivityRevision, int64, error) {
+	db := utils.GetDB()
+	q := db.Model(&models.ActivityRevision{}).Where("user_id = ? AND activity_id = ?", userID, activityID)
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var revisions []models.ActivityRevision
+	err := q.Order("id DESC").Offset(offset).Limit(limit).Find(&revisions).Error
+	return revisions, total, err
+}
+
+// GetActivityTimer returns the user's running timer, or nil if none is running
+func GetActivityTimer(userID uint) (*models.ActivityTimer, error) {
+	db := utils.GetDB()
+	var timer models.ActivityTimer
+	result := db.Where("user_id = ?", userID).Limit(1).Find(&timer)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, result.Error
+	}
+	return &timer, nil
+}
+
+// DeleteActivityTimer removes the user's running timer
+// Returns false if none was running
+func DeleteActivityTimer(userID uint) (bool, error) {
+	db := utils.GetDB()
+	result := db.Where("user_id = ?", userID).Delete(&models.ActivityTimer{})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// GetActivitySegments returns the segments of the given activities ordered by start
+func GetActivitySegments(activityIDs []uint) ([]models.ActivitySegment, error) {
+	db := utils.GetDB()
+	var segments []models.ActivitySegment
+	err := db.Where("activity_id IN ?", activityIDs).Order("start_at").Find(&segments).Error
+	return segments, err
+}
@@ -0,0 +1,331 @@
+/*
+#Plan: Email Address Change
+
+Endpoints:
+1. POST /change-email (authenticated)
+   - Requires the current password
+   - Always returns the same response, whether or not the new address is taken
+   - If the address is free: store "email_change:<hash>" -> {user_id, new email}
+     for 1 hour and mail a confirmation link to the NEW address
+
+2. POST /auth/confirm-email-change
+   - Consumes the token and switches the email (unique constraint re-checked by the DB)
+   - Mails the OLD address a notice with a revert link ("email_revert:<hash>", 7 days)
+
+3. POST /auth/revert-email-change
+   - Consumes the revert token, restores the old address with its verified state and
+     logs out every session since the change may not have been made by the owner
+
+Security Notes:
+- Responses never reveal whether an address belongs to another account, the confirmation
+  is sent in the background so the timing does not either
+- The new address must prove ownership before it is used for anything
+*/
+
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/aman1117/backend/models"
+	"github.com/aman1117/backend/utils"
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ==================== Request/Response Types ====================
+
+type ChangeEmailRequest struct {
+	NewEmail        string `json:"new_email"`
+	CurrentPassword string `json:"current_password"`
+}
+
+type EmailChangeTokenRequest struct {
+	Token string `json:"token"`
+}
+
+// ==================== Handlers ====================
+
+// ChangeEmailHandler handles POST /change-email
+func ChangeEmailHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	var req ChangeEmailRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid request body",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	req.NewEmail = strings.TrimSpace(req.NewEmail)
+	req.CurrentPassword = strings.TrimSpace(req.CurrentPassword)
+	if req.NewEmail == "" || req.CurrentPassword == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "All fields are required",
+			"error_code": "MISSING_FIELDS",
+		})
+	}
+
+	if addr, err := mail.ParseAddress(req.NewEmail); err != nil || addr.Address != req.NewEmail {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid email address",
+			"error_code": "INVALID_EMAIL",
+		})
+	}
+
+	user, err := GetUserByID(userID)
+	if err != nil || user == nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "User not found",
+			"error_code": "USER_NOT_FOUND",
+		})
+	}
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithFullContext(traceID, userID, user.Username)
+
+	if user.NoPassword {
+		return passwordNotSetResponse(c)
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.CurrentPassword)); err != nil {
+		log.Warn("Invalid current password for email change")
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Current password is incorrect",
+			"error_code": "INVALID_PASSWORD",
+		})
+	}
+
+	if strings.EqualFold(req.NewEmail, user.Email) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "New email is the same as the current one",
+			"error_code": "SAME_EMAIL",
+		})
+	}
+
+	// Same response whether or not the address is taken
+	successResponse := fiber.Map{
+		"success": true,
+		"message": "If this address can be used, a confirmation link has been sent to it.",
+	}
+
+	taken, err := EmailExists(req.NewEmail)
+	if err != nil {
+		log.Errorw("Email availability check failed", "error", err)
+		return c.Status(fiber.StatusOK).JSON(successResponse)
+	}
+	if taken {
+		log.Infow("Email change requested to an address in use")
+		return c.Status(fiber.StatusOK).JSON(successResponse)
+	}
+
+	// The link is stored and mailed in the background, so the response takes as long
+	// as it does for a taken address
+	newEmail, username := req.NewEmail, user.Username
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		rawToken, tokenHash, err := utils.GenerateResetToken()
+		if err != nil {
+			log.Errorw("Error generating email change token", "error", err)
+			return
+		}
+
+		if err := utils.StoreEmailChangeToken(ctx, tokenHash, utils.EmailChangeToken{UserID: userID, Email: newEmail}); err != nil {
+			log.Errorw("Error storing email change token", "error", err)
+			return
+		}
+
+		if err := sendEmailChangeConfirmation(newEmail, username, rawToken); err != nil {
+			log.Errorw("Error sending email change confirmation", "error", err)
+		}
+	}()
+
+	log.Info("Email change requested")
+	recordAuditEvent(c, userID, models.AuditEmailChangeRequested, fiber.Map{"new_email": maskEmail(req.NewEmail)})
+	return c.Status(fiber.StatusOK).JSON(successResponse)
+}
+
+// ConfirmEmailChangeHandler handles POST /auth/confirm-email-change
+func ConfirmEmailChangeHandler(c *fiber.Ctx) error {
+	var req EmailChangeTokenRequest
+	if err := c.BodyParser(&req); err != nil || req.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Confirmation token is required",
+			"error_code": "MISSING_TOKEN",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	payload, err := utils.ConsumeEmailChangeToken(ctx, req.Token)
+	if err != nil {
+		utils.Sugar.Errorw("Error consuming email change token", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "An error occurred. Please try again.",
+			"error_code": "SERVER_ERROR",
+		})
+	}
+	if payload == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid or expired confirmation link",
+			"error_code": "INVALID_TOKEN",
+		})
+	}
+
+	user, err := GetUserByID(payload.UserID)
+	if err != nil || user == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid or expired confirmation link",
+			"error_code": "INVALID_TOKEN",
+		})
+	}
+
+	log := utils.LogWithUser(user.ID, user.Username)
+	oldEmail, oldVerified := user.Email, user.EmailVerified
+
+	// The unique constraint catches an address claimed since the request,
+	// the error stays generic so it does not reveal the other account
+	if err := UpdateEmail(user.ID, payload.Email, true); err != nil {
+		log.Warnw("Email change could not be applied", "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "This email change can no longer be completed",
+			"error_code": "EMAIL_CHANGE_FAILED",
+		})
+	}
+
+	rawToken, tokenHash, err := utils.GenerateResetToken()
+	if err == nil {
+		err = utils.StoreEmailRevertToken(ctx, tokenHash, utils.EmailChangeToken{UserID: user.ID, Email: oldEmail, EmailVerified: oldVerified})
+	}
+	if err == nil {
+		err = sendEmailChangedNotice(oldEmail, user.Username, payload.Email, rawToken)
+	}
+	if err != nil {
+		log.Errorw("Error notifying previous email address", "error", err)
+	}
+
+	log.Info("Email changed")
+	recordAuditEvent(c, user.ID, models.AuditEmailChanged, fiber.Map{"old_email": maskEmail(oldEmail), "new_email": maskEmail(payload.Email)})
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Email updated successfully.",
+		"email":   payload.Email,
+	})
+}
+
+// RevertEmailChangeHandler handles POST /auth/revert-email-change
+func RevertEmailChangeHandler(c *fiber.Ctx) error {
+	var req EmailChangeTokenRequest
+	if err := c.BodyParser(&req); err != nil || req.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Revert token is required",
+			"error_code": "MISSING_TOKEN",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	payload, err := utils.ConsumeEmailRevertToken(ctx, req.Token)
+	if err != nil {
+		utils.Sugar.Errorw("Error consuming email revert token", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "An error occurred. Please try again.",
+			"error_code": "SERVER_ERROR",
+		})
+	}
+	if payload == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid or expired revert link",
+			"error_code": "INVALID_TOKEN",
+		})
+	}
+
+	log := utils.LogWithUserID(payload.UserID)
+	if err := UpdateEmail(payload.UserID, payload.Email, payload.EmailVerified); err != nil {
+		log.Warnw("Email revert could not be applied", "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "This email change can no longer be reverted",
+			"error_code": "EMAIL_REVERT_FAILED",
+		})
+	}
+
+	// Whoever changed the address may still be signed in
+	revokeUserSessions(payload.UserID, "")
+
+	log.Info("Email change reverted")
+	recordAuditEvent(c, payload.UserID, models.AuditEmailChangeReverted, fiber.Map{"email": maskEmail(payload.Email)})
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Your previous email has been restored and all sessions were signed out. We recommend resetting your password.",
+	})
+}
+
+// ==================== Helper Functions ====================
+
+// sendEmailChangeConfirmation mails the confirmation link to the new address
+func sendEmailChangeConfirmation(newEmail, username, token string) error {
+	link := fmt.Sprintf("%s/confirm-email-change?token=%s", frontendURL(), token)
+	return sendActionEmail(newEmail, "Confirm Your New Email - Growth Tracker", actionEmail{
+		Title:       "✉️ Confirm your new email",
+		Username:    username,
+		Intro:       "We received a request to use this address for your Growth Tracker account. Click the button below to confirm the change:",
+		ButtonLabel: "Confirm Email",
+		Link:        link,
+		Expiry:      "1 hour",
+		Notice:      "If you didn't request this change, you can safely ignore this email. Nothing will change.",
+	})
+}
+
+// sendEmailChangedNotice tells the previous address about the change and offers a revert link
+func sendEmailChangedNotice(oldEmail, username, newEmail, token string) error {
+	link := fmt.Sprintf("%s/revert-email-change?token=%s", frontendURL(), token)
+	return sendActionEmail(oldEmail, "Your Email Was Changed - Growth Tracker", actionEmail{
+		Title:       "⚠️ Your email was changed",
+		Username:    username,
+		Intro:       fmt.Sprintf("The email address of your Growth Tracker account was changed to %s. If this was you, no action is needed.", maskEmail(newEmail)),
+		ButtonLabel: "This wasn't me",
+		Link:        link,
+		Expiry:      "7 days",
+		Notice:      "If you didn't make this change, use the button above to restore this address and sign out every session, then reset your password.",
+	})
+}
+
+// maskEmail hides most of the local part, e.g. "jane.doe@example.com" -> "j*******@example.com"
+func maskEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok || len(local) == 0 {
+		return email
+	}
+	return local[:1] + strings.Repeat("*", len(local)-1) + "@" + domain
+}
@@ -0,0 +1,187 @@
+/*
+#Plan: Email Verification
+
+Endpoints:
+1. RegisterHandler sends a verification link right after the user is created
+   - Token generated and hashed exactly like password reset tokens
+   - Stored in Redis as "verify_email:<hash>" -> "<userId>" for 24 hours
+
+2. POST /auth/verify-email
+   - Consumes the token (single-use) and sets users.email_verified
+
+3. POST /auth/resend-verification (authenticated)
+   - Sends a new link, at most once per minute per user
+
+Policy:
+- Streak reminder emails are only sent to verified addresses
+*/
+
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aman1117/backend/models"
+	"github.com/aman1117/backend/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	verificationResendPrefix   = "verify_email_sent:"
+	verificationResendCooldown = time.Minute
+)
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// VerifyEmailHandler handles POST /auth/verify-email
+func VerifyEmailHandler(c *fiber.Ctx) error {
+	var req VerifyEmailRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid request body",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	if req.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Verification token is required",
+			"error_code": "MISSING_TOKEN",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userID, err := utils.ConsumeEmailVerificationToken(ctx, req.Token)
+	if err != nil {
+		utils.Sugar.Errorw("Error consuming verification token", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "An error occurred. Please try again.",
+			"error_code": "SERVER_ERROR",
+		})
+	}
+
+	if userID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid or expired verification link",
+			"error_code": "INVALID_TOKEN",
+		})
+	}
+
+	if err := SetEmailVerified(userID, true); err != nil {
+		utils.LogWithUserID(userID).Errorw("Error marking email verified", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to verify email",
+			"error_code": "UPDATE_FAILED",
+		})
+	}
+
+	utils.LogWithUserID(userID).Info("Email verified")
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Email verified successfully.",
+	})
+}
+
+// ResendVerificationHandler handles POST /auth/resend-verification
+func ResendVerificationHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	user, err := GetUserByID(userID)
+	if err != nil || user == nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "User not found",
+			"error_code": "USER_NOT_FOUND",
+		})
+	}
+
+	if user.EmailVerified {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Email is already verified",
+			"error_code": "ALREADY_VERIFIED",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+
+	if redis := utils.GetRedis(); redis != nil {
+		key := fmt.Sprintf("%s%d", verificationResendPrefix, userID)
+		allowed, err := redis.SetNX(ctx, key, 1, verificationResendCooldown).Result()
+		if err != nil {
+			log.Errorw("Verification cooldown check failed", "error", err)
+		} else if !allowed {
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"success":    false,
+				"error":      "Please wait a minute before requesting another email",
+				"error_code": "TOO_MANY_REQUESTS",
+			})
+		}
+	}
+
+	if err := startEmailVerification(ctx, user); err != nil {
+		log.Errorw("Error sending verification email", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to send verification email",
+			"error_code": "EMAIL_FAILED",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Verification email sent.",
+	})
+}
+
+// ==================== Helper Functions ====================
+
+// startEmailVerification generates a verification token for the user's current email and sends it
+func startEmailVerification(ctx context.Context, user *models.User) error {
+	rawToken, tokenHash, err := utils.GenerateResetToken()
+	if err != nil {
+		return err
+	}
+
+	if err := utils.StoreEmailVerificationToken(ctx, tokenHash, user.ID); err != nil {
+		return err
+	}
+
+	return sendVerificationEmail(user.Email, user.Username, rawToken)
+}
+
+// sendVerificationEmail sends the email verification link via Resend
+func sendVerificationEmail(email, username, token string) error {
+	link := fmt.Sprintf("%s/verify-email?token=%s", frontendURL(), token)
+	return sendActionEmail(email, "Verify Your Email - Growth Tracker", actionEmail{
+		Title:       "✉️ Verify your email",
+		Username:    username,
+		Intro:       "Thanks for joining Growth Tracker! Please confirm that this is your email address so we can send you reminders and account notices.",
+		ButtonLabel: "Verify Email",
+		Link:        link,
+		Expiry:      "24 hours",
+		Notice:      "If you didn't create a Growth Tracker account, you can safely ignore this email.",
+	})
+}
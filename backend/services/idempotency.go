@@ -0,0 +1,174 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aman1117/backend/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+)
+
+// idempotentHeaders are the response headers stored and replayed with the body
+var idempotentHeaders = []string{fiber.HeaderContentType, fiber.HeaderETag}
+
+// IdempotencyMiddleware makes a write safe to retry: a request carrying an
+// Idempotency-Key header runs once and every retry with the same key and payload
+// gets the stored response (see utils/idempotency.go). Reusing a key for a
+// different payload is rejected. Requests without the header pass through, and
+// so does everything when Redis is unavailable.
+// Register it after AuthMiddleware so keys are scoped to the user, not the IP.
+func IdempotencyMiddleware(c *fiber.Ctx) error {
+	key := c.Get(IdempotencyKeyHeader)
+	if key == "" || utils.GetRedis() == nil {
+		return c.Next()
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Idempotency-Key cannot be longer than 255 characters",
+			"error_code": "INVALID_IDEMPOTENCY_KEY",
+		})
+	}
+
+	traceID, _ := c.Locals("trace_id").(string)
+	userID, _ := c.Locals("user_id").(uint)
+	log := utils.LogWithContext(traceID, userID)
+
+	// Unauthenticated routes fall back to the client IP, so two clients picking
+	// the same key never see each other's responses
+	scope := "ip:" + c.IP()
+	if userID != 0 {
+		scope = "user:" + strconv.FormatUint(uint64(userID), 10)
+	}
+	redisKey := utils.IdempotencyKey(scope, key)
+
+	fingerprint, err := requestFingerprint(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid request body",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	record, err := utils.ClaimIdempotencyKey(c.Context(), redisKey, fingerprint)
+	if err != nil {
+		log.Errorw("Idempotency key claim failed", "error", err)
+		return c.Next()
+	}
+	if record != nil {
+		if record.Fingerprint != fingerprint {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"success":    false,
+				"error":      "This Idempotency-Key was already used for a different request",
+				"error_code": "IDEMPOTENCY_KEY_REUSED",
+			})
+		}
+		if !record.Completed() {
+			c.Set(fiber.HeaderRetryAfter, "1")
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"success":    false,
+				"error":      "A request with this Idempotency-Key is still being processed",
+				"error_code": "IDEMPOTENCY_KEY_IN_USE",
+			})
+		}
+
+		log.Debugw("Replaying idempotent response", "path", c.Path(), "status", record.Status)
+		for name, value := range record.Headers {
+			c.Set(name, value)
+		}
+		c.Set(idempotencyReplayedHeader, "true")
+		return c.Status(record.Status).Send(record.Body)
+	}
+
+	if err := c.Next(); err != nil {
+		if releaseErr := utils.ReleaseIdempotencyKey(c.Context(), redisKey); releaseErr != nil {
+			log.Errorw("Idempotency key release failed", "error", releaseErr)
+		}
+		return err
+	}
+
+	// Server errors are not final, the client should be able to retry them
+	status := c.Response().StatusCode()
+	if status >= fiber.StatusInternalServerError {
+		if err := utils.ReleaseIdempotencyKey(c.Context(), redisKey); err != nil {
+			log.Errorw("Idempotency key release failed", "error", err)
+		}
+		return nil
+	}
+
+	stored := utils.IdempotencyRecord{
+		Fingerprint: fingerprint,
+		Status:      status,
+		Headers:     map[string]string{},
+		Body:        append([]byte(nil), c.Response().Body()...),
+	}
+	for _, name := range idempotentHeaders {
+		if value := c.GetRespHeader(name); value != "" {
+			stored.Headers[name] = value
+		}
+	}
+	if err := utils.CompleteIdempotencyKey(c.Context(), redisKey, stored); err != nil {
+		log.Errorw("Idempotency response store failed", "error", err)
+	}
+	return nil
+}
+
+// requestFingerprint hashes what makes two requests the same: method, path and
+// payload. Multipart bodies are hashed by their fields and file contents since a
+// retry usually comes with a new boundary.
+func requestFingerprint(c *fiber.Ctx) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", c.Method(), c.Path())
+
+	if !strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEMultipartForm) {
+		h.Write(c.Body())
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		return "", err
+	}
+
+	names := make([]string, 0, len(form.Value))
+	for name := range form.Value {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(h, "value %q %q\n", name, form.Value[name])
+	}
+
+	names = names[:0]
+	for name := range form.File {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, header := range form.File[name] {
+			fmt.Fprintf(h, "file %q %q %d\n", name, header.Filename, header.Size)
+			file, err := header.Open()
+			if err != nil {
+				return "", err
+			}
+			_, err = io.Copy(h, file)
+			file.Close()
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
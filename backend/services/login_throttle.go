@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aman1117/backend/models"
+	"github.com/aman1117/backend/utils"
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	dummyPasswordHash     []byte
+	dummyPasswordHashOnce sync.Once
+)
+
+type throttleCheck struct {
+	policy utils.ThrottlePolicy
+	key    string
+}
+
+// checkThrottles returns the longest wait of the given checks
+// Redis errors are logged and let the request through
+func checkThrottles(ctx context.Context, checks ...throttleCheck) time.Duration {
+	var wait time.Duration
+	for _, check := range checks {
+		w, err := utils.CheckThrottle(ctx, check.policy, check.key)
+		if err != nil {
+			utils.Sugar.Errorw("Throttle check failed", "scope", check.policy.Scope, "error", err)
+			continue
+		}
+		if w > wait {
+			wait = w
+		}
+	}
+	return wait
+}
+
+// tooManyAttemptsResponse writes a 429 with a Retry-After header
+func tooManyAttemptsResponse(c *fiber.Ctx, wait time.Duration) error {
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"success":     false,
+		"error":       "Too many attempts. Please try again later.",
+		"error_code":  "TOO_MANY_ATTEMPTS",
+		"retry_after": seconds,
+	})
+}
+
+// recordLoginFailure counts a failed login for the identifier and the client IP
+// and emails the account owner when the identifier gets locked
+func recordLoginFailure(ctx context.Context, identifier, ip string, user *models.User) {
+	if _, err := utils.RecordThrottleFailure(ctx, utils.LoginIPThrottle, ip); err != nil {
+		utils.Sugar.Errorw("Failed to record login failure for IP", "ip", ip, "error", err)
+	}
+
+	locked, err := utils.RecordThrottleFailure(ctx, utils.LoginIdentifierThrottle, utils.ThrottleKey(identifier))
+	if err != nil {
+		utils.Sugar.Errorw("Failed to record login failure", "identifier", identifier, "error", err)
+		return
+	}
+	if !locked || user == nil {
+		return
+	}
+
+	log := utils.LogWithUser(user.ID, user.Username)
+	log.Warnw("Account temporarily locked after failed logins", "ip", ip)
+	if err := sendAccountLockedEmail(user.Email, user.Username, ip, utils.LoginIdentifierThrottle.LockoutDuration); err != nil {
+		log.Errorw("Error sending account locked email", "error", err)
+	}
+}
+
+// resetLoginFailures clears the identifier's failure counter after a successful login
+func resetLoginFailures(ctx context.Context, identifier string) {
+	if err := utils.ResetThrottle(ctx, utils.LoginIdentifierThrottle, utils.ThrottleKey(identifier)); err != nil {
+		utils.Sugar.Errorw("Failed to reset login failures", "identifier", identifier, "error", err)
+	}
+}
+
+// secondFactorThrottleChecks are the throttles a second factor attempt for user must pass
+func secondFactorThrottleChecks(user *models.User, ip string) []throttleCheck {
+	return []throttleCheck{
+		{utils.MFAUserThrottle, mfaThrottleKey(user.ID)},
+		{utils.LoginIPThrottle, ip},
+	}
+}
+
+// recordSecondFactorFailure counts an invalid 2FA code for the user and the client IP
+// and emails the account owner when the user gets locked
+func recordSecondFactorFailure(ctx context.Context, ip string, user *models.User) {
+	if _, err := utils.RecordThrottleFailure(ctx, utils.LoginIPThrottle, ip); err != nil {
+		utils.Sugar.Errorw("Failed to record second factor failure for IP", "ip", ip, "error", err)
+	}
+
+	log := utils.LogWithUser(user.ID, user.Username)
+	locked, err := utils.RecordThrottleFailure(ctx, utils.MFAUserThrottle, mfaThrottleKey(user.ID))
+	if err != nil {
+		log.Errorw("Failed to record second factor failure", "error", err)
+		return
+	}
+	if !locked {
+		return
+	}
+
+	log.Warnw("Account temporarily locked after failed second factor codes", "ip", ip)
+	if err := sendAccountLockedEmail(user.Email, user.Username, ip, utils.MFAUserThrottle.LockoutDuration); err != nil {
+		log.Errorw("Error sending account locked email", "error", err)
+	}
+}
+
+// resetSecondFactorFailures clears the user's code failures and, since the login is
+// only complete now, the password failures of both identifiers it may have used
+func resetSecondFactorFailures(ctx context.Context, user *models.User) {
+	if err := utils.ResetThrottle(ctx, utils.MFAUserThrottle, mfaThrottleKey(user.ID)); err != nil {
+		utils.LogWithUser(user.ID, user.Username).Errorw("Failed to reset second factor failures", "error", err)
+	}
+	resetLoginFailures(ctx, user.Email)
+	resetLoginFailures(ctx, user.Username)
+}
+
+func mfaThrottleKey(userID uint) string {
+	return strconv.FormatUint(uint64(userID), 10)
+}
+
+// compareDummyPassword spends the same time as a real bcrypt check so
+// unknown identifiers cannot be told apart by response time
+func compareDummyPassword(password string) {
+	dummyPasswordHashOnce.Do(func() {
+		dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+	})
+	_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+}
+
+// sendAccountLockedEmail tells the owner that sign-in was locked and suggests a reset
+func sendAccountLockedEmail(email, username, ip string, lockout time.Duration) error {
+	link := fmt.Sprintf("%s/forgot-password", frontendURL())
+	minutes := int(lockout.Minutes())
+	return sendActionEmail(email, "Sign-in Temporarily Locked - Growth Tracker", actionEmail{
+		Title:       "🔒 Sign-in temporarily locked",
+		Username:    username,
+		Intro:       fmt.Sprintf("We noticed several failed sign-in attempts on your Growth Tracker account (last from IP %s), so signing in is paused for %d minutes.", ip, minutes),
+		ButtonLabel: "Reset Password",
+		Link:        link,
+		Notice:      "If this was you, just wait and try again. If not, we recommend resetting your password.",
+	})
+}
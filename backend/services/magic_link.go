@@ -0,0 +1,165 @@
+/*
+#Plan: Magic-Link Email Login
+
+Endpoints:
+1. POST /auth/magic-link
+   - Always returns same response (security: don't reveal if user exists)
+   - If user exists: generate token, store hash in Redis as "magic_link:<hash>"
+     for 10 minutes, send the login link
+   - Throttled per email and per IP like /auth/forgot-password
+
+2. POST /auth/magic-link/verify
+   - Consumes the token (single-use) and logs in through completeLogin,
+     so 2FA still applies and the response matches /login
+   - Marks the email verified, opening the link proves access to the inbox
+*/
+
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aman1117/backend/models"
+	"github.com/aman1117/backend/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+type MagicLinkRequest struct {
+	Email string `json:"email"`
+}
+
+type MagicLinkVerifyRequest struct {
+	Token string `json:"token"`
+}
+
+// RequestMagicLinkHandler handles POST /auth/magic-link
+func RequestMagicLinkHandler(c *fiber.Ctx) error {
+	// Generic success response (used for both existing and non-existing users)
+	successResponse := fiber.Map{
+		"success": true,
+		"message": "If an account exists with this email, a login link has been sent.",
+	}
+
+	var req MagicLinkRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusOK).JSON(successResponse)
+	}
+
+	req.Email = strings.TrimSpace(req.Email)
+	if req.Email == "" {
+		return c.Status(fiber.StatusOK).JSON(successResponse)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Throttled whether or not the account exists, so a 429 does not reveal anything either
+	emailKey := utils.ThrottleKey(req.Email)
+	if wait := checkThrottles(ctx,
+		throttleCheck{utils.MagicLinkEmailThrottle, emailKey},
+		throttleCheck{utils.MagicLinkIPThrottle, c.IP()},
+	); wait > 0 {
+		utils.Sugar.Warnw("Throttled login link request", "ip", c.IP())
+		return tooManyAttemptsResponse(c, wait)
+	}
+	if _, err := utils.RecordThrottleFailure(ctx, utils.MagicLinkEmailThrottle, emailKey); err != nil {
+		utils.Sugar.Errorw("Failed to record login link request", "error", err)
+	}
+	if _, err := utils.RecordThrottleFailure(ctx, utils.MagicLinkIPThrottle, c.IP()); err != nil {
+		utils.Sugar.Errorw("Failed to record login link request", "error", err)
+	}
+
+	db := utils.GetDB()
+	var user models.User
+	if err := db.Where("email = ?", req.Email).First(&user).Error; err != nil {
+		// User doesn't exist - return same success response
+		return c.Status(fiber.StatusOK).JSON(successResponse)
+	}
+
+	rawToken, tokenHash, err := utils.GenerateResetToken()
+	if err != nil {
+		utils.Sugar.Errorw("Error generating login link token", "user_id", user.ID, "error", err)
+		return c.Status(fiber.StatusOK).JSON(successResponse)
+	}
+
+	if err := utils.StoreMagicLinkToken(ctx, tokenHash, user.ID); err != nil {
+		utils.Sugar.Errorw("Error storing login link token", "user_id", user.ID, "error", err)
+		return c.Status(fiber.StatusOK).JSON(successResponse)
+	}
+
+	if err := sendMagicLinkEmail(user.Email, user.Username, rawToken); err != nil {
+		utils.Sugar.Errorw("Error sending login link email", "user_id", user.ID, "error", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(successResponse)
+}
+
+// VerifyMagicLinkHandler handles POST /auth/magic-link/verify
+func VerifyMagicLinkHandler(c *fiber.Ctx) error {
+	var req MagicLinkVerifyRequest
+	if err := c.BodyParser(&req); err != nil || req.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Login token is required",
+			"error_code": "MISSING_TOKEN",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userID, err := utils.ConsumeMagicLinkToken(ctx, req.Token)
+	if err != nil {
+		utils.Sugar.Errorw("Error consuming login link token", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "An error occurred. Please try again.",
+			"error_code": "SERVER_ERROR",
+		})
+	}
+
+	if userID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid or expired login link",
+			"error_code": "INVALID_TOKEN",
+		})
+	}
+
+	user, err := GetUserByID(userID)
+	if err != nil || user == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid or expired login link",
+			"error_code": "INVALID_TOKEN",
+		})
+	}
+
+	if !user.EmailVerified {
+		if err := SetEmailVerified(user.ID, true); err != nil {
+			utils.LogWithUserID(user.ID).Warnw("Failed to mark email verified", "error", err)
+		} else {
+			user.EmailVerified = true
+		}
+	}
+
+	utils.LogWithUser(user.ID, user.Username).Info("Login link used")
+	return completeLogin(c, user)
+}
+
+// sendMagicLinkEmail sends the passwordless login link via Resend
+func sendMagicLinkEmail(email, username, token string) error {
+	link := fmt.Sprintf("%s/magic-login?token=%s", frontendURL(), token)
+	return sendActionEmail(email, "Your Login Link - Growth Tracker", actionEmail{
+		Title:       "🔑 Log in to Growth Tracker",
+		Username:    username,
+		Intro:       "Click the button below to log in. No password needed:",
+		ButtonLabel: "Log In",
+		Link:        link,
+		Expiry:      "10 minutes",
+		Notice:      "If you didn't request this link, you can safely ignore this email. The link can only be used once.",
+	})
+}
@@ -0,0 +1,501 @@
+/*
+#Plan: OpenID Connect Login & Account Linking
+
+Endpoints:
+1. GET /auth/oidc/providers
+   - Lists configured providers (see utils/oidc.go for the environment variables)
+
+2. POST /auth/oidc/:provider/start
+   - Returns the authorization URL to redirect the browser to
+
+3. POST /auth/oidc/:provider/link (authenticated)
+   - Same as start, but the callback links the provider to the current account
+
+4. POST /auth/oidc/:provider/callback
+   - { code, state, timezone? } posted by the frontend page at the redirect URL
+   - Known identity: logs in through completeLogin (2FA still applies)
+   - Link state: stores the identity for the account that started the flow
+   - Unknown identity: creates an account with a username suggested from the
+     provider's profile (validUsername rules, numeric suffix if taken). The account
+     has no password, POST /set-password adds one for password confirmations
+   - If the email already belongs to an account nothing is linked automatically,
+     the owner has to log in and use /link so an identity provider cannot take over accounts
+
+5. GET /auth/identities, DELETE /auth/identities/:id (authenticated)
+   - List and unlink connected providers
+*/
+
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/aman1117/backend/models"
+	"github.com/aman1117/backend/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 20
+)
+
+type OIDCCallbackRequest struct {
+	Code     string `json:"code"`
+	State    string `json:"state"`
+	Timezone string `json:"timezone,omitempty"`
+}
+
+type UserIdentityDTO struct {
+	ID         uint       `json:"id"`
+	Provider   string     `json:"provider"`
+	Email      string     `json:"email"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// ==================== Handlers ====================
+
+// ListOIDCProvidersHandler handles GET /auth/oidc/providers
+func ListOIDCProvidersHandler(c *fiber.Ctx) error {
+	providers := utils.GetOIDCProviders()
+	data := make([]fiber.Map, 0, len(providers))
+	for _, p := range providers {
+		data = append(data, fiber.Map{
+			"name":         p.Name,
+			"display_name": p.DisplayName,
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"data":    data,
+	})
+}
+
+// StartOIDCLoginHandler handles POST /auth/oidc/:provider/start
+func StartOIDCLoginHandler(c *fiber.Ctx) error {
+	return startOIDCFlow(c, 0)
+}
+
+// StartOIDCLinkHandler handles POST /auth/oidc/:provider/link
+func StartOIDCLinkHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+	return startOIDCFlow(c, userID)
+}
+
+// OIDCCallbackHandler handles POST /auth/oidc/:provider/callback
+func OIDCCallbackHandler(c *fiber.Ctx) error {
+	var req OIDCCallbackRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid request body",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	if req.Code == "" || req.State == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Code and state are required",
+			"error_code": "MISSING_FIELDS",
+		})
+	}
+
+	provider, err := utils.GetOIDCProvider(c.Params("provider"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unknown login provider",
+			"error_code": "PROVIDER_NOT_FOUND",
+		})
+	}
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithTrace(traceID).With("provider", provider.Name)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	state, err := utils.ConsumeOIDCState(ctx, req.State)
+	if err != nil {
+		log.Errorw("OIDC state lookup failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "An error occurred. Please try again.",
+			"error_code": "SERVER_ERROR",
+		})
+	}
+	if state == nil || state.Provider != provider.Name {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid or expired login attempt, please start again",
+			"error_code": "INVALID_STATE",
+		})
+	}
+
+	claims, err := utils.ExchangeOIDCCode(ctx, provider, req.Code, state)
+	if err != nil {
+		log.Warnw("OIDC code exchange failed", "error", err)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Could not verify your login with the provider",
+			"error_code": "OIDC_LOGIN_FAILED",
+		})
+	}
+
+	identity, err := GetUserIdentity(provider.Name, claims.Subject)
+	if err != nil {
+		log.Errorw("Identity lookup failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "An error occurred. Please try again.",
+			"error_code": "SERVER_ERROR",
+		})
+	}
+
+	if state.LinkUserID != 0 {
+		return linkOIDCIdentity(c, provider, claims, identity, state.LinkUserID)
+	}
+
+	if identity != nil {
+		if err := TouchUserIdentity(identity.ID); err != nil {
+			log.Warnw("Failed to record identity use", "error", err)
+		}
+		log.Infow("OIDC login", "user_id", identity.UserID)
+		return completeLogin(c, &identity.User)
+	}
+
+	return registerOIDCUser(c, provider, claims, req.Timezone)
+}
+
+// ListIdentitiesHandler handles GET /auth/identities
+func ListIdentitiesHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	db := utils.GetDB()
+	var identities []models.UserIdentity
+	if err := db.Where("user_id = ?", userID).Order("created_at ASC").Find(&identities).Error; err != nil {
+		traceID, _ := c.Locals("trace_id").(string)
+		utils.LogWithContext(traceID, userID).Errorw("Identity fetch failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to fetch linked accounts",
+			"error_code": "FETCH_FAILED",
+		})
+	}
+
+	data := make([]UserIdentityDTO, 0, len(identities))
+	for _, identity := range identities {
+		data = append(data, ToUserIdentityDTO(identity))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"data":    data,
+	})
+}
+
+// UnlinkIdentityHandler handles DELETE /auth/identities/:id
+func UnlinkIdentityHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	identityID, err := c.ParamsInt("id")
+	if err != nil || identityID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid identity id",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+
+	db := utils.GetDB()
+	result := db.Where("id = ? AND user_id = ?", identityID, userID).Delete(&models.UserIdentity{})
+	if result.Error != nil {
+		log.Errorw("Identity unlink failed", "error", result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to unlink account",
+			"error_code": "DELETE_FAILED",
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Linked account not found",
+			"error_code": "IDENTITY_NOT_FOUND",
+		})
+	}
+
+	log.Infow("Identity unlinked", "identity_id", identityID)
+	recordAuditEvent(c, userID, models.AuditIdentityUnlinked, fiber.Map{"identity_id": identityID})
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Account unlinked",
+	})
+}
+
+// ==================== Helper Functions ====================
+
+// startOIDCFlow writes the authorization URL for the provider in the route,
+// linkUserID is 0 for a login
+func startOIDCFlow(c *fiber.Ctx, linkUserID uint) error {
+	provider, err := utils.GetOIDCProvider(c.Params("provider"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unknown login provider",
+			"error_code": "PROVIDER_NOT_FOUND",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	authURL, _, err := utils.StartOIDCLogin(ctx, provider, linkUserID)
+	if err != nil {
+		traceID, _ := c.Locals("trace_id").(string)
+		utils.LogWithTrace(traceID).Errorw("OIDC start failed", "provider", provider.Name, "error", err)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Login provider is unavailable",
+			"error_code": "PROVIDER_UNAVAILABLE",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success":           true,
+		"authorization_url": authURL,
+		"expires_in":        int(utils.OIDCStateTTL.Seconds()),
+	})
+}
+
+// linkOIDCIdentity attaches the verified subject to the account that started the link flow
+func linkOIDCIdentity(c *fiber.Ctx, provider *utils.OIDCProvider, claims *utils.OIDCClaims, existing *models.UserIdentity, userID uint) error {
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID).With("provider", provider.Name)
+
+	if existing != nil {
+		if existing.UserID == userID {
+			return c.Status(fiber.StatusOK).JSON(fiber.Map{
+				"success": true,
+				"message": "Account already linked",
+				"data":    ToUserIdentityDTO(*existing),
+			})
+		}
+		log.Warn("OIDC identity already linked to another account")
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"success":    false,
+			"error":      "This account is already linked to another user",
+			"error_code": "IDENTITY_IN_USE",
+		})
+	}
+
+	identity := models.UserIdentity{
+		UserID:   userID,
+		Provider: provider.Name,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+	if err := CreateUserIdentity(&identity); err != nil {
+		log.Errorw("Identity link failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to link account",
+			"error_code": "LINK_FAILED",
+		})
+	}
+
+	log.Info("OIDC identity linked")
+	recordAuditEvent(c, userID, models.AuditIdentityLinked, fiber.Map{"provider": provider.Name, "identity_id": identity.ID})
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Account linked",
+		"data":    ToUserIdentityDTO(identity),
+	})
+}
+
+// registerOIDCUser creates an account for a first-time provider login and logs it in
+func registerOIDCUser(c *fiber.Ctx, provider *utils.OIDCProvider, claims *utils.OIDCClaims, timezone string) error {
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithTrace(traceID).With("provider", provider.Name)
+
+	email := strings.TrimSpace(claims.Email)
+	if email == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "The provider did not share an email address",
+			"error_code": "EMAIL_REQUIRED",
+		})
+	}
+
+	taken, err := EmailExists(email)
+	if err != nil {
+		log.Errorw("Email lookup failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "An error occurred. Please try again.",
+			"error_code": "SERVER_ERROR",
+		})
+	}
+	if taken {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"success":    false,
+			"error":      "An account with this email already exists. Log in and link this provider from your settings.",
+			"error_code": "ACCOUNT_EXISTS",
+		})
+	}
+
+	timezone = strings.TrimSpace(timezone)
+	if timezone == "" || utils.ValidateTimezone(timezone) != nil {
+		timezone = utils.DefaultTimezone
+	}
+
+	localPart, _, _ := strings.Cut(email, "@")
+	username, err := suggestUsername(claims.PreferredUsername, localPart, claims.Name)
+	if err != nil {
+		log.Errorw("Username suggestion failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "An error occurred. Please try again.",
+			"error_code": "SERVER_ERROR",
+		})
+	}
+
+	user := models.User{
+		Email:         email,
+		EmailVerified: claims.EmailVerified != nil && *claims.EmailVerified,
+		Username:      username,
+		Timezone:      timezone,
+	}
+	identity := models.UserIdentity{
+		Provider: provider.Name,
+		Subject:  claims.Subject,
+		Email:    email,
+	}
+	if err := CreateOIDCUser(&user, &identity); err != nil {
+		log.Warnw("OIDC registration failed", "username", username, "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Could not create user (maybe email/username already used)",
+			"error_code": "USER_EXISTS",
+		})
+	}
+
+	utils.Sugar.Infow("New user registered", "username", username, "provider", provider.Name)
+
+	if !user.EmailVerified {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := startEmailVerification(ctx, &user); err != nil {
+			utils.LogWithUserID(user.ID).Errorw("Error sending verification email", "error", err)
+		}
+	}
+
+	return completeLogin(c, &user)
+}
+
+// suggestUsername turns the first usable candidate into a free username that
+// satisfies validUsername, adding a numeric suffix when the name is taken
+func suggestUsername(candidates ...string) (string, error) {
+	base := ""
+	for _, candidate := range candidates {
+		if base = sanitizeUsername(candidate); base != "" {
+			break
+		}
+	}
+	if base == "" {
+		base = "user"
+	}
+
+	if taken, err := UsernameExists(base); err != nil {
+		return "", err
+	} else if !taken && len(base) >= minUsernameLength {
+		return base, nil
+	}
+
+	for i := 0; i < 10; i++ {
+		n, err := rand.Int(rand.Reader, big.NewInt(10000))
+		if err != nil {
+			return "", err
+		}
+		suffix := fmt.Sprintf("%04d", n.Int64())
+		name := base
+		if len(name)+len(suffix) > maxUsernameLength {
+			name = name[:maxUsernameLength-len(suffix)]
+		}
+		name += suffix
+
+		taken, err := UsernameExists(name)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return name, nil
+		}
+	}
+	return "", errors.New("no free username found")
+}
+
+// sanitizeUsername lowercases s and drops everything validUsername does not allow
+func sanitizeUsername(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(s)) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '.':
+			b.WriteRune(r)
+		case r == ' ' || r == '-':
+			b.WriteRune('_')
+		}
+	}
+
+	name := strings.Trim(b.String(), "._")
+	if len(name) > maxUsernameLength {
+		name = strings.TrimRight(name[:maxUsernameLength], "._")
+	}
+	if !validUsername.MatchString(name) {
+		return ""
+	}
+	return name
+}
+
+func ToUserIdentityDTO(identity models.UserIdentity) UserIdentityDTO {
+	return UserIdentityDTO{
+		ID:         identity.ID,
+		Provider:   identity.Provider,
+		Email:      identity.Email,
+		CreatedAt:  identity.CreatedAt,
+		LastUsedAt: identity.LastUsedAt,
+	}
+}
@@ -0,0 +1,583 @@
+/*
+#Plan: Passkeys (WebAuthn)
+
+Configuration:
+- WEBAUTHN_RP_ID       relying party ID, the frontend's host name (default "localhost")
+- WEBAUTHN_RP_ORIGINS  comma separated allowed origins (default FRONTEND_BASE_URL)
+
+Endpoints:
+1. POST /auth/passkeys/register/begin (authenticated)
+   - Returns creation options and a session_token, the ceremony state is kept in
+     Redis as "webauthn:<hash>" for 5 minutes
+2. POST /auth/passkeys/register/finish (authenticated)
+   - { session_token, name, credential } stores the new credential
+3. POST /auth/passkeys/login/begin
+   - Discoverable login, no username needed
+4. POST /auth/passkeys/login/finish
+   - { session_token, credential } verifies the assertion and returns the same
+     token response as /login (passkeys already prove possession and user verification,
+     so TOTP is not asked again)
+5. GET /passkeys, DELETE /passkeys/:id (authenticated)
+
+Security:
+- Ceremony states are single-use and bound to their purpose and user
+- The user handle is random and shared by all passkeys of a user, it never contains the user ID
+- A sign counter that goes backwards (cloned authenticator) rejects the login
+*/
+
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aman1117/backend/models"
+	"github.com/aman1117/backend/utils"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	maxPasskeysPerUser   = 10
+	passkeyUserHandleLen = 32
+	webAuthnPurposeReg   = "register"
+	webAuthnPurposeLogin = "login"
+)
+
+var webAuthn *webauthn.WebAuthn
+
+type PasskeyFinishRequest struct {
+	SessionToken string          `json:"session_token"`
+	Name         string          `json:"name"`
+	Credential   json.RawMessage `json:"credential"`
+}
+
+type PasskeyDTO struct {
+	ID             uint       `json:"id"`
+	Name           string     `json:"name"`
+	BackupEligible bool       `json:"backup_eligible"` // synced passkey (e.g. iCloud Keychain, Google Password Manager)
+	CreatedAt      time.Time  `json:"created_at"`
+	LastUsedAt     *time.Time `json:"last_used_at"`
+}
+
+// passkeyUser adapts models.User to webauthn.User
+type passkeyUser struct {
+	user        *models.User
+	handle      []byte
+	credentials []webauthn.Credential
+}
+
+func (u *passkeyUser) WebAuthnID() []byte                         { return u.handle }
+func (u *passkeyUser) WebAuthnName() string                       { return u.user.Email }
+func (u *passkeyUser) WebAuthnDisplayName() string                { return u.user.Username }
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+
+// InitWebAuthn configures the relying party from the environment
+func InitWebAuthn() error {
+	rpID := utils.GetFromEnv("WEBAUTHN_RP_ID")
+	origins := strings.Split(utils.GetFromEnv("WEBAUTHN_RP_ORIGINS"), ",")
+	if utils.GetFromEnv("WEBAUTHN_RP_ORIGINS") == "" {
+		origins = []string{frontendURL()}
+	}
+	if rpID == "" {
+		if u, err := url.Parse(origins[0]); err == nil && u.Hostname() != "" {
+			rpID = u.Hostname()
+		} else {
+			rpID = "localhost"
+		}
+	}
+	for i := range origins {
+		origins[i] = strings.TrimRight(strings.TrimSpace(origins[i]), "/")
+	}
+
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: utils.TOTPIssuer,
+		RPOrigins:     origins,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationRequired,
+		},
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: utils.WebAuthnSessionTTL},
+			Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: utils.WebAuthnSessionTTL},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	webAuthn = w
+	utils.Sugar.Infow("WebAuthn initialized", "rp_id", rpID, "origins", origins)
+	return nil
+}
+
+// ==================== Handlers ====================
+
+// BeginPasskeyRegistrationHandler handles POST /auth/passkeys/register/begin
+func BeginPasskeyRegistrationHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	if !passkeysAvailable() {
+		return passkeysUnavailableResponse(c)
+	}
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+
+	user, err := loadPasskeyUser(userID)
+	if err != nil || user == nil {
+		log.Errorw("Passkey user lookup failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "User not found",
+			"error_code": "USER_NOT_FOUND",
+		})
+	}
+
+	if len(user.credentials) >= maxPasskeysPerUser {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Passkey limit reached",
+			"error_code": "PASSKEY_LIMIT_REACHED",
+		})
+	}
+
+	options, session, err := webAuthn.BeginRegistration(user,
+		webauthn.WithExclusions(webauthn.Credentials(user.credentials).CredentialDescriptors()),
+	)
+	if err != nil {
+		log.Errorw("Passkey registration begin failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to start passkey registration",
+			"error_code": "PASSKEY_FAILED",
+		})
+	}
+
+	return writeWebAuthnOptions(c, userID, webAuthnPurposeReg, options, session)
+}
+
+// FinishPasskeyRegistrationHandler handles POST /auth/passkeys/register/finish
+func FinishPasskeyRegistrationHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	if !passkeysAvailable() {
+		return passkeysUnavailableResponse(c)
+	}
+
+	req, session, err := parsePasskeyFinish(c, webAuthnPurposeReg, userID)
+	if err != nil || session == nil {
+		return err
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = describeDevice(c.Get("User-Agent"))
+	}
+	if len(name) > 100 {
+		name = name[:100]
+	}
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+
+	user, err := loadPasskeyUser(userID)
+	if err != nil || user == nil {
+		log.Errorw("Passkey user lookup failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "User not found",
+			"error_code": "USER_NOT_FOUND",
+		})
+	}
+
+	// The first passkey keeps the handle generated when the ceremony began
+	if len(user.credentials) == 0 {
+		user.handle = session.UserID
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(req.Credential)
+	if err != nil {
+		return invalidPasskeyResponse(c)
+	}
+
+	credential, err := webAuthn.CreateCredential(user, *session, parsed)
+	if err != nil {
+		log.Warnw("Passkey registration rejected", "error", err)
+		return invalidPasskeyResponse(c)
+	}
+
+	passkey := models.Passkey{
+		UserID:          userID,
+		Name:            name,
+		CredentialID:    credential.ID,
+		UserHandle:      user.handle,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Transports:      joinTransports(credential.Transport),
+		Flags:           uint8(credential.Flags.ProtocolValue()),
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       int64(credential.Authenticator.SignCount),
+	}
+	if err := utils.GetDB().Create(&passkey).Error; err != nil {
+		log.Errorw("Passkey save failed", "error", err)
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"success":    false,
+			"error":      "This passkey is already registered",
+			"error_code": "PASSKEY_EXISTS",
+		})
+	}
+
+	log.Infow("Passkey registered", "passkey_id", passkey.ID)
+	recordAuditEvent(c, userID, models.AuditPasskeyAdded, fiber.Map{"passkey_id": passkey.ID})
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"data":    ToPasskeyDTO(passkey),
+	})
+}
+
+// BeginPasskeyLoginHandler handles POST /auth/passkeys/login/begin
+func BeginPasskeyLoginHandler(c *fiber.Ctx) error {
+	if !passkeysAvailable() {
+		return passkeysUnavailableResponse(c)
+	}
+
+	options, session, err := webAuthn.BeginDiscoverableLogin()
+	if err != nil {
+		utils.Sugar.Errorw("Passkey login begin failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to start passkey login",
+			"error_code": "PASSKEY_FAILED",
+		})
+	}
+
+	return writeWebAuthnOptions(c, 0, webAuthnPurposeLogin, options, session)
+}
+
+// FinishPasskeyLoginHandler handles POST /auth/passkeys/login/finish
+func FinishPasskeyLoginHandler(c *fiber.Ctx) error {
+	if !passkeysAvailable() {
+		return passkeysUnavailableResponse(c)
+	}
+
+	req, session, err := parsePasskeyFinish(c, webAuthnPurposeLogin, 0)
+	if err != nil || session == nil {
+		return err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(req.Credential)
+	if err != nil {
+		return invalidPasskeyResponse(c)
+	}
+
+	var passkey *models.Passkey
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		db := utils.GetDB()
+		var found models.Passkey
+		if err := db.Where("credential_id = ?", rawID).First(&found).Error; err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(found.UserHandle, userHandle) {
+			return nil, errors.New("user handle does not match credential")
+		}
+		user, err := loadPasskeyUser(found.UserID)
+		if err != nil || user == nil {
+			return nil, errors.New("passkey owner not found")
+		}
+		passkey = &found
+		return user, nil
+	}
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithTrace(traceID)
+
+	webUser, credential, err := webAuthn.ValidatePasskeyLogin(handler, *session, parsed)
+	if err != nil || passkey == nil {
+		log.Warnw("Passkey login rejected", "error", err)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid credentials",
+			"error_code": "INVALID_CREDENTIALS",
+		})
+	}
+
+	user := webUser.(*passkeyUser).user
+	if credential.Authenticator.CloneWarning {
+		utils.LogWithUser(user.ID, user.Username).Warnw("Passkey sign counter went backwards, possible clone", "passkey_id", passkey.ID)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid credentials",
+			"error_code": "INVALID_CREDENTIALS",
+		})
+	}
+
+	now := time.Now()
+	if err := utils.GetDB().Model(passkey).Updates(map[string]interface{}{
+		"sign_count":   int64(credential.Authenticator.SignCount),
+		"flags":        uint8(credential.Flags.ProtocolValue()),
+		"last_used_at": now,
+	}).Error; err != nil {
+		utils.LogWithUserID(user.ID).Warnw("Failed to update passkey", "passkey_id", passkey.ID, "error", err)
+	}
+
+	return issueTokens(c, user)
+}
+
+// ListPasskeysHandler handles GET /passkeys
+func ListPasskeysHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	var passkeys []models.Passkey
+	if err := utils.GetDB().Where("user_id = ?", userID).Order("created_at ASC").Find(&passkeys).Error; err != nil {
+		traceID, _ := c.Locals("trace_id").(string)
+		utils.LogWithContext(traceID, userID).Errorw("Passkey fetch failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to fetch passkeys",
+			"error_code": "FETCH_FAILED",
+		})
+	}
+
+	data := make([]PasskeyDTO, 0, len(passkeys))
+	for _, p := range passkeys {
+		data = append(data, ToPasskeyDTO(p))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"data":    data,
+	})
+}
+
+// DeletePasskeyHandler handles DELETE /passkeys/:id
+func DeletePasskeyHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	passkeyID, err := c.ParamsInt("id")
+	if err != nil || passkeyID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid passkey id",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+
+	result := utils.GetDB().Where("id = ? AND user_id = ?", passkeyID, userID).Delete(&models.Passkey{})
+	if result.Error != nil {
+		log.Errorw("Passkey deletion failed", "error", result.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to delete passkey",
+			"error_code": "DELETE_FAILED",
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Passkey not found",
+			"error_code": "PASSKEY_NOT_FOUND",
+		})
+	}
+
+	log.Infow("Passkey removed", "passkey_id", passkeyID)
+	recordAuditEvent(c, userID, models.AuditPasskeyRemoved, fiber.Map{"passkey_id": passkeyID})
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Passkey removed",
+	})
+}
+
+// ==================== Helper Functions ====================
+
+// passkeysAvailable reports whether WebAuthn is configured and Redis can hold ceremony state
+func passkeysAvailable() bool {
+	return webAuthn != nil && utils.GetRedis() != nil
+}
+
+func passkeysUnavailableResponse(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+		"success":    false,
+		"error":      "Passkeys are not available",
+		"error_code": "PASSKEYS_UNAVAILABLE",
+	})
+}
+
+// writeWebAuthnOptions stores the ceremony state and returns the options for navigator.credentials
+func writeWebAuthnOptions(c *fiber.Ctx, userID uint, purpose string, options interface{}, session *webauthn.SessionData) error {
+	data, err := json.Marshal(session)
+	var rawToken, tokenHash string
+	if err == nil {
+		rawToken, tokenHash, err = utils.GenerateResetToken()
+	}
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err = utils.StoreWebAuthnSession(ctx, tokenHash, utils.WebAuthnSession{UserID: userID, Purpose: purpose, Data: data})
+	}
+	if err != nil {
+		traceID, _ := c.Locals("trace_id").(string)
+		utils.LogWithContext(traceID, userID).Errorw("Passkey ceremony state could not be stored", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "An error occurred. Please try again.",
+			"error_code": "SERVER_ERROR",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success":       true,
+		"options":       options,
+		"session_token": rawToken,
+		"expires_in":    int(utils.WebAuthnSessionTTL.Seconds()),
+	})
+}
+
+// parsePasskeyFinish reads a finish request and consumes its ceremony state
+// Returns (nil, nil, response error) when the request is rejected
+func parsePasskeyFinish(c *fiber.Ctx, purpose string, userID uint) (*PasskeyFinishRequest, *webauthn.SessionData, error) {
+	var req PasskeyFinishRequest
+	if err := c.BodyParser(&req); err != nil || req.SessionToken == "" || len(req.Credential) == 0 {
+		return nil, nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "session_token and credential are required",
+			"error_code": "MISSING_FIELDS",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stored, err := utils.ConsumeWebAuthnSession(ctx, req.SessionToken)
+	if err != nil {
+		utils.Sugar.Errorw("Passkey ceremony state lookup failed", "error", err)
+		return nil, nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "An error occurred. Please try again.",
+			"error_code": "SERVER_ERROR",
+		})
+	}
+
+	var session webauthn.SessionData
+	if stored == nil || stored.Purpose != purpose || stored.UserID != userID || json.Unmarshal(stored.Data, &session) != nil {
+		return nil, nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid or expired passkey request, please try again",
+			"error_code": "INVALID_TOKEN",
+		})
+	}
+	return &req, &session, nil
+}
+
+// loadPasskeyUser loads the user with their passkeys, generating a user handle
+// if they have none yet
+func loadPasskeyUser(userID uint) (*passkeyUser, error) {
+	user, err := GetUserByID(userID)
+	if err != nil || user == nil {
+		return nil, err
+	}
+
+	var passkeys []models.Passkey
+	if err := utils.GetDB().Where("user_id = ?", userID).Find(&passkeys).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	u := &passkeyUser{user: user}
+	for _, p := range passkeys {
+		if u.handle == nil {
+			u.handle = p.UserHandle
+		}
+		u.credentials = append(u.credentials, toWebAuthnCredential(p))
+	}
+	if u.handle == nil {
+		u.handle = make([]byte, passkeyUserHandleLen)
+		if _, err := rand.Read(u.handle); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+func toWebAuthnCredential(p models.Passkey) webauthn.Credential {
+	var transports []protocol.AuthenticatorTransport
+	for _, t := range strings.Fields(p.Transports) {
+		transports = append(transports, protocol.AuthenticatorTransport(t))
+	}
+	return webauthn.Credential{
+		ID:              p.CredentialID,
+		PublicKey:       p.PublicKey,
+		AttestationType: p.AttestationType,
+		Transport:       transports,
+		Flags:           webauthn.NewCredentialFlags(protocol.AuthenticatorFlags(p.Flags)),
+		Authenticator: webauthn.Authenticator{
+			AAGUID:    p.AAGUID,
+			SignCount: uint32(p.SignCount),
+		},
+	}
+}
+
+func joinTransports(transports []protocol.AuthenticatorTransport) string {
+	parts := make([]string, 0, len(transports))
+	for _, t := range transports {
+		parts = append(parts, string(t))
+	}
+	return strings.Join(parts, " ")
+}
+
+func invalidPasskeyResponse(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		"success":    false,
+		"error":      "Invalid passkey response",
+		"error_code": "INVALID_PASSKEY",
+	})
+}
+
+func ToPasskeyDTO(p models.Passkey) PasskeyDTO {
+	return PasskeyDTO{
+		ID:             p.ID,
+		Name:           p.Name,
+		BackupEligible: protocol.AuthenticatorFlags(p.Flags).HasBackupEligible(),
+		CreatedAt:      p.CreatedAt,
+		LastUsedAt:     p.LastUsedAt,
+	}
+}
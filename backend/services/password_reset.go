@@ -24,6 +24,7 @@ Security Notes:
 - Always hash tokens before Redis lookup
 - Use bcrypt for password hashing
 - Identical response for existing/non-existing users on forgot-password
+- Forgot-password requests are throttled per email and per IP (see utils/throttle.go)
 */
 
 package services
@@ -81,6 +82,25 @@ func ForgotPasswordHandler(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusOK).JSON(successResponse)
 	}
 
+	// Throttled per email and per IP whether or not the account exists,
+	// so a 429 does not reveal anything either
+	throttleCtx, throttleCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer throttleCancel()
+	emailKey := utils.ThrottleKey(req.Email)
+	if wait := checkThrottles(throttleCtx,
+		throttleCheck{utils.ForgotPasswordEmailThrottle, emailKey},
+		throttleCheck{utils.ForgotPasswordIPThrottle, c.IP()},
+	); wait > 0 {
+		utils.Sugar.Warnw("Throttled password reset request", "ip", c.IP())
+		return tooManyAttemptsResponse(c, wait)
+	}
+	if _, err := utils.RecordThrottleFailure(throttleCtx, utils.ForgotPasswordEmailThrottle, emailKey); err != nil {
+		utils.Sugar.Errorw("Failed to record password reset request", "error", err)
+	}
+	if _, err := utils.RecordThrottleFailure(throttleCtx, utils.ForgotPasswordIPThrottle, c.IP()); err != nil {
+		utils.Sugar.Errorw("Failed to record password reset request", "error", err)
+	}
+
 	// Look up user (do this in background-like manner - same response either way)
 	db := utils.GetDB()
 	var user models.User
@@ -108,6 +128,8 @@ func ForgotPasswordHandler(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusOK).JSON(successResponse)
 	}
 
+	recordAuditEvent(c, user.ID, models.AuditPasswordResetRequested, nil)
+
 	// Send email with reset link
 	if err := sendPasswordResetEmail(user.Email, user.Username, rawToken); err != nil {
 		utils.Sugar.Errorw("Error sending reset email", "user_id", user.ID, "email", user.Email, "error", err)
@@ -190,7 +212,10 @@ func ResetPasswordHandler(c *fiber.Ctx) error {
 
 	// Update password in database
 	db := utils.GetDB()
-	result := db.Model(&models.User{}).Where("id = ?", userID).Update("password_hash", string(hashedPassword))
+	result := db.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"password_hash": string(hashedPassword),
+		"no_password":   false,
+	})
 	if result.Error != nil {
 		utils.LogWithUserID(userID).Errorw("Error updating password", "error", result.Error)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -200,6 +225,10 @@ func ResetPasswordHandler(c *fiber.Ctx) error {
 		})
 	}
 
+	// Whoever knew the old password must not stay signed in
+	revokeUserSessions(userID, "")
+	recordAuditEvent(c, userID, models.AuditPasswordResetCompleted, nil)
+
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"success": true,
 		"message": "Password updated successfully. You can now log in with your new password.",
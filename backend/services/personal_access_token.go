@@ -0,0 +1,356 @@
+/*
+#Plan: Personal Access Tokens
+
+Endpoints:
+1. GET /tokens (authenticated)
+   - Lists the user's tokens with scopes, expiry and last use, never the token itself
+
+2. POST /tokens (authenticated)
+   - { name, scopes: ["activities:write"], expires_in_days? }
+   - Returns "gt_pat_<64 hex chars>" once, only its SHA-256 hash is stored
+
+3. DELETE /tokens/:id (authenticated)
+   - Revokes the token immediately
+
+AuthMiddleware:
+- Bearer values starting with "gt_pat_" are looked up by hash instead of parsed as JWTs
+- Tokens only work on routes listed in personalAccessTokenRoutes and need the scope
+  listed there, every other route (including token management) rejects them
+- last_used_at/last_used_ip are updated at most once per personalAccessTokenTouchInterval
+- Tokens are deleted when the account deletion is requested
+*/
+
+package services
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aman1117/backend/models"
+	"github.com/aman1117/backend/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	maxPersonalAccessTokensPerUser   = 20
+	maxPersonalAccessTokenExpiryDays = 365
+	personalAccessTokenTouchInterval = time.Minute
+)
+
+// personalAccessTokenRoutes maps "METHOD /route" to the scope a token needs to call it
+var personalAccessTokenRoutes = map[string]models.TokenScope{
+	"POST /create-activity":              models.ScopeActivitiesWrite,
+	"POST /activities/copy":              models.ScopeActivitiesWrite,
+	"POST /activities/batch":             models.ScopeActivitiesWrite,
+	"PATCH /activities/:id":              models.ScopeActivitiesWrite,
+	"GET /activities/:id/history":        models.ScopeActivitiesRead,
+	"POST /activities/:id/revert":        models.ScopeActivitiesWrite,
+	"DELETE /activities/:id":             models.ScopeActivitiesWrite,
+	"POST /activities/segments":          models.ScopeActivitiesWrite,
+	"DELETE /activities/segments/:id":    models.ScopeActivitiesWrite,
+	"GET /timer":                         models.ScopeActivitiesRead,
+	"POST /timer/start":                  models.ScopeActivitiesWrite,
+	"POST /timer/stop":                   models.ScopeActivitiesWrite,
+	"DELETE /timer":                      models.ScopeActivitiesWrite,
+	"POST /get-activities":               models.ScopeActivitiesRead,
+	"GET /activity-templates":            models.ScopeActivitiesRead,
+	"POST /activity-templates/:id/apply": models.ScopeActivitiesWrite,
+	"POST /activities/drafts/confirm":    models.ScopeActivitiesWrite,
+	"GET /activity-categories":           models.ScopeActivitiesRead,
+	"POST /get-streak":                   models.ScopeActivitiesRead,
+	"GET /profile":                       models.ScopeProfileRead,
+}
+
+type CreateTokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays *int     `json:"expires_in_days"`
+}
+
+type PersonalAccessTokenDTO struct {
+	ID         uint                `json:"id"`
+	Name       string              `json:"name"`
+	Prefix     string              `json:"prefix"`
+	Scopes     []models.TokenScope `json:"scopes"`
+	ExpiresAt  *time.Time          `json:"expires_at"`
+	LastUsedAt *time.Time          `json:"last_used_at"`
+	LastUsedIP *string             `json:"last_used_ip"`
+	CreatedAt  time.Time           `json:"created_at"`
+	Expired    bool                `json:"expired"`
+}
+
+// ==================== Handlers ====================
+
+// ListTokensHandler handles GET /tokens
+func ListTokensHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	db := utils.GetDB()
+	var tokens []models.PersonalAccessToken
+	if err := db.Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens).Error; err != nil {
+		traceID, _ := c.Locals("trace_id").(string)
+		utils.LogWithContext(traceID, userID).Errorw("Token fetch failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to fetch tokens",
+			"error_code": "FETCH_FAILED",
+		})
+	}
+
+	now := time.Now()
+	data := make([]PersonalAccessTokenDTO, 0, len(tokens))
+	for i := range tokens {
+		data = append(data, ToPersonalAccessTokenDTO(&tokens[i], now))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"data":    data,
+	})
+}
+
+// CreateTokenHandler handles POST /tokens
+func CreateTokenHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	var body CreateTokenRequest
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid request body",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	name := strings.TrimSpace(body.Name)
+	if name == "" || len(name) > 100 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Name must be 1-100 characters",
+			"error_code": "INVALID_NAME",
+		})
+	}
+
+	scopes := make([]string, 0, len(body.Scopes))
+	seen := map[models.TokenScope]bool{}
+	for _, s := range body.Scopes {
+		scope := models.TokenScope(strings.TrimSpace(s))
+		if !scope.IsValid() {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"error":      "Unknown scope: " + s,
+				"error_code": "INVALID_SCOPE",
+			})
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, string(scope))
+		}
+	}
+	if len(scopes) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "At least one scope is required",
+			"error_code": "INVALID_SCOPE",
+		})
+	}
+
+	var expiresAt *time.Time
+	if body.ExpiresInDays != nil {
+		days := *body.ExpiresInDays
+		if days < 1 || days > maxPersonalAccessTokenExpiryDays {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"error":      "expires_in_days must be between 1 and 365",
+				"error_code": "INVALID_EXPIRY",
+			})
+		}
+		t := time.Now().Add(time.Duration(days) * 24 * time.Hour)
+		expiresAt = &t
+	}
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+	db := utils.GetDB()
+
+	var count int64
+	if err := db.Model(&models.PersonalAccessToken{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		log.Errorw("Token count failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to create token",
+			"error_code": "CREATE_FAILED",
+		})
+	}
+	if count >= maxPersonalAccessTokensPerUser {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Token limit reached",
+			"error_code": "TOKEN_LIMIT_REACHED",
+		})
+	}
+
+	rawToken, tokenHash, displayPrefix, err := utils.GeneratePersonalAccessToken()
+	if err != nil {
+		log.Errorw("Error generating personal access token", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to create token",
+			"error_code": "CREATE_FAILED",
+		})
+	}
+
+	token := models.PersonalAccessToken{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: tokenHash,
+		Prefix:    displayPrefix,
+		Scopes:    strings.Join(scopes, " "),
+		ExpiresAt: expiresAt,
+	}
+	if err := db.Create(&token).Error; err != nil {
+		log.Errorw("Token creation failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to create token",
+			"error_code": "CREATE_FAILED",
+		})
+	}
+
+	log.Infow("Personal access token created", "token_id", token.ID, "scopes", token.Scopes)
+	recordAuditEvent(c, userID, models.AuditAccessTokenCreated, fiber.Map{"token_id": token.ID, "name": token.Name, "scopes": token.Scopes})
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"message": "Copy this token now, it will not be shown again.",
+		"token":   rawToken,
+		"data":    ToPersonalAccessTokenDTO(&token, time.Now()),
+	})
+}
+
+// DeleteTokenHandler handles DELETE /tokens/:id
+func DeleteTokenHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	tokenID, err := c.ParamsInt("id")
+	if err != nil || tokenID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid token id",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+
+	deleted, err := DeletePersonalAccessToken(userID, uint(tokenID))
+	if err != nil {
+		log.Errorw("Token deletion failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to delete token",
+			"error_code": "DELETE_FAILED",
+		})
+	}
+	if !deleted {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Token not found",
+			"error_code": "TOKEN_NOT_FOUND",
+		})
+	}
+
+	log.Infow("Personal access token revoked", "token_id", tokenID)
+	recordAuditEvent(c, userID, models.AuditAccessTokenRevoked, fiber.Map{"token_id": tokenID})
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Token revoked",
+	})
+}
+
+// ==================== Middleware Helpers ====================
+
+// authenticatePersonalAccessToken is the AuthMiddleware branch for "gt_pat_" tokens
+func authenticatePersonalAccessToken(c *fiber.Ctx, rawToken string) error {
+	token, err := GetPersonalAccessTokenByHash(utils.HashToken(rawToken))
+	if err != nil {
+		utils.Sugar.Errorw("Personal access token lookup failed", "error", err)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Could not verify token",
+			"error_code": "INVALID_TOKEN",
+		})
+	}
+	if token == nil || token.IsExpired(time.Now()) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid token",
+			"error_code": "INVALID_TOKEN",
+		})
+	}
+	if token.User.IsDisabled() {
+		return accountDisabledResponse(c)
+	}
+
+	required, allowed := personalAccessTokenRoutes[c.Method()+" "+c.Route().Path]
+	if !allowed {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Personal access tokens cannot be used for this endpoint",
+			"error_code": "TOKEN_NOT_ALLOWED",
+		})
+	}
+	if !token.HasScope(required) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Token is missing the " + string(required) + " scope",
+			"error_code": "INSUFFICIENT_SCOPE",
+		})
+	}
+
+	if err := TouchPersonalAccessToken(token.ID, c.IP(), personalAccessTokenTouchInterval); err != nil {
+		utils.LogWithUserID(token.UserID).Warnw("Failed to record token use", "token_id", token.ID, "error", err)
+	}
+
+	c.Locals("user_id", token.UserID)
+	c.Locals("username", token.User.Username)
+	c.Locals("session_id", "")
+	c.Locals("token_id", token.ID)
+
+	return c.Next()
+}
+
+func ToPersonalAccessTokenDTO(t *models.PersonalAccessToken, now time.Time) PersonalAccessTokenDTO {
+	return PersonalAccessTokenDTO{
+		ID:         t.ID,
+		Name:       t.Name,
+		Prefix:     t.Prefix,
+		Scopes:     t.ScopeList(),
+		ExpiresAt:  t.ExpiresAt,
+		LastUsedAt: t.LastUsedAt,
+		LastUsedIP: t.LastUsedIP,
+		CreatedAt:  t.CreatedAt,
+		Expired:    t.IsExpired(now),
+	}
+}
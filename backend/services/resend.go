@@ -1,7 +1,9 @@
 package services
 
 import (
+	"context"
 	"fmt"
+	"html"
 	"time"
 
 	"github.com/aman1117/backend/models"
@@ -26,29 +28,51 @@ func InitResendClient() (*resend.Client, error) {
 }
 
 // SendStreakReminderEmails sends reminder emails to users who missed their streak yesterday
-// This is called by the cron job at 9 AM IST
+// This is called by the scheduler and handles every timezone bucket that reached 9 AM local time
+// and has not been reminded today. A failing bucket is logged and retried on the next tick.
 func SendStreakReminderEmails() error {
-	db := utils.GetDB()
-
-	loc, err := time.LoadLocation("Asia/Kolkata")
+	ctx := context.Background()
+	now := time.Now()
+	timezones, err := dueTimezones(ctx, now, reminderJobName, reminderJobHour)
 	if err != nil {
-		return fmt.Errorf("failed to load timezone: %v", err)
+		return fmt.Errorf("failed to load timezones: %v", err)
+	}
+
+	for _, tz := range timezones {
+		if err := SendStreakReminderEmailsForTimezone(tz); err != nil {
+			utils.Sugar.Errorw("Reminder emails failed for timezone", "timezone", tz, "error", err)
+			continue
+		}
+		if err := markJobRun(ctx, reminderJobName, tz, now); err != nil {
+			utils.Sugar.Errorw("Failed to record reminder job run", "timezone", tz, "error", err)
+		}
 	}
+	return nil
+}
 
+// SendStreakReminderEmailsForTimezone sends reminders to users in one timezone bucket
+func SendStreakReminderEmailsForTimezone(timezone string) error {
+	db := utils.GetDB()
+
+	loc := utils.LoadUserLocation(timezone)
 	yesterday := time.Now().In(loc).AddDate(0, 0, -1).Format("2006-01-02")
 
 	var users []models.User
 
-	db.
+	// Reminders only go to verified addresses so we never mail someone who did not sign up
+	if err := db.
+		Where("timezone = ? AND email_verified = ? AND deletion_requested_at IS NULL AND disabled_at IS NULL", timezone, true).
 		Where("id IN (?)",
 			db.Table("streaks").
 				Where("current = 0").
 				Select("user_id").
 				Where("DATE(activity_date) = ?", yesterday),
 		).
-		Find(&users)
+		Find(&users).Error; err != nil {
+		return fmt.Errorf("failed to load users: %v", err)
+	}
 	if len(users) == 0 {
-		utils.Sugar.Info("No users found who missed their streak yesterday")
+		utils.Sugar.Infow("No users found who missed their streak yesterday", "timezone", timezone)
 		return nil
 	}
 
@@ -95,6 +119,121 @@ func SendStreakReminderEmails() error {
 		}
 	}
 
-	utils.Sugar.Infow("Sent reminder emails", "total", len(users), "failed", notSuccessful)
+	utils.Sugar.Infow("Sent reminder emails", "timezone", timezone, "total", len(users), "failed", notSuccessful)
+	return nil
+}
+
+// actionEmail describes a transactional email with a single call-to-action button
+type actionEmail struct {
+	Title       string // heading, e.g. "✉️ Verify your email"
+	Username    string
+	Intro       string // paragraph above the button
+	ButtonLabel string
+	Link        string
+	Expiry      string // e.g. "24 hours", omitted if empty
+	Notice      string // grey security notice below the button
+}
+
+// renderActionEmail renders an actionEmail using the same layout as the password reset email
+func renderActionEmail(e actionEmail) string {
+	expiry := ""
+	if e.Expiry != "" {
+		expiry = fmt.Sprintf(`
+                            <p style="margin: 0 0 16px; font-size: 14px; color: #666; line-height: 1.5;">
+                                ⏰ This link will expire in <strong>%s</strong>.
+                            </p>`, html.EscapeString(e.Expiry))
+	}
+
+	button := ""
+	fallback := ""
+	if e.Link != "" {
+		link := html.EscapeString(e.Link)
+		button = fmt.Sprintf(`
+                            <div style="text-align: center; margin: 32px 0;">
+                                <a href="%s" style="display: inline-block; padding: 14px 32px; background-color: #0066ff; color: #ffffff; text-decoration: none; font-weight: 600; font-size: 16px; border-radius: 8px;">
+                                    %s
+                                </a>
+                            </div>`, link, html.EscapeString(e.ButtonLabel))
+		fallback = fmt.Sprintf(`
+                            <p style="margin: 24px 0 0; font-size: 12px; color: #999; line-height: 1.5; word-break: break-all;">
+                                If the button doesn't work, copy and paste this link into your browser:<br>
+                                <a href="%s" style="color: #0066ff;">%s</a>
+                            </p>`, link, link)
+	}
+
+	return fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+</head>
+<body style="margin: 0; padding: 0; font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, 'Helvetica Neue', Arial, sans-serif; background-color: #f5f5f5;">
+    <table width="100%%" cellpadding="0" cellspacing="0" style="background-color: #f5f5f5; padding: 40px 20px;">
+        <tr>
+            <td align="center">
+                <table width="100%%" style="max-width: 480px; background-color: #ffffff; border-radius: 12px; box-shadow: 0 2px 8px rgba(0,0,0,0.08);">
+                    <tr>
+                        <td style="padding: 40px 32px;">
+                            <div style="text-align: center; margin-bottom: 32px;">
+                                <h1 style="margin: 0; font-size: 24px; font-weight: 700; color: #1a1a1a;">
+                                    %s
+                                </h1>
+                            </div>
+                            <p style="margin: 0 0 16px; font-size: 16px; color: #333; line-height: 1.5;">
+                                Hi <strong>%s</strong>,
+                            </p>
+                            <p style="margin: 0 0 24px; font-size: 16px; color: #333; line-height: 1.5;">
+                                %s
+                            </p>%s%s
+                            <div style="background-color: #f8f9fa; border-radius: 8px; padding: 16px; margin-top: 24px;">
+                                <p style="margin: 0; font-size: 14px; color: #666; line-height: 1.5;">
+                                    %s
+                                </p>
+                            </div>%s
+                        </td>
+                    </tr>
+                    <tr>
+                        <td style="padding: 24px 32px; border-top: 1px solid #eee; text-align: center;">
+                            <p style="margin: 0; font-size: 12px; color: #999;">
+                                Growth Tracker • Track your daily activities
+                            </p>
+                        </td>
+                    </tr>
+                </table>
+            </td>
+        </tr>
+    </table>
+</body>
+</html>
+`, html.EscapeString(e.Title), html.EscapeString(e.Username), html.EscapeString(e.Intro), button, expiry, html.EscapeString(e.Notice), fallback)
+}
+
+// sendActionEmail renders and sends an actionEmail via Resend
+func sendActionEmail(to, subject string, e actionEmail) error {
+	client, err := InitResendClient()
+	if err != nil {
+		return fmt.Errorf("failed to initialize email client: %w", err)
+	}
+
+	params := &resend.SendEmailRequest{
+		From:    "Growth Tracker <aman@amancodes.dev>",
+		To:      []string{to},
+		Subject: subject,
+		Html:    renderActionEmail(e),
+	}
+
+	if _, err := client.Emails.Send(params); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
 	return nil
 }
+
+// frontendURL returns FRONTEND_BASE_URL, defaulting to the local dev server
+func frontendURL() string {
+	url := utils.GetFromEnv("FRONTEND_BASE_URL")
+	if url == "" {
+		url = "http://localhost:5173"
+	}
+	return url
+}
@@ -0,0 +1,329 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/aman1117/backend/models"
+	"github.com/aman1117/backend/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshTokenHandler handles POST /auth/refresh
+// Consumes the refresh token and returns a new access/refresh token pair for the same session
+func RefreshTokenHandler(c *fiber.Ctx) error {
+	var req RefreshTokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid request body",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	if req.RefreshToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Refresh token is required",
+			"error_code": "MISSING_TOKEN",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	traceID, _ := c.Locals("trace_id").(string)
+	userID, sessionID, refreshToken, err := utils.RotateRefreshToken(ctx, req.RefreshToken)
+	if errors.Is(err, utils.ErrRefreshTokenReused) {
+		utils.LogWithContext(traceID, userID).Warnw("Refresh token reuse detected, session revoked", "ip", c.IP())
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Refresh token has already been used, please log in again",
+			"error_code": "REFRESH_TOKEN_REUSED",
+		})
+	}
+	if errors.Is(err, utils.ErrRefreshTokenInvalid) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid or expired refresh token",
+			"error_code": "INVALID_REFRESH_TOKEN",
+		})
+	}
+	if err != nil {
+		utils.LogWithTrace(traceID).Errorw("Refresh token rotation failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "An error occurred. Please try again.",
+			"error_code": "SERVER_ERROR",
+		})
+	}
+
+	user, err := GetUserByID(userID)
+	if err != nil || user == nil {
+		_ = utils.RevokeSession(ctx, userID, sessionID)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid or expired refresh token",
+			"error_code": "INVALID_REFRESH_TOKEN",
+		})
+	}
+	if user.IsDisabled() {
+		_ = utils.RevokeSession(ctx, userID, sessionID)
+		return accountDisabledResponse(c)
+	}
+
+	return writeTokenResponse(c, user, sessionID, refreshToken)
+}
+
+// LogoutHandler handles POST /auth/logout
+// Revokes the session of the access token used for this request
+func LogoutHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	sessionID, _ := c.Locals("session_id").(string)
+	if sessionID == "" {
+		// Token was issued without a session, nothing to revoke server-side
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success": true,
+			"message": "Logged out",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+	if err := utils.RevokeSession(ctx, userID, sessionID); err != nil {
+		log.Errorw("Logout failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to log out",
+			"error_code": "LOGOUT_FAILED",
+		})
+	}
+
+	log.Info("User logged out")
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Logged out",
+	})
+}
+
+// LogoutAllHandler handles POST /auth/logout-all
+// Revokes every session of the user, including the current one
+func LogoutAllHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+	if err := utils.RevokeAllSessions(ctx, userID); err != nil {
+		log.Errorw("Logout from all sessions failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to log out",
+			"error_code": "LOGOUT_FAILED",
+		})
+	}
+
+	log.Info("User logged out of all sessions")
+	recordAuditEvent(c, userID, models.AuditLoggedOutEverywhere, nil)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Logged out of all sessions",
+	})
+}
+
+// ListSessionsHandler handles GET /sessions
+func ListSessionsHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sessions, err := utils.ListSessions(ctx, userID)
+	if err != nil {
+		traceID, _ := c.Locals("trace_id").(string)
+		utils.LogWithContext(traceID, userID).Errorw("Session list failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to fetch sessions",
+			"error_code": "FETCH_FAILED",
+		})
+	}
+
+	currentSessionID, _ := c.Locals("session_id").(string)
+	data := make([]fiber.Map, 0, len(sessions))
+	for _, session := range sessions {
+		data = append(data, fiber.Map{
+			"id":         session.ID,
+			"device":     session.Device,
+			"user_agent": session.UserAgent,
+			"ip":         session.IP,
+			"created_at": session.CreatedAt.Format(time.RFC3339),
+			"last_seen":  session.LastSeen.Format(time.RFC3339),
+			"current":    session.ID == currentSessionID,
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"data":    data,
+	})
+}
+
+// RevokeSessionHandler handles DELETE /sessions/:id
+func RevokeSessionHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	sessionID := c.Params("id")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+
+	owned, err := utils.HasSession(ctx, userID, sessionID)
+	if err != nil {
+		log.Errorw("Session lookup failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to revoke session",
+			"error_code": "REVOKE_FAILED",
+		})
+	}
+	if !owned {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Session not found",
+			"error_code": "SESSION_NOT_FOUND",
+		})
+	}
+
+	if err := utils.RevokeSession(ctx, userID, sessionID); err != nil {
+		log.Errorw("Session revoke failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to revoke session",
+			"error_code": "REVOKE_FAILED",
+		})
+	}
+
+	log.Info("Session revoked")
+	recordAuditEvent(c, userID, models.AuditSessionRevoked, nil)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Session revoked",
+	})
+}
+
+// revokeUserSessions logs out every session except keepSessionID (pass "" to revoke all)
+// after a credential change. Failures are logged, the change itself already succeeded.
+func revokeUserSessions(userID uint, keepSessionID string) {
+	if utils.GetRedis() == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var err error
+	if keepSessionID == "" {
+		err = utils.RevokeAllSessions(ctx, userID)
+	} else {
+		err = utils.RevokeOtherSessions(ctx, userID, keepSessionID)
+	}
+	if err != nil {
+		utils.LogWithUserID(userID).Errorw("Failed to revoke sessions", "error", err)
+	}
+}
+
+// describeDevice turns a User-Agent into a short label like "Chrome on Windows"
+func describeDevice(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+
+	var platform string
+	switch {
+	case strings.Contains(ua, "okhttp"):
+		return "Android app"
+	case strings.Contains(ua, "android"):
+		platform = "Android"
+	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipad"):
+		platform = "iOS"
+	case strings.Contains(ua, "windows"):
+		platform = "Windows"
+	case strings.Contains(ua, "mac os"):
+		platform = "macOS"
+	case strings.Contains(ua, "linux"):
+		platform = "Linux"
+	}
+
+	var browser string
+	switch {
+	case strings.Contains(ua, "edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "chrome/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "safari/"):
+		browser = "Safari"
+	case strings.Contains(ua, "curl/"):
+		browser = "curl"
+	}
+
+	switch {
+	case browser != "" && platform != "":
+		return browser + " on " + platform
+	case browser != "":
+		return browser
+	case platform != "":
+		return platform
+	default:
+		return "Unknown device"
+	}
+}
+
+func truncate(s string, max int) string {
+	if len(s) > max {
+		return s[:max]
+	}
+	return s
+}
@@ -30,8 +30,9 @@ func AddStreak(userID uint, date time.Time, isCron bool) error {
 	}
 	db := utils.GetDB()
 	streak := models.Streak{}
+	// Rows after date (left by clients that logged ahead) are not part of the chain yet
 	result := db.
-		Where("user_id = ?", userID).
+		Where("user_id = ? AND activity_date <= ?", userID, date).
 		Order("activity_date DESC").
 		Limit(1).
 		Find(&streak)
@@ -40,6 +41,10 @@ func AddStreak(userID uint, date time.Time, isCron bool) error {
 		return result.Error
 	}
 	if isCron {
+		// A caught-up or retried run must not start the same day twice
+		if result.RowsAffected > 0 && streak.ActivityDate.Format("2006-01-02") == date.Format("2006-01-02") {
+			return nil
+		}
 		longest := 0
 		if result.RowsAffected > 0 {
 			longest = streak.Longest
@@ -104,6 +109,108 @@ func AddStreak(userID uint, date time.Time, isCron bool) error {
 	return nil
 }
 
+// SyncDayStreak brings the streak in line with the day's activities after an entry
+// was created, changed or deleted: a day with logged hours counts, a day that ended
+// up without any hours does not. Past days are handled like today, so re-logging
+// yesterday restores the chain the midnight job already closed.
+// Draft entries are not counted until the user confirms them, and neither are days
+// after the user's today (date carries the user's timezone).
+func SyncDayStreak(userID uint, date time.Time) error {
+	if date.After(utils.StartOfDay(time.Now(), date.Location())) {
+		return nil
+	}
+
+	total, err := GetConfirmedDayHours(userID, date)
+	if err != nil {
+		return err
+	}
+	return recountStreakFrom(userID, date, total > 0)
+}
+
+// recountStreakFrom sets whether date counts and renumbers date and every later
+// streak row, so the current and longest streak look as if the day had always been
+// logged (or never been). A missing row for a counted day is created.
+// The chain only continues from one calendar day to the next, a gap in the rows
+// (days the cron missed, days before sign-up) starts over at 1.
+func recountStreakFrom(userID uint, date time.Time, counted bool) error {
+	const layout = "2006-01-02"
+	db := utils.GetDB()
+	return db.Transaction(func(tx *gorm.DB) error {
+		var rows []models.Streak
+		if err := tx.
+			Where("user_id = ? AND activity_date >= ?", userID, date).
+			Order("activity_date ASC").
+			Find(&rows).Error; err != nil {
+			return err
+		}
+
+		hasRow := len(rows) > 0 && rows[0].ActivityDate.Format(layout) == date.Format(layout)
+		if !hasRow && !counted {
+			return nil
+		}
+		if hasRow && (rows[0].Current > 0) == counted {
+			return nil
+		}
+
+		previous := models.Streak{}
+		if err := tx.
+			Where("user_id = ? AND activity_date < ?", userID, date).
+			Order("activity_date DESC").
+			Limit(1).
+			Find(&previous).Error; err != nil {
+			return err
+		}
+
+		if !hasRow {
+			row := models.Streak{UserID: userID, ActivityDate: date}
+			if err := tx.Create(&row).Error; err != nil {
+				return err
+			}
+			rows = append([]models.Streak{row}, rows...)
+		}
+
+		current, longest := previous.Current, previous.Longest
+		var last *time.Time
+		if previous.ID != 0 {
+			last = &previous.ActivityDate
+		}
+		for i := range rows {
+			row := &rows[i]
+			count := row.Current > 0
+			if i == 0 {
+				count = counted
+			}
+			switch {
+			case !count:
+				row.Current = 0
+			case last != nil && isNextDay(*last, row.ActivityDate):
+				row.Current = current + 1
+			default:
+				row.Current = 1
+			}
+			current = row.Current
+			last = &row.ActivityDate
+			longest = max(longest, row.Current)
+			row.Longest = longest
+
+			if err := tx.Model(row).Updates(map[string]interface{}{
+				"current": row.Current,
+				"longest": row.Longest,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// isNextDay reports whether day is the calendar day right after previous, each
+// read in its own location
+func isNextDay(previous, day time.Time) bool {
+	const layout = "2006-01-02"
+	return previous.AddDate(0, 0, 1).Format(layout) == day.Format(layout)
+}
+
 func GetStreakHandler(c *fiber.Ctx) error {
 	var body GetStreakRequest
 	if err := c.BodyParser(&body); err != nil {
@@ -1,9 +1,12 @@
 package services
 
 import (
+	"errors"
+
 	"github.com/aman1117/backend/models"
 	"github.com/aman1117/backend/utils"
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
 // GetTileConfig fetches the tile configuration for a user
@@ -35,25 +38,53 @@ func GetTileConfigByUsername(username string) (*models.TileConfig, error) {
 	return &config, nil
 }
 
-// SaveTileConfig saves or updates tile configuration for a user
-func SaveTileConfig(userID uint, config models.JSONB) error {
+// SaveTileConfig saves or updates tile configuration for a user.
+// If expected is set and differs from the stored version (0 when there is no config yet),
+// errVersionConflict is returned together with the stored config, if any.
+func SaveTileConfig(userID uint, config models.JSONB, expected *uint) (*models.TileConfig, error) {
 	db := utils.GetDB()
 
-	var existing models.TileConfig
-	result := db.Where("user_id = ?", userID).First(&existing)
+	var (
+		saved models.TileConfig
+		found bool
+	)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := lockUserForUpdate(tx, userID); err != nil {
+			return err
+		}
 
-	if result.Error != nil {
-		// Create new record
-		newConfig := models.TileConfig{
-			UserID: userID,
-			Config: config,
+		result := tx.Where("user_id = ?", userID).Limit(1).Find(&saved)
+		if result.Error != nil {
+			return result.Error
+		}
+		found = result.RowsAffected > 0
+
+		if expected != nil && *expected != saved.Version {
+			return errVersionConflict
+		}
+
+		if !found {
+			// Create new record
+			saved = models.TileConfig{
+				UserID:  userID,
+				Config:  config,
+				Version: 1,
+			}
+			return tx.Create(&saved).Error
 		}
-		return db.Create(&newConfig).Error
-	}
 
-	// Update existing record
-	existing.Config = config
-	return db.Save(&existing).Error
+		// Update existing record
+		saved.Config = config
+		saved.Version++
+		return tx.Save(&saved).Error
+	})
+	if errors.Is(err, errVersionConflict) && found {
+		return &saved, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &saved, nil
 }
 
 // GetTileConfigHandler - GET /tile-config
@@ -76,9 +107,11 @@ func GetTileConfigHandler(c *fiber.Ctx) error {
 		})
 	}
 
+	c.Set(fiber.HeaderETag, versionETag(config.Version))
 	return c.JSON(fiber.Map{
 		"success": true,
 		"data":    config.Config,
+		"version": config.Version,
 	})
 }
 
@@ -150,7 +183,8 @@ func GetTileConfigByUsernameHandler(c *fiber.Ctx) error {
 
 // SaveTileConfigRequest represents the request body for saving tile config
 type SaveTileConfigRequest struct {
-	Config models.JSONB `json:"config"`
+	Config  models.JSONB `json:"config"`
+	Version *uint        `json:"version,omitempty"` // see If-Match in version.go
 }
 
 // SaveTileConfigHandler - POST /tile-config
@@ -175,7 +209,20 @@ func SaveTileConfigHandler(c *fiber.Ctx) error {
 		})
 	}
 
-	if err := SaveTileConfig(userID, req.Config); err != nil {
+	expected, err := expectedVersion(c, req.Version)
+	if err != nil {
+		return invalidVersionResponse(c)
+	}
+
+	saved, err := SaveTileConfig(userID, req.Config, expected)
+	if errors.Is(err, errVersionConflict) {
+		var current fiber.Map
+		if saved != nil {
+			current = fiber.Map{"config": saved.Config, "version": saved.Version}
+		}
+		return versionConflictResponse(c, current)
+	}
+	if err != nil {
 		utils.LogWithContext(traceID, userID).Errorw("Tile config save failed", "error", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"success":    false,
@@ -184,9 +231,11 @@ func SaveTileConfigHandler(c *fiber.Ctx) error {
 		})
 	}
 
-	utils.LogWithContext(traceID, userID).Debug("Tile config saved")
+	utils.LogWithContext(traceID, userID).Debugw("Tile config saved", "version", saved.Version)
+	c.Set(fiber.HeaderETag, versionETag(saved.Version))
 	return c.JSON(fiber.Map{
 		"success": true,
 		"message": "Tile configuration saved successfully",
+		"version": saved.Version,
 	})
 }
@@ -0,0 +1,521 @@
+/*
+#Plan: Live Activity Timer
+
+Storage:
+- activity_timers holds at most one running timer per user (unique user_id), so the
+  timer is visible from every device and survives restarts
+
+Endpoints:
+1. GET /timer
+   - The running timer with its elapsed time, data is null when none is running
+
+2. POST /timer/start
+   - { activity, note? }, 409 TIMER_RUNNING if the user already has a timer
+
+3. POST /timer/stop
+   - { ended_at?, confirm? }, ended_at (RFC 3339) stops the timer at an earlier time,
+     e.g. when the user forgot to stop it
+   - A timer that ran longer than timerConfirmAfter answers 409 TIMER_CONFIRMATION_REQUIRED
+     unless confirm is set or ended_at shortens it below that, so a forgotten timer does
+     not silently fill every day since it was started
+   - Splits the elapsed time at local midnights (user's timezone), so a timer that
+     crosses midnight adds to both days
+   - Each part is added to that day's entry for the activity: new and segmented entries
+     get a segment with the exact start and end (see activity_segment.go), entries logged
+     as plain hours get the hours added
+   - Hours that would push the day over 24 are dropped and reported as discarded_hours,
+     so are parts that overlap a segment the user logged while the timer was running
+   - Runs in one transaction, then syncs the streak of every day that got hours,
+     past days included (the part before midnight of a timer that crossed it)
+
+4. DELETE /timer
+   - Discards the running timer without logging anything
+*/
+
+package services
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aman1117/backend/models"
+	"github.com/aman1117/backend/utils"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// timerConfirmAfter is how long a timer may run before stopping it needs confirmation
+const timerConfirmAfter = 12 * time.Hour
+
+var (
+	errTimerNeedsConfirm = errors.New("timer ran too long to stop without confirmation")
+	errInvalidTimerEnd   = errors.New("ended_at outside the timer's run")
+)
+
+// ==================== Request/Response Types ====================
+
+type StartTimerRequest struct {
+	Activity models.ActivityName `json:"activity"`
+	Note     *string             `json:"note,omitempty"`
+}
+
+type StopTimerRequest struct {
+	EndedAt *time.Time `json:"ended_at,omitempty"`
+	Confirm bool       `json:"confirm"`
+}
+
+type TimerDTO struct {
+	Activity       models.ActivityName `json:"activity"`
+	Note           *string             `json:"note"`
+	StartedAt      time.Time           `json:"started_at"`
+	ElapsedSeconds int64               `json:"elapsed_seconds"`
+}
+
+type TimerEntryDTO struct {
+	Date          string              `json:"date"`
+	Activity      models.ActivityName `json:"activity"`
+	AddedHours    float32             `json:"added_hours"`
+	DayTotalHours float32             `json:"day_total_hours"`
+}
+
+// ==================== Handlers ====================
+
+// GetTimerHandler handles GET /timer
+func GetTimerHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	timer, err := GetActivityTimer(userID)
+	if err != nil {
+		traceID, _ := c.Locals("trace_id").(string)
+		utils.LogWithContext(traceID, userID).Errorw("Timer fetch failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to fetch timer",
+			"error_code": "FETCH_FAILED",
+		})
+	}
+
+	var data *TimerDTO
+	if timer != nil {
+		dto := ToTimerDTO(*timer, time.Now())
+		data = &dto
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"data":    data,
+	})
+}
+
+// StartTimerHandler handles POST /timer/start
+func StartTimerHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	var body StartTimerRequest
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid request body",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	if body.Activity == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Activity is required",
+			"error_code": "MISSING_FIELDS",
+		})
+	}
+
+	db := utils.GetDB()
+	if err := checkActivityName(db, userID, body.Activity); err != nil {
+		return activityNameErrorResponse(c, err)
+	}
+
+	var note *string
+	if body.Note != nil {
+		trimmed := strings.TrimSpace(*body.Note)
+		if utf8.RuneCountInString(trimmed) > maxActivityNoteLength {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"error":      "Note cannot be longer than 500 characters",
+				"error_code": "NOTE_TOO_LONG",
+			})
+		}
+		if trimmed != "" {
+			note = &trimmed
+		}
+	}
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+
+	existing, err := GetActivityTimer(userID)
+	if err != nil {
+		log.Errorw("Timer fetch failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to start timer",
+			"error_code": "CREATE_FAILED",
+		})
+	}
+	if existing != nil {
+		return timerRunningResponse(c, existing)
+	}
+
+	timer := models.ActivityTimer{
+		UserID:    userID,
+		Name:      body.Activity,
+		Note:      note,
+		StartedAt: time.Now(),
+	}
+	if err := db.Create(&timer).Error; err != nil {
+		// Lost a race against another device starting a timer
+		if existing, _ := GetActivityTimer(userID); existing != nil {
+			return timerRunningResponse(c, existing)
+		}
+		log.Errorw("Timer creation failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to start timer",
+			"error_code": "CREATE_FAILED",
+		})
+	}
+
+	log.Debugw("Timer started", "activity", timer.Name)
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"data":    ToTimerDTO(timer, time.Now()),
+	})
+}
+
+// StopTimerHandler handles POST /timer/stop
+func StopTimerHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	// The body is optional, older clients stop the timer without one
+	var body StopTimerRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"error":      "Invalid request body",
+				"error_code": "INVALID_REQUEST",
+			})
+		}
+	}
+
+	loc, err := GetUserLocation(userID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to find user",
+			"error_code": "USER_NOT_FOUND",
+		})
+	}
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+
+	var (
+		timer     *models.ActivityTimer
+		entries   []TimerEntryDTO
+		discarded float64
+	)
+	now := time.Now()
+	stoppedAt := now
+
+	err = utils.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := lockUserForUpdate(tx, userID); err != nil {
+			return err
+		}
+
+		var running models.ActivityTimer
+		result := tx.Where("user_id = ?", userID).Limit(1).Find(&running)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		timer = &running
+
+		if body.EndedAt != nil {
+			if !body.EndedAt.After(running.StartedAt) || body.EndedAt.After(now) {
+				return errInvalidTimerEnd
+			}
+			stoppedAt = *body.EndedAt
+		}
+		if stoppedAt.Sub(running.StartedAt) > timerConfirmAfter && !body.Confirm {
+			return errTimerNeedsConfirm
+		}
+
+		for _, part := range splitByLocalDay(running.StartedAt, stoppedAt, loc) {
+			entry, dropped, err := addTimerPart(tx, &running, part)
+			if err != nil {
+				return err
+			}
+			discarded += dropped
+			if entry != nil {
+				entries = append(entries, *entry)
+			}
+		}
+		return tx.Delete(&running).Error
+	})
+	if errors.Is(err, errInvalidTimerEnd) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "ended_at must be after the timer started and not in the future",
+			"error_code": "INVALID_END_TIME",
+		})
+	}
+	if errors.Is(err, errTimerNeedsConfirm) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"success":    false,
+			"error":      "This timer has been running for a long time, confirm the time or set when it ended",
+			"error_code": "TIMER_CONFIRMATION_REQUIRED",
+			"data":       ToTimerDTO(*timer, now),
+		})
+	}
+	if err != nil {
+		log.Errorw("Timer stop failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to stop timer",
+			"error_code": "UPDATE_FAILED",
+		})
+	}
+	if timer == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success":    false,
+			"error":      "No timer is running",
+			"error_code": "TIMER_NOT_RUNNING",
+		})
+	}
+
+	for _, entry := range entries {
+		date, _ := time.ParseInLocation("2006-01-02", entry.Date, loc)
+		if err := SyncDayStreak(userID, date); err != nil {
+			log.Errorw("Failed to sync streak", "date", entry.Date, "error", err)
+		}
+	}
+
+	elapsed := stoppedAt.Sub(timer.StartedAt).Hours()
+	log.Debugw("Timer stopped", "activity", timer.Name, "elapsed_hours", elapsed, "days", len(entries))
+	if entries == nil {
+		entries = []TimerEntryDTO{}
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Timer stopped",
+		"data": fiber.Map{
+			"activity":        timer.Name,
+			"started_at":      timer.StartedAt,
+			"stopped_at":      stoppedAt,
+			"elapsed_hours":   roundHours(elapsed),
+			"discarded_hours": roundHours(discarded),
+			"entries":         entries,
+		},
+	})
+}
+
+// DiscardTimerHandler handles DELETE /timer
+func DiscardTimerHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	deleted, err := DeleteActivityTimer(userID)
+	if err != nil {
+		traceID, _ := c.Locals("trace_id").(string)
+		utils.LogWithContext(traceID, userID).Errorw("Timer discard failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to discard timer",
+			"error_code": "DELETE_FAILED",
+		})
+	}
+	if !deleted {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success":    false,
+			"error":      "No timer is running",
+			"error_code": "TIMER_NOT_RUNNING",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Timer discarded",
+	})
+}
+
+// ==================== Helpers ====================
+
+type dayPart struct {
+	date  time.Time // local midnight
+	start time.Time
+	end   time.Time
+}
+
+func (p dayPart) hours() float64 {
+	return p.end.Sub(p.start).Hours()
+}
+
+// splitByLocalDay cuts [start, end) at every midnight in loc
+func splitByLocalDay(start, end time.Time, loc *time.Location) []dayPart {
+	var parts []dayPart
+	for cursor := start; cursor.Before(end); {
+		day := utils.StartOfDay(cursor, loc)
+		next := day.AddDate(0, 0, 1)
+		partEnd := end
+		if next.Before(end) {
+			partEnd = next
+		}
+		parts = append(parts, dayPart{date: day, start: cursor, end: partEnd})
+		cursor = partEnd
+	}
+	return parts
+}
+
+// addTimerPart adds one day's part of the timer to the activity's entry on that day,
+// as far as the day's 24 hour limit allows. Returns the resulting entry (nil if
+// nothing was added) and the hours that were dropped.
+func addTimerPart(tx *gorm.DB, timer *models.ActivityTimer, part dayPart) (*TimerEntryDTO, float64, error) {
+	var dayActivities []models.Activity
+	if err := tx.Where("user_id = ? AND activity_date = ?", timer.UserID, part.date).Find(&dayActivities).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var (
+		totalHours float32
+		existing   *models.Activity
+	)
+	for i := range dayActivities {
+		a := &dayActivities[i]
+		totalHours += a.DurationHours
+		if a.Name == timer.Name {
+			existing = a
+		}
+	}
+
+	// decimal(4,2) column, round down so the day never ends up above 24
+	hours := part.hours()
+	added := float32(math.Floor(math.Min(hours, float64(24-totalHours))*100) / 100)
+	if added <= 0 {
+		return nil, hours, nil
+	}
+
+	// New entries and empty plain entries can take segments as well
+	segmented := existing == nil
+	if existing != nil {
+		has, err := activityHasSegments(tx, existing.ID)
+		if err != nil {
+			return nil, 0, err
+		}
+		segmented = has || existing.DurationHours == 0
+	}
+
+	// Tracked time confirms a pre-filled draft like any other edit
+	activity := existing
+	if segmented {
+		// A segment cannot be cut in hours, shorten it to what fits instead
+		end := part.start.Add(time.Duration(float64(added) * float64(time.Hour)))
+		if end.After(part.end) {
+			end = part.end
+		}
+
+		// A new entry is only kept if its segment could be added
+		if err := tx.SavePoint("timer_part").Error; err != nil {
+			return nil, 0, err
+		}
+		if activity == nil {
+			activity = &models.Activity{
+				UserID:       timer.UserID,
+				Name:         timer.Name,
+				ActivityDate: part.date,
+				Note:         timer.Note,
+			}
+			if err := tx.Create(activity).Error; err != nil {
+				return nil, 0, err
+			}
+		}
+		before := activity.DurationHours
+		activity.Draft = false
+		_, _, err := insertSegment(tx, activity, part.start.UTC(), end.UTC())
+		if err == nil {
+			err = deriveActivityHours(tx, activity, part.date)
+		}
+		if errors.Is(err, errSegmentOverlap) || errors.Is(err, errDayHoursExceeded) {
+			if err := tx.RollbackTo("timer_part").Error; err != nil {
+				return nil, 0, err
+			}
+			return nil, hours, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		added = activity.DurationHours - before
+	} else {
+		activity.DurationHours += added
+		activity.Draft = false
+		if err := tx.Save(activity).Error; err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return &TimerEntryDTO{
+		Date:          part.date.Format("2006-01-02"),
+		Activity:      timer.Name,
+		AddedHours:    added,
+		DayTotalHours: totalHours + added,
+	}, math.Max(hours-float64(added), 0), nil
+}
+
+// timerRunningResponse rejects a second timer and returns the one that is running
+func timerRunningResponse(c *fiber.Ctx, timer *models.ActivityTimer) error {
+	return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+		"success":    false,
+		"error":      "A timer is already running, stop it first",
+		"error_code": "TIMER_RUNNING",
+		"data":       ToTimerDTO(*timer, time.Now()),
+	})
+}
+
+func roundHours(hours float64) float64 {
+	return math.Round(hours*100) / 100
+}
+
+func ToTimerDTO(timer models.ActivityTimer, now time.Time) TimerDTO {
+	return TimerDTO{
+		Activity:       timer.Name,
+		Note:           timer.Note,
+		StartedAt:      timer.StartedAt,
+		ElapsedSeconds: int64(now.Sub(timer.StartedAt).Seconds()),
+	}
+}
@@ -0,0 +1,518 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/aman1117/backend/models"
+	"github.com/aman1117/backend/utils"
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const recoveryCodeCount = 10
+
+type verifyTOTPRequest struct {
+	Code string `json:"code"`
+}
+
+type passwordConfirmRequest struct {
+	Password string `json:"password"`
+}
+
+type mfaLoginRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// completeLogin is the last step of every first-factor login: users with 2FA
+// get an "mfa_required" challenge, everyone else gets tokens right away
+func completeLogin(c *fiber.Ctx, user *models.User) error {
+	if user.IsDisabled() {
+		return accountDisabledResponse(c)
+	}
+	if !user.TOTPEnabled {
+		return issueTokens(c, user)
+	}
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithFullContext(traceID, user.ID, user.Username)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// A fresh challenge must not buy a fresh set of code guesses
+	if wait := checkThrottles(ctx, secondFactorThrottleChecks(user, c.IP())...); wait > 0 {
+		log.Warnw("Throttled second factor challenge", "ip", c.IP())
+		return tooManyAttemptsResponse(c, wait)
+	}
+
+	rawToken, tokenHash, err := utils.GenerateResetToken()
+	if err == nil {
+		err = utils.StoreMFAChallenge(ctx, tokenHash, user.ID)
+	}
+	if err != nil {
+		log.Errorw("MFA challenge creation failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "An error occurred. Please try again.",
+			"error_code": "SERVER_ERROR",
+		})
+	}
+
+	log.Info("Password verified, waiting for second factor")
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success":      true,
+		"mfa_required": true,
+		"mfa_token":    rawToken,
+		"expires_in":   int(utils.MFAChallengeTTL.Seconds()),
+	})
+}
+
+// EnrollTOTPHandler handles POST /auth/2fa/enroll
+// Generates a new secret that only becomes active after /auth/2fa/verify
+func EnrollTOTPHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	user, err := GetUserByID(userID)
+	if err != nil || user == nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "User not found",
+			"error_code": "USER_NOT_FOUND",
+		})
+	}
+
+	if user.TOTPEnabled {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Two-factor authentication is already enabled",
+			"error_code": "TWO_FACTOR_ALREADY_ENABLED",
+		})
+	}
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		log.Errorw("TOTP secret generation failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "An error occurred. Please try again.",
+			"error_code": "SERVER_ERROR",
+		})
+	}
+
+	if err := SetPendingTOTPSecret(userID, secret); err != nil {
+		log.Errorw("TOTP secret save failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to start enrollment",
+			"error_code": "UPDATE_FAILED",
+		})
+	}
+
+	log.Info("2FA enrollment started")
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success":     true,
+		"secret":      secret,
+		"otpauth_uri": utils.TOTPURI(user.Email, secret),
+	})
+}
+
+// VerifyTOTPHandler handles POST /auth/2fa/verify
+// Confirms the authenticator app works, enables 2FA and returns recovery codes
+func VerifyTOTPHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	var body verifyTOTPRequest
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid request body",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	user, err := GetUserByID(userID)
+	if err != nil || user == nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "User not found",
+			"error_code": "USER_NOT_FOUND",
+		})
+	}
+
+	if user.TOTPEnabled {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Two-factor authentication is already enabled",
+			"error_code": "TWO_FACTOR_ALREADY_ENABLED",
+		})
+	}
+
+	if user.TOTPSecret == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Start enrollment first",
+			"error_code": "TWO_FACTOR_NOT_ENROLLED",
+		})
+	}
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+
+	valid, err := checkTOTPCode(user, body.Code)
+	if err != nil {
+		log.Errorw("TOTP verification failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "An error occurred. Please try again.",
+			"error_code": "SERVER_ERROR",
+		})
+	}
+	if !valid {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid verification code",
+			"error_code": "INVALID_CODE",
+		})
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		log.Errorw("Recovery code generation failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "An error occurred. Please try again.",
+			"error_code": "SERVER_ERROR",
+		})
+	}
+
+	if err := EnableTOTP(userID, hashes); err != nil {
+		log.Errorw("Enabling 2FA failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to enable two-factor authentication",
+			"error_code": "UPDATE_FAILED",
+		})
+	}
+
+	log.Info("2FA enabled")
+	recordAuditEvent(c, userID, models.AuditTwoFactorEnabled, nil)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success":        true,
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTOTPHandler handles POST /auth/2fa/disable
+func DisableTOTPHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	user, err := confirmPassword(c, userID)
+	if err != nil || user == nil {
+		return err
+	}
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+	if err := DisableTOTP(userID); err != nil {
+		log.Errorw("Disabling 2FA failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to disable two-factor authentication",
+			"error_code": "UPDATE_FAILED",
+		})
+	}
+
+	log.Info("2FA disabled")
+	recordAuditEvent(c, userID, models.AuditTwoFactorDisabled, nil)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Two-factor authentication disabled",
+	})
+}
+
+// RegenerateRecoveryCodesHandler handles POST /auth/2fa/recovery-codes
+// Invalidates all previous recovery codes
+func RegenerateRecoveryCodesHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Unauthorized",
+			"error_code": "UNAUTHORIZED",
+		})
+	}
+
+	user, err := confirmPassword(c, userID)
+	if err != nil || user == nil {
+		return err
+	}
+
+	if !user.TOTPEnabled {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Two-factor authentication is not enabled",
+			"error_code": "TWO_FACTOR_NOT_ENABLED",
+		})
+	}
+
+	traceID, _ := c.Locals("trace_id").(string)
+	log := utils.LogWithContext(traceID, userID)
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err == nil {
+		err = ReplaceRecoveryCodes(userID, hashes)
+	}
+	if err != nil {
+		log.Errorw("Recovery code regeneration failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Failed to generate recovery codes",
+			"error_code": "UPDATE_FAILED",
+		})
+	}
+
+	log.Info("Recovery codes regenerated")
+	recordAuditEvent(c, userID, models.AuditRecoveryCodesRegenerated, nil)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success":        true,
+		"recovery_codes": codes,
+	})
+}
+
+// MFALoginHandler handles POST /auth/2fa/login
+// Exchanges the challenge from LoginHandler plus a TOTP or recovery code for tokens
+func MFALoginHandler(c *fiber.Ctx) error {
+	var body mfaLoginRequest
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid request body",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	if body.MFAToken == "" || (body.Code == "" && body.RecoveryCode == "") {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "mfa_token and a code are required",
+			"error_code": "MISSING_FIELDS",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	traceID, _ := c.Locals("trace_id").(string)
+	userID, err := utils.ValidateMFAChallenge(ctx, body.MFAToken)
+	if err != nil {
+		utils.LogWithTrace(traceID).Errorw("MFA challenge lookup failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "An error occurred. Please try again.",
+			"error_code": "SERVER_ERROR",
+		})
+	}
+	if userID == 0 {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Login challenge expired, please log in again",
+			"error_code": "INVALID_MFA_TOKEN",
+		})
+	}
+
+	user, err := GetUserByID(userID)
+	if err != nil || user == nil || !user.TOTPEnabled {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Login challenge expired, please log in again",
+			"error_code": "INVALID_MFA_TOKEN",
+		})
+	}
+
+	log := utils.LogWithFullContext(traceID, user.ID, user.Username)
+
+	if wait := checkThrottles(ctx, secondFactorThrottleChecks(user, c.IP())...); wait > 0 {
+		log.Warnw("Throttled second factor attempt", "ip", c.IP())
+		return tooManyAttemptsResponse(c, wait)
+	}
+
+	// A recovery code is only checked here and spent once the challenge is ours,
+	// so a request that loses the challenge race does not burn it
+	var valid bool
+	codeHash := utils.HashToken(utils.NormalizeRecoveryCode(body.RecoveryCode))
+	if body.RecoveryCode != "" {
+		valid, err = RecoveryCodeUnused(user.ID, codeHash)
+	} else {
+		valid, err = checkTOTPCode(user, body.Code)
+	}
+	if err != nil {
+		log.Errorw("Second factor check failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "An error occurred. Please try again.",
+			"error_code": "SERVER_ERROR",
+		})
+	}
+	if !valid {
+		log.Warn("Invalid second factor code")
+		recordSecondFactorFailure(ctx, c.IP(), user)
+		recordAuditEvent(c, user.ID, models.AuditTwoFactorFailed, fiber.Map{"method": secondFactorMethod(body.RecoveryCode)})
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid verification code",
+			"error_code": "INVALID_CODE",
+		})
+	}
+
+	// The challenge is single-use, a concurrent request may have won the race
+	consumedID, err := utils.ConsumeMFAChallenge(ctx, body.MFAToken)
+	if err != nil || consumedID != user.ID {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Login challenge expired, please log in again",
+			"error_code": "INVALID_MFA_TOKEN",
+		})
+	}
+
+	if body.RecoveryCode != "" {
+		used, err := ConsumeRecoveryCode(user.ID, codeHash)
+		if err != nil {
+			log.Errorw("Recovery code consume failed", "error", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"error":      "An error occurred. Please try again.",
+				"error_code": "SERVER_ERROR",
+			})
+		}
+		// Spent by another login in the meantime
+		if !used {
+			log.Warn("Recovery code already used")
+			recordSecondFactorFailure(ctx, c.IP(), user)
+			recordAuditEvent(c, user.ID, models.AuditTwoFactorFailed, fiber.Map{"method": "recovery_code"})
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"error":      "Invalid verification code",
+				"error_code": "INVALID_CODE",
+			})
+		}
+
+		remaining, _ := CountUnusedRecoveryCodes(user.ID)
+		log.Infow("Recovery code used for login", "remaining", remaining)
+	}
+
+	resetSecondFactorFailures(ctx, user)
+	return issueTokens(c, user)
+}
+
+// secondFactorMethod names the kind of code a login sent, for the audit log
+func secondFactorMethod(recoveryCode string) string {
+	if recoveryCode != "" {
+		return "recovery_code"
+	}
+	return "totp"
+}
+
+// checkTOTPCode validates a code against the user's secret and burns its time step
+func checkTOTPCode(user *models.User, code string) (bool, error) {
+	if user.TOTPSecret == nil {
+		return false, nil
+	}
+
+	step, ok := utils.ValidateTOTP(*user.TOTPSecret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return utils.MarkTOTPStepUsed(ctx, user.ID, step)
+}
+
+// confirmPassword parses a passwordConfirmRequest body and checks it against the user's password.
+// If it returns a nil user, the error response has already been written.
+func confirmPassword(c *fiber.Ctx, userID uint) (*models.User, error) {
+	var body passwordConfirmRequest
+	if err := c.BodyParser(&body); err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Invalid request body",
+			"error_code": "INVALID_REQUEST",
+		})
+	}
+
+	if body.Password == "" {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Password is required",
+			"error_code": "MISSING_FIELDS",
+		})
+	}
+
+	user, err := GetUserByID(userID)
+	if err != nil || user == nil {
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"error":      "User not found",
+			"error_code": "USER_NOT_FOUND",
+		})
+	}
+
+	if user.NoPassword {
+		return nil, passwordNotSetResponse(c)
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(body.Password)); err != nil {
+		traceID, _ := c.Locals("trace_id").(string)
+		utils.LogWithContext(traceID, userID).Warn("Invalid password confirmation")
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Password is incorrect",
+			"error_code": "INVALID_PASSWORD",
+		})
+	}
+
+	return user, nil
+}
+
+// generateRecoveryCodes returns the raw codes to show once and their hashes to store
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := utils.GenerateRecoveryCode()
+		if err != nil {
+			return nil, nil, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, utils.HashToken(code))
+	}
+	return codes, hashes, nil
+}
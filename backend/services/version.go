@@ -0,0 +1,75 @@
+/*
+#Plan: Optimistic Concurrency
+
+- Activity entries and tile configs carry a version that every write bumps
+- Responses expose it as "version" and, for a single resource, as a weak ETag: W/"<version>"
+- Writes may send the version they are based on, as If-Match or as a "version" field
+  (If-Match wins when both are sent); without either the write is unconditional,
+  so existing clients keep working
+- Version 0 means "nothing exists yet", a create based on it fails if another
+  device created the resource in the meantime
+- A stale write answers 409 VERSION_CONFLICT with the current server state in
+  "current" (null if the resource is gone), the client merges and retries
+- The check and the write happen under the user row lock, so two writes based on
+  the same version cannot both win
+
+Covered writes: POST /create-activity, PATCH and DELETE /activities/:id,
+POST /activities/:id/revert, POST and DELETE /activities/segments (checked against
+the segment's entry) and POST /tile-config
+*/
+
+package services
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	errVersionConflict = errors.New("version conflict")
+	errInvalidIfMatch  = errors.New("invalid If-Match header")
+)
+
+// versionETag formats version as a weak ETag
+func versionETag(version uint) string {
+	return `W/"` + strconv.FormatUint(uint64(version), 10) + `"`
+}
+
+// expectedVersion returns the version the client based its write on, from the
+// If-Match header or else the body's version field. Nil means unconditional.
+func expectedVersion(c *fiber.Ctx, bodyVersion *uint) (*uint, error) {
+	header := strings.TrimSpace(c.Get(fiber.HeaderIfMatch))
+	if header == "" || header == "*" {
+		return bodyVersion, nil
+	}
+
+	tag := strings.Trim(strings.TrimPrefix(header, "W/"), `"`)
+	version, err := strconv.ParseUint(tag, 10, 32)
+	if err != nil {
+		return nil, errInvalidIfMatch
+	}
+	v := uint(version)
+	return &v, nil
+}
+
+// invalidVersionResponse answers a malformed If-Match header
+func invalidVersionResponse(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		"success":    false,
+		"error":      `If-Match must be a version ETag like W/"3"`,
+		"error_code": "INVALID_VERSION",
+	})
+}
+
+// versionConflictResponse answers a stale write with the current server state
+func versionConflictResponse(c *fiber.Ctx, current interface{}) error {
+	return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+		"success":    false,
+		"error":      "This was changed on another device, reload and try again",
+		"error_code": "VERSION_CONFLICT",
+		"current":    current,
+	})
+}
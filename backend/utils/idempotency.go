@@ -0,0 +1,112 @@
+/*
+#Plan: Redis Helper for Idempotency Keys
+
+Flow Overview:
+1. A write request with an Idempotency-Key header claims "idempotency:<keyHash>" with
+   SET NX, storing the request fingerprint and no response yet, TTL IdempotencyLockTTL
+2. If the claim succeeds the request runs, its response is then stored under the same
+   key with TTL IdempotencyTTL
+3. If the key already exists the stored record is returned to the caller, which
+   replays the response, rejects a different fingerprint or reports that the first
+   request is still running
+4. A request that failed with a server error releases its key so it can be retried
+
+Keys:
+- The key is hashed together with its scope (the user, or the client IP when
+  unauthenticated), so one client's key can never return another client's response
+*/
+
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	IdempotencyPrefix  = "idempotency:"
+	IdempotencyTTL     = 24 * time.Hour
+	IdempotencyLockTTL = time.Minute // longest a request may run before its key is claimable again
+)
+
+// IdempotencyRecord is what Redis holds for one idempotency key
+type IdempotencyRecord struct {
+	Fingerprint string            `json:"fingerprint"`
+	Status      int               `json:"status,omitempty"` // 0 while the first request is running
+	Headers     map[string]string `json:"headers,omitempty"`
+	Body        []byte            `json:"body,omitempty"`
+}
+
+// Completed reports whether the record holds a response to replay
+func (r *IdempotencyRecord) Completed() bool {
+	return r.Status != 0
+}
+
+// IdempotencyKey builds the Redis key for a client key within scope
+func IdempotencyKey(scope, key string) string {
+	return IdempotencyPrefix + HashToken(scope+":"+key)
+}
+
+// ClaimIdempotencyKey claims redisKey for a request with the given fingerprint.
+// Returns nil if the claim succeeded, otherwise the record stored by the first request.
+func ClaimIdempotencyKey(ctx context.Context, redisKey, fingerprint string) (*IdempotencyRecord, error) {
+	if redisClient == nil {
+		return nil, fmt.Errorf("redis client not initialized")
+	}
+
+	value, err := json.Marshal(IdempotencyRecord{Fingerprint: fingerprint})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode idempotency record: %w", err)
+	}
+
+	claimed, err := redisClient.SetNX(ctx, redisKey, value, IdempotencyLockTTL).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim idempotency key: %w", err)
+	}
+	if claimed {
+		return nil, nil
+	}
+
+	stored, err := redisClient.Get(ctx, redisKey).Bytes()
+	if err == redis.Nil {
+		// Expired between SETNX and GET, try once more
+		return ClaimIdempotencyKey(ctx, redisKey, fingerprint)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get idempotency key: %w", err)
+	}
+
+	var record IdempotencyRecord
+	if err := json.Unmarshal(stored, &record); err != nil {
+		return nil, fmt.Errorf("failed to decode idempotency record: %w", err)
+	}
+	return &record, nil
+}
+
+// CompleteIdempotencyKey stores the response of the request that claimed redisKey
+func CompleteIdempotencyKey(ctx context.Context, redisKey string, record IdempotencyRecord) error {
+	if redisClient == nil {
+		return fmt.Errorf("redis client not initialized")
+	}
+
+	value, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode idempotency record: %w", err)
+	}
+	if err := redisClient.Set(ctx, redisKey, value, IdempotencyTTL).Err(); err != nil {
+		return fmt.Errorf("failed to store idempotency record: %w", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey frees redisKey so the request can be retried
+func ReleaseIdempotencyKey(ctx context.Context, redisKey string) error {
+	if redisClient == nil {
+		return fmt.Errorf("redis client not initialized")
+	}
+	return redisClient.Del(ctx, redisKey).Err()
+}
@@ -0,0 +1,292 @@
+/*
+#Plan: Asymmetric JWT Signing Keys
+
+Configuration:
+- JWT_KEY_DIR     directory with one PEM file per key, the file name without ".pem" is the kid
+                  - private keys (PKCS#8 RSA/Ed25519 or PKCS#1 RSA) can sign and verify
+                  - public keys ("PUBLIC KEY") only verify, used for retired keys
+- JWT_ACTIVE_KID  kid used to sign new tokens (defaults to the greatest kid with a private key,
+                  so date based names like "2026-10" sort naturally)
+- JWT_SECRET_KEY  legacy HS256 secret: without JWT_KEY_DIR tokens are still signed with it,
+                  with JWT_KEY_DIR it only verifies old tokens that carry no kid
+
+Tokens carry the kid header, ParseToken picks the verification key by kid and
+rejects any algorithm that does not belong to that key.
+GET /.well-known/jwks.json publishes every public key so other services can verify tokens.
+
+Rotation procedure (old tokens stay valid until they expire):
+1. Generate the new key, e.g.
+     openssl genpkey -algorithm ed25519 -out $JWT_KEY_DIR/2026-11.pem
+   or openssl genpkey -algorithm RSA -pkeyopt rsa_keygen_bits:3072 -out $JWT_KEY_DIR/2026-11.pem
+2. Deploy it to every instance while JWT_ACTIVE_KID still names the old key, the new
+   key is now published in the JWKS and accepted everywhere
+3. Set JWT_ACTIVE_KID to the new kid and restart, new tokens are signed with it
+4. After TTL_ACCESS_TOKEN (plus JWKS cache time of consumers) has passed, replace the
+   old private key with its public key (openssl pkey -in old.pem -pubout) or delete it
+Migrating from HS256 works the same way: add a key directory, keep JWT_SECRET_KEY until
+the last HS256 token expired, then remove it.
+*/
+
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const minRSAKeyBits = 2048
+
+var validKeyID = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+type jwtKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.PrivateKey // nil for verification-only keys
+	public  crypto.PublicKey
+}
+
+type jwtKeySet struct {
+	active     *jwtKey // nil in legacy HS256 mode
+	keys       map[string]*jwtKey
+	hmacSecret []byte
+}
+
+// JSONWebKey is a public key as published in the JWKS
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+var (
+	jwtKeysMu sync.RWMutex
+	jwtKeys   *jwtKeySet
+)
+
+// InitJWTKeys loads the signing and verification keys from the environment
+func InitJWTKeys() error {
+	set, err := loadJWTKeys()
+	if err != nil {
+		return err
+	}
+
+	jwtKeysMu.Lock()
+	jwtKeys = set
+	jwtKeysMu.Unlock()
+
+	if set.active != nil {
+		Sugar.Infow("JWT keys loaded", "active_kid", set.active.kid, "keys", len(set.keys), "legacy_hs256", set.hmacSecret != nil)
+	} else {
+		Sugar.Warn("JWT_KEY_DIR not set, signing tokens with the legacy HS256 secret")
+	}
+	return nil
+}
+
+// getJWTKeys returns the loaded key set, loading it on first use
+func getJWTKeys() (*jwtKeySet, error) {
+	jwtKeysMu.RLock()
+	set := jwtKeys
+	jwtKeysMu.RUnlock()
+	if set != nil {
+		return set, nil
+	}
+	if err := InitJWTKeys(); err != nil {
+		return nil, err
+	}
+	jwtKeysMu.RLock()
+	defer jwtKeysMu.RUnlock()
+	return jwtKeys, nil
+}
+
+func loadJWTKeys() (*jwtKeySet, error) {
+	set := &jwtKeySet{keys: map[string]*jwtKey{}}
+	if secret := GetFromEnv("JWT_SECRET_KEY"); secret != "" {
+		set.hmacSecret = []byte(secret)
+	}
+
+	dir := GetFromEnv("JWT_KEY_DIR")
+	if dir == "" {
+		if set.hmacSecret == nil {
+			return nil, errors.New("neither JWT_KEY_DIR nor JWT_SECRET_KEY is set in environment variables")
+		}
+		return set, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list JWT keys: %w", err)
+	}
+	for _, file := range files {
+		kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+		if !validKeyID.MatchString(kid) {
+			return nil, fmt.Errorf("invalid JWT key file name %s", filepath.Base(file))
+		}
+		key, err := loadJWTKey(file, kid)
+		if err != nil {
+			return nil, err
+		}
+		set.keys[kid] = key
+	}
+
+	activeKID := GetFromEnv("JWT_ACTIVE_KID")
+	if activeKID == "" {
+		var signing []string
+		for kid, key := range set.keys {
+			if key.private != nil {
+				signing = append(signing, kid)
+			}
+		}
+		sort.Strings(signing)
+		if len(signing) > 0 {
+			activeKID = signing[len(signing)-1]
+		}
+	}
+
+	active, ok := set.keys[activeKID]
+	if !ok || active.private == nil {
+		return nil, fmt.Errorf("no private JWT key found for active kid %q in %s", activeKID, dir)
+	}
+	set.active = active
+	return set, nil
+}
+
+func loadJWTKey(file, kid string) (*jwtKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWT key %s: %w", kid, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("JWT key %s is not PEM encoded", kid)
+	}
+
+	key := &jwtKey{kid: kid}
+	switch block.Type {
+	case "PRIVATE KEY":
+		key.private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key.private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key.public, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("JWT key %s has unsupported PEM type %q", kid, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWT key %s: %w", kid, err)
+	}
+
+	if key.private != nil {
+		signer, ok := key.private.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("JWT key %s cannot sign", kid)
+		}
+		key.public = signer.Public()
+	}
+
+	switch pub := key.public.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("JWT key %s: RSA keys must have at least %d bits", kid, minRSAKeyBits)
+		}
+		key.method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("JWT key %s: only RSA and Ed25519 keys are supported", kid)
+	}
+	return key, nil
+}
+
+// signToken signs claims with the active key, or the legacy HS256 secret without a key directory
+func signToken(claims jwt.Claims) (string, error) {
+	set, err := getJWTKeys()
+	if err != nil {
+		return "", err
+	}
+
+	if set.active == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(set.hmacSecret)
+	}
+
+	token := jwt.NewWithClaims(set.active.method, claims)
+	token.Header["kid"] = set.active.kid
+	return token.SignedString(set.active.private)
+}
+
+// verificationKey is the jwt.Keyfunc for our own tokens
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	set, err := getJWTKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		// Tokens issued before the key directory was introduced
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok || set.hmacSecret == nil {
+			return nil, fmt.Errorf("unexpected signing method")
+		}
+		return set.hmacSecret, nil
+	}
+
+	key, ok := set.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method")
+	}
+	return key.public, nil
+}
+
+// PublicJWKS returns every public verification key for /.well-known/jwks.json
+func PublicJWKS() ([]JSONWebKey, error) {
+	set, err := getJWTKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	kids := make([]string, 0, len(set.keys))
+	for kid := range set.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	keys := make([]JSONWebKey, 0, len(kids))
+	for _, kid := range kids {
+		key := set.keys[kid]
+		jwk := JSONWebKey{Kid: kid, Use: "sig", Alg: key.method.Alg()}
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		keys = append(keys, jwk)
+	}
+	return keys, nil
+}
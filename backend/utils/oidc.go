@@ -0,0 +1,520 @@
+/*
+#Plan: OpenID Connect Client
+
+Configuration (one block per provider, names listed in OIDC_PROVIDERS=google,mock):
+- OIDC_<NAME>_ISSUER         issuer URL, discovery is read from <issuer>/.well-known/openid-configuration
+- OIDC_<NAME>_CLIENT_ID
+- OIDC_<NAME>_CLIENT_SECRET  optional for public clients
+- OIDC_<NAME>_REDIRECT_URL   frontend page that receives ?code=&state=
+- OIDC_<NAME>_SCOPES         optional, defaults to "openid email profile"
+- OIDC_<NAME>_DISPLAY_NAME   optional, defaults to the provider name
+
+Flow Overview:
+1. StartOIDCLogin creates a random state, nonce and PKCE verifier, stores them
+   hashed in Redis as "oidc_state:<hash>" for OIDCStateTTL and builds the
+   authorization URL (code_challenge_method=S256)
+2. The frontend posts code and state back, ConsumeOIDCState deletes the state (single-use)
+3. ExchangeOIDCCode redeems the code with the verifier at the token endpoint
+4. VerifyIDToken checks the signature against the provider's JWKS (RSA and EC keys),
+   iss, aud, exp and the nonce, and returns the claims
+
+Security:
+- The issuer must use https, except localhost/127.0.0.1 so a local mock issuer works
+  (go run ./mockoidc, see mockoidc/main.go for the matching configuration)
+- Discovery documents and JWKS are cached for oidcCacheTTL, an unknown kid forces one refetch
+*/
+
+package utils
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	OIDCStatePrefix = "oidc_state:"
+	OIDCStateTTL    = 10 * time.Minute
+	oidcCacheTTL    = time.Hour
+	oidcHTTPTimeout = 10 * time.Second
+)
+
+var (
+	ErrOIDCProviderUnknown = errors.New("unknown OIDC provider")
+	ErrOIDCInvalidIDToken  = errors.New("invalid ID token")
+)
+
+// OIDCProvider is one configured identity provider
+type OIDCProvider struct {
+	Name         string
+	DisplayName  string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// OIDCState is stored in Redis between the redirect to the provider and the callback
+type OIDCState struct {
+	Provider     string `json:"provider"`
+	CodeVerifier string `json:"code_verifier"`
+	Nonce        string `json:"nonce"`
+	LinkUserID   uint   `json:"link_user_id,omitempty"` // set when an existing account links a provider
+}
+
+// OIDCClaims are the ID token claims the app uses
+type OIDCClaims struct {
+	Email             string `json:"email"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+	Nonce             string `json:"nonce"`
+	AuthorizedParty   string `json:"azp,omitempty"`
+	jwt.RegisteredClaims
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcProviderCache struct {
+	discovery   *oidcDiscovery
+	discoveryAt time.Time
+	keys        map[string]crypto.PublicKey
+	keysAt      time.Time
+}
+
+var (
+	oidcHTTPClient = &http.Client{Timeout: oidcHTTPTimeout}
+	oidcCacheMu    sync.Mutex
+	oidcCache      = map[string]*oidcProviderCache{}
+)
+
+// GetOIDCProviders returns all providers configured in the environment
+func GetOIDCProviders() []OIDCProvider {
+	var providers []OIDCProvider
+	for _, name := range strings.Split(GetFromEnv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if p, err := GetOIDCProvider(name); err == nil {
+			providers = append(providers, *p)
+		} else {
+			Sugar.Warnw("Skipping OIDC provider", "provider", name, "error", err)
+		}
+	}
+	return providers
+}
+
+// GetOIDCProvider loads the configuration of one provider
+func GetOIDCProvider(name string) (*OIDCProvider, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	enabled := false
+	for _, n := range strings.Split(GetFromEnv("OIDC_PROVIDERS"), ",") {
+		if strings.ToLower(strings.TrimSpace(n)) == name && name != "" {
+			enabled = true
+		}
+	}
+	if !enabled {
+		return nil, ErrOIDCProviderUnknown
+	}
+
+	env := func(key string) string {
+		return strings.TrimSpace(GetFromEnv("OIDC_" + strings.ToUpper(name) + "_" + key))
+	}
+
+	p := &OIDCProvider{
+		Name:         name,
+		DisplayName:  env("DISPLAY_NAME"),
+		Issuer:       strings.TrimRight(env("ISSUER"), "/"),
+		ClientID:     env("CLIENT_ID"),
+		ClientSecret: env("CLIENT_SECRET"),
+		RedirectURL:  env("REDIRECT_URL"),
+		Scopes:       strings.Fields(env("SCOPES")),
+	}
+	if p.DisplayName == "" {
+		p.DisplayName = name
+	}
+	if len(p.Scopes) == 0 {
+		p.Scopes = []string{"openid", "email", "profile"}
+	}
+	if p.Issuer == "" || p.ClientID == "" || p.RedirectURL == "" {
+		return nil, fmt.Errorf("OIDC_%s_ISSUER, _CLIENT_ID and _REDIRECT_URL are required", strings.ToUpper(name))
+	}
+	if err := checkIssuerURL(p.Issuer); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// checkIssuerURL requires https, plain http is only allowed for local mock issuers
+func checkIssuerURL(issuer string) error {
+	u, err := url.Parse(issuer)
+	if err != nil {
+		return fmt.Errorf("invalid issuer URL: %w", err)
+	}
+	if u.Scheme == "https" {
+		return nil
+	}
+	host := u.Hostname()
+	if u.Scheme == "http" && (host == "localhost" || host == "127.0.0.1" || host == "::1") {
+		return nil
+	}
+	return fmt.Errorf("issuer %s must use https", issuer)
+}
+
+// StartOIDCLogin stores a new state and returns the authorization URL and the raw state
+func StartOIDCLogin(ctx context.Context, p *OIDCProvider, linkUserID uint) (authURL string, state string, err error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err = randomURLToken()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomURLToken()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomURLToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	payload := OIDCState{Provider: p.Name, CodeVerifier: verifier, Nonce: nonce, LinkUserID: linkUserID}
+	if err := storeTokenPayload(ctx, OIDCStatePrefix, HashToken(state), payload, OIDCStateTTL); err != nil {
+		return "", "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.ClientID)
+	params.Set("redirect_uri", p.RedirectURL)
+	params.Set("scope", strings.Join(p.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return discovery.AuthorizationEndpoint + sep + params.Encode(), state, nil
+}
+
+// ConsumeOIDCState returns and deletes the state, nil if it is unknown or expired
+func ConsumeOIDCState(ctx context.Context, rawState string) (*OIDCState, error) {
+	var payload OIDCState
+	found, err := consumeTokenPayload(ctx, OIDCStatePrefix, rawState, &payload)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &payload, nil
+}
+
+// ExchangeOIDCCode redeems an authorization code and returns the verified ID token claims
+func ExchangeOIDCCode(ctx context.Context, p *OIDCProvider, code string, state *OIDCState) (*OIDCClaims, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("client_id", p.ClientID)
+	form.Set("code_verifier", state.CodeVerifier)
+	if p.ClientSecret != "" {
+		form.Set("client_secret", p.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned %d: %s", resp.StatusCode, truncateForLog(string(body)))
+	}
+
+	var tokenResp struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return nil, fmt.Errorf("failed to parse token response: %w", err)
+	}
+	if tokenResp.IDToken == "" {
+		return nil, fmt.Errorf("token response has no id_token")
+	}
+
+	return p.VerifyIDToken(ctx, tokenResp.IDToken, state.Nonce)
+}
+
+// VerifyIDToken validates signature, issuer, audience, expiry and nonce of an ID token
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (*OIDCClaims, error) {
+	claims := &OIDCClaims{}
+	token, err := jwt.ParseWithClaims(rawIDToken, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.publicKey(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCInvalidIDToken, err)
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: missing sub", ErrOIDCInvalidIDToken)
+	}
+	if nonce == "" || claims.Nonce != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrOIDCInvalidIDToken)
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.ClientID {
+		return nil, fmt.Errorf("%w: azp mismatch", ErrOIDCInvalidIDToken)
+	}
+	return claims, nil
+}
+
+// discover returns the provider's (cached) discovery document
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	oidcCacheMu.Lock()
+	cache := oidcCache[p.Issuer]
+	if cache != nil && cache.discovery != nil && time.Since(cache.discoveryAt) < oidcCacheTTL {
+		d := cache.discovery
+		oidcCacheMu.Unlock()
+		return d, nil
+	}
+	oidcCacheMu.Unlock()
+
+	var d oidcDiscovery
+	if err := fetchJSON(ctx, p.Issuer+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	if strings.TrimRight(d.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("OIDC discovery issuer mismatch: %s", d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("OIDC discovery document is incomplete")
+	}
+
+	oidcCacheMu.Lock()
+	defer oidcCacheMu.Unlock()
+	if oidcCache[p.Issuer] == nil {
+		oidcCache[p.Issuer] = &oidcProviderCache{}
+	}
+	oidcCache[p.Issuer].discovery = &d
+	oidcCache[p.Issuer].discoveryAt = time.Now()
+	return &d, nil
+}
+
+// publicKey returns the JWKS key for kid, refetching the JWKS once if it is unknown
+func (p *OIDCProvider) publicKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	oidcCacheMu.Lock()
+	cache := oidcCache[p.Issuer]
+	var keys map[string]crypto.PublicKey
+	fresh := false
+	if cache != nil && cache.keys != nil {
+		keys = cache.keys
+		fresh = time.Since(cache.keysAt) < oidcCacheTTL
+	}
+	oidcCacheMu.Unlock()
+
+	if key := lookupJWK(keys, kid); key != nil && fresh {
+		return key, nil
+	}
+
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys, err = fetchJWKS(ctx, discovery.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+
+	oidcCacheMu.Lock()
+	if oidcCache[p.Issuer] == nil {
+		oidcCache[p.Issuer] = &oidcProviderCache{}
+	}
+	oidcCache[p.Issuer].keys = keys
+	oidcCache[p.Issuer].keysAt = time.Now()
+	oidcCacheMu.Unlock()
+
+	if key := lookupJWK(keys, kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("no signing key found for kid %q", kid)
+}
+
+// lookupJWK finds kid in keys, a token without kid only matches a single-key set
+func lookupJWK(keys map[string]crypto.PublicKey, kid string) crypto.PublicKey {
+	if kid != "" {
+		return keys[kid]
+	}
+	if len(keys) == 1 {
+		for _, key := range keys {
+			return key
+		}
+	}
+	return nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetchJWKS downloads a JWKS and parses its RSA and EC signing keys
+func fetchJWKS(ctx context.Context, jwksURI string) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := fetchJSON(ctx, jwksURI, &set); err != nil {
+		return nil, fmt.Errorf("JWKS fetch failed: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := parseJWK(k)
+		if err != nil {
+			Sugar.Warnw("Skipping JWKS key", "kid", k.Kid, "error", err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func parseJWK(k jsonWebKey) (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %w", err)
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("unsupported exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %w", err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate: %w", err)
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("point is not on curve")
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func fetchJSON(ctx context.Context, target string, dest interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", target, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(dest)
+}
+
+// randomURLToken returns 32 random bytes encoded as base64url, usable as state, nonce or PKCE verifier
+func randomURLToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+func truncateForLog(s string) string {
+	if len(s) > 200 {
+		return s[:200] + "..."
+	}
+	return s
+}
@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"strings"
+)
+
+const (
+	// PersonalAccessTokenPrefix marks tokens that are looked up in the database instead of parsed as JWTs
+	PersonalAccessTokenPrefix = "gt_pat_"
+	// personalAccessTokenDisplayLength is how much of the token is kept in plain text for listings
+	personalAccessTokenDisplayLength = len(PersonalAccessTokenPrefix) + 6
+)
+
+// GeneratePersonalAccessToken returns a new token, its SHA-256 hash and a short display prefix
+func GeneratePersonalAccessToken() (token, tokenHash, displayPrefix string, err error) {
+	raw, _, err := GenerateResetToken()
+	if err != nil {
+		return "", "", "", err
+	}
+	token = PersonalAccessTokenPrefix + raw
+	return token, HashToken(token), token[:personalAccessTokenDisplayLength], nil
+}
+
+// IsPersonalAccessToken reports whether a bearer token is a personal access token
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, PersonalAccessTokenPrefix)
+}
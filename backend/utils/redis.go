@@ -27,6 +27,7 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -36,11 +37,37 @@ import (
 var redisClient *redis.Client
 
 const (
-	ResetTokenPrefix = "reset:"
-	ResetTokenTTL    = 15 * time.Minute
-	TokenByteLength  = 32
+	ResetTokenPrefix       = "reset:"
+	ResetTokenTTL          = 15 * time.Minute
+	VerifyEmailTokenPrefix = "verify_email:"
+	VerifyEmailTokenTTL    = 24 * time.Hour
+	EmailChangeTokenPrefix = "email_change:"
+	EmailChangeTokenTTL    = time.Hour
+	EmailRevertTokenPrefix = "email_revert:"
+	EmailRevertTokenTTL    = 7 * 24 * time.Hour
+	MagicLinkTokenPrefix   = "magic_link:"
+	MagicLinkTokenTTL      = 10 * time.Minute
+	WebAuthnSessionPrefix  = "webauthn:"
+	WebAuthnSessionTTL     = 5 * time.Minute
+	TokenByteLength        = 32
 )
 
+// EmailChangeToken is the payload stored for email change confirm and revert links
+type EmailChangeToken struct {
+	UserID uint   `json:"user_id"`
+	Email  string `json:"email"` // new address for confirm links, old address for revert links
+
+	// Revert links only: whether the old address was verified, the revert restores it
+	EmailVerified bool `json:"email_verified"`
+}
+
+// WebAuthnSession holds the state of a passkey ceremony between its begin and finish requests
+type WebAuthnSession struct {
+	UserID  uint            `json:"user_id,omitempty"` // 0 for discoverable logins
+	Purpose string          `json:"purpose"`           // "register" or "login"
+	Data    json.RawMessage `json:"data"`              // webauthn.SessionData
+}
+
 // InitRedis initializes the Redis client
 func InitRedis() error {
 	redisURL := GetFromEnv("REDIS_URL")
@@ -102,90 +129,214 @@ func HashToken(rawToken string) string {
 // StoreResetToken stores a password reset token in Redis
 // Key: "reset:<tokenHash>", Value: "<userId>", TTL: 15 minutes
 func StoreResetToken(ctx context.Context, tokenHash string, userID uint) error {
+	return storeUserToken(ctx, ResetTokenPrefix, tokenHash, userID, ResetTokenTTL)
+}
+
+// ValidateResetToken checks if a reset token exists in Redis
+// Returns the userID if valid, 0 if invalid/expired
+// Does NOT delete the token (for validate-only endpoint)
+func ValidateResetToken(ctx context.Context, rawToken string) (uint, error) {
+	return validateUserToken(ctx, ResetTokenPrefix, rawToken)
+}
+
+// ConsumeResetToken validates and deletes a reset token (single-use)
+// Returns the userID if valid, 0 if invalid/expired
+func ConsumeResetToken(ctx context.Context, rawToken string) (uint, error) {
+	return consumeUserToken(ctx, ResetTokenPrefix, rawToken)
+}
+
+// DeleteResetToken explicitly deletes a reset token
+func DeleteResetToken(ctx context.Context, rawToken string) error {
+	return deleteUserToken(ctx, ResetTokenPrefix, rawToken)
+}
+
+// StoreEmailVerificationToken stores an email verification token in Redis
+// Key: "verify_email:<tokenHash>", Value: "<userId>", TTL: 24 hours
+func StoreEmailVerificationToken(ctx context.Context, tokenHash string, userID uint) error {
+	return storeUserToken(ctx, VerifyEmailTokenPrefix, tokenHash, userID, VerifyEmailTokenTTL)
+}
+
+// ConsumeEmailVerificationToken validates and deletes an email verification token (single-use)
+// Returns the userID if valid, 0 if invalid/expired
+func ConsumeEmailVerificationToken(ctx context.Context, rawToken string) (uint, error) {
+	return consumeUserToken(ctx, VerifyEmailTokenPrefix, rawToken)
+}
+
+// StoreEmailChangeToken stores a pending email change until the new address confirms it
+// Key: "email_change:<tokenHash>", Value: JSON {user_id, email}, TTL: 1 hour
+func StoreEmailChangeToken(ctx context.Context, tokenHash string, payload EmailChangeToken) error {
+	return storeTokenPayload(ctx, EmailChangeTokenPrefix, tokenHash, payload, EmailChangeTokenTTL)
+}
+
+// ConsumeEmailChangeToken validates and deletes an email change token (single-use)
+// Returns nil if invalid/expired
+func ConsumeEmailChangeToken(ctx context.Context, rawToken string) (*EmailChangeToken, error) {
+	var payload EmailChangeToken
+	found, err := consumeTokenPayload(ctx, EmailChangeTokenPrefix, rawToken, &payload)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &payload, nil
+}
+
+// StoreEmailRevertToken lets the previous address undo an email change
+// Key: "email_revert:<tokenHash>", Value: JSON {user_id, email}, TTL: 7 days
+func StoreEmailRevertToken(ctx context.Context, tokenHash string, payload EmailChangeToken) error {
+	return storeTokenPayload(ctx, EmailRevertTokenPrefix, tokenHash, payload, EmailRevertTokenTTL)
+}
+
+// ConsumeEmailRevertToken validates and deletes an email revert token (single-use)
+// Returns nil if invalid/expired
+func ConsumeEmailRevertToken(ctx context.Context, rawToken string) (*EmailChangeToken, error) {
+	var payload EmailChangeToken
+	found, err := consumeTokenPayload(ctx, EmailRevertTokenPrefix, rawToken, &payload)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &payload, nil
+}
+
+// StoreMagicLinkToken stores a passwordless login token
+// Key: "magic_link:<tokenHash>", Value: "<userId>", TTL: 10 minutes
+func StoreMagicLinkToken(ctx context.Context, tokenHash string, userID uint) error {
+	return storeUserToken(ctx, MagicLinkTokenPrefix, tokenHash, userID, MagicLinkTokenTTL)
+}
+
+// ConsumeMagicLinkToken validates and deletes a login link token (single-use)
+// Returns the userID if valid, 0 if invalid/expired
+func ConsumeMagicLinkToken(ctx context.Context, rawToken string) (uint, error) {
+	return consumeUserToken(ctx, MagicLinkTokenPrefix, rawToken)
+}
+
+// StoreWebAuthnSession stores the state of a passkey ceremony
+// Key: "webauthn:<tokenHash>", Value: JSON payload, TTL: 5 minutes
+func StoreWebAuthnSession(ctx context.Context, tokenHash string, session WebAuthnSession) error {
+	return storeTokenPayload(ctx, WebAuthnSessionPrefix, tokenHash, session, WebAuthnSessionTTL)
+}
+
+// ConsumeWebAuthnSession returns and deletes a ceremony state (single-use)
+// Returns nil if the token is invalid/expired
+func ConsumeWebAuthnSession(ctx context.Context, rawToken string) (*WebAuthnSession, error) {
+	var session WebAuthnSession
+	found, err := consumeTokenPayload(ctx, WebAuthnSessionPrefix, rawToken, &session)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// ==================== Shared single-use token helpers ====================
+// Every hashed user token (reset, MFA challenge, ...) follows the same
+// "<prefix><sha256(token)>" -> "<userId>" layout and only differs by prefix and TTL
+
+// storeUserToken stores "<prefix><tokenHash>" -> "<userId>" with the given TTL
+func storeUserToken(ctx context.Context, prefix, tokenHash string, userID uint, ttl time.Duration) error {
 	if redisClient == nil {
 		return fmt.Errorf("redis client not initialized")
 	}
 
-	key := ResetTokenPrefix + tokenHash
+	key := prefix + tokenHash
 	value := fmt.Sprintf("%d", userID)
 
-	err := redisClient.Set(ctx, key, value, ResetTokenTTL).Err()
+	err := redisClient.Set(ctx, key, value, ttl).Err()
 	if err != nil {
-		return fmt.Errorf("failed to store reset token: %w", err)
+		return fmt.Errorf("failed to store %stoken: %w", prefix, err)
 	}
 
 	return nil
 }
 
-// ValidateResetToken checks if a reset token exists in Redis
-// Returns the userID if valid, 0 if invalid/expired
-// Does NOT delete the token (for validate-only endpoint)
-func ValidateResetToken(ctx context.Context, rawToken string) (uint, error) {
+// validateUserToken returns the userID stored for rawToken, 0 if invalid/expired
+func validateUserToken(ctx context.Context, prefix, rawToken string) (uint, error) {
 	if redisClient == nil {
 		return 0, fmt.Errorf("redis client not initialized")
 	}
 
-	tokenHash := HashToken(rawToken)
-	key := ResetTokenPrefix + tokenHash
+	key := prefix + HashToken(rawToken)
 
 	value, err := redisClient.Get(ctx, key).Result()
 	if err == redis.Nil {
 		return 0, nil // Token doesn't exist or expired
 	}
 	if err != nil {
-		return 0, fmt.Errorf("failed to get reset token: %w", err)
-	}
-
-	var userID uint
-	if _, err := fmt.Sscanf(value, "%d", &userID); err != nil {
-		return 0, fmt.Errorf("failed to parse user ID: %w", err)
+		return 0, fmt.Errorf("failed to get %stoken: %w", prefix, err)
 	}
 
-	return userID, nil
+	return parseUserID(value)
 }
 
-// ConsumeResetToken validates and deletes a reset token (single-use)
-// Returns the userID if valid, 0 if invalid/expired
-func ConsumeResetToken(ctx context.Context, rawToken string) (uint, error) {
+// consumeUserToken returns the userID stored for rawToken and deletes it (single-use)
+// Returns 0 if invalid/expired
+func consumeUserToken(ctx context.Context, prefix, rawToken string) (uint, error) {
 	if redisClient == nil {
 		return 0, fmt.Errorf("redis client not initialized")
 	}
 
-	tokenHash := HashToken(rawToken)
-	key := ResetTokenPrefix + tokenHash
-
-	// Use a transaction to get and delete atomically
-	var userID uint
+	key := prefix + HashToken(rawToken)
 
-	// First get the value
-	value, err := redisClient.Get(ctx, key).Result()
+	// GETDEL reads and deletes atomically, so the token can only be used once
+	value, err := redisClient.GetDel(ctx, key).Result()
 	if err == redis.Nil {
 		return 0, nil // Token doesn't exist or expired
 	}
 	if err != nil {
-		return 0, fmt.Errorf("failed to get reset token: %w", err)
+		return 0, fmt.Errorf("failed to consume %stoken: %w", prefix, err)
 	}
 
-	if _, err := fmt.Sscanf(value, "%d", &userID); err != nil {
-		return 0, fmt.Errorf("failed to parse user ID: %w", err)
-	}
+	return parseUserID(value)
+}
 
-	// Delete the token (make it single-use)
-	if err := redisClient.Del(ctx, key).Err(); err != nil {
-		return 0, fmt.Errorf("failed to delete reset token: %w", err)
+// deleteUserToken explicitly deletes a token
+func deleteUserToken(ctx context.Context, prefix, rawToken string) error {
+	if redisClient == nil {
+		return fmt.Errorf("redis client not initialized")
 	}
 
-	return userID, nil
+	return redisClient.Del(ctx, prefix+HashToken(rawToken)).Err()
 }
 
-// DeleteResetToken explicitly deletes a reset token
-func DeleteResetToken(ctx context.Context, rawToken string) error {
+// storeTokenPayload stores "<prefix><tokenHash>" -> JSON(payload) for tokens that carry more than a user ID
+func storeTokenPayload(ctx context.Context, prefix, tokenHash string, payload interface{}, ttl time.Duration) error {
 	if redisClient == nil {
 		return fmt.Errorf("redis client not initialized")
 	}
 
-	tokenHash := HashToken(rawToken)
-	key := ResetTokenPrefix + tokenHash
+	value, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode %stoken: %w", prefix, err)
+	}
+
+	if err := redisClient.Set(ctx, prefix+tokenHash, value, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to store %stoken: %w", prefix, err)
+	}
+	return nil
+}
+
+// consumeTokenPayload decodes the payload stored for rawToken into dest and deletes it (single-use)
+// Returns false if the token is invalid/expired
+func consumeTokenPayload(ctx context.Context, prefix, rawToken string, dest interface{}) (bool, error) {
+	if redisClient == nil {
+		return false, fmt.Errorf("redis client not initialized")
+	}
 
-	return redisClient.Del(ctx, key).Err()
+	value, err := redisClient.GetDel(ctx, prefix+HashToken(rawToken)).Bytes()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to consume %stoken: %w", prefix, err)
+	}
+
+	if err := json.Unmarshal(value, dest); err != nil {
+		return false, fmt.Errorf("failed to decode %stoken: %w", prefix, err)
+	}
+	return true, nil
+}
+
+func parseUserID(value string) (uint, error) {
+	var userID uint
+	if _, err := fmt.Sscanf(value, "%d", &userID); err != nil {
+		return 0, fmt.Errorf("failed to parse user ID: %w", err)
+	}
+	return userID, nil
 }
@@ -0,0 +1,373 @@
+/*
+#Plan: Redis Helper for Sessions and Refresh Tokens
+
+Flow Overview:
+1. On login a session is created with a random ID and stored as the hash
+   "session:<sid>" holding user_id, device, user_agent, ip, created_at, last_seen
+2. The session ID is added to the user's set "user_sessions:<userId>"
+3. An opaque 32-byte refresh token is generated, hashed with SHA-256 and
+   stored as "refresh:<hash>" with value "<userId>:<sid>"
+4. Access tokens carry the session ID (sid claim), AuthMiddleware rejects
+   tokens whose session key no longer exists
+5. /auth/refresh atomically consumes the refresh token (GETDEL), issues a
+   new one for the same session and leaves a "refresh_used:<hash>" marker
+6. If a consumed refresh token is presented again, the whole session is
+   revoked (token reuse means it was stolen)
+7. Logout deletes the session key, logout-all deletes every session in the set
+8. AuthMiddleware bumps last_seen/ip at most once per SessionTouchInterval
+
+Security:
+- Raw refresh tokens never stored or logged, same as reset tokens
+- Refresh tokens are single-use, rotation happens on every refresh
+- Revoking a session invalidates its refresh token and its access tokens
+*/
+
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	SessionPrefix          = "session:"
+	UserSessionsPrefix     = "user_sessions:"
+	RefreshTokenPrefix     = "refresh:"
+	UsedRefreshTokenPrefix = "refresh_used:"
+	DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+	SessionTouchInterval   = time.Minute
+)
+
+// SessionInfo describes where a session was started and last used
+type SessionInfo struct {
+	ID        string    `json:"id"`
+	Device    string    `json:"device"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// touchSessionScript only updates sessions that still exist, so a touch racing
+// with a revoke cannot recreate the deleted hash
+var touchSessionScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return redis.call("HSET", KEYS[1], "last_seen", ARGV[1], "ip", ARGV[2])
+end
+return 0
+`)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// RefreshTokenTTL returns the refresh token lifetime from TTL_REFRESH_TOKEN (minutes)
+func RefreshTokenTTL() time.Duration {
+	ttl, err := strconv.Atoi(GetFromEnv("TTL_REFRESH_TOKEN"))
+	if err != nil || ttl <= 0 {
+		return DefaultRefreshTokenTTL
+	}
+	return time.Duration(ttl) * time.Minute
+}
+
+// CreateSession starts a new session for the user
+// Returns the session ID and the raw refresh token to hand to the client
+func CreateSession(ctx context.Context, userID uint, info SessionInfo) (sessionID string, rawRefreshToken string, err error) {
+	if redisClient == nil {
+		return "", "", fmt.Errorf("redis client not initialized")
+	}
+
+	sessionID, _, err = GenerateResetToken()
+	if err != nil {
+		return "", "", err
+	}
+	sessionID = sessionID[:32]
+
+	ttl := RefreshTokenTTL()
+	pipe := redisClient.TxPipeline()
+	now := time.Now().Unix()
+	pipe.HSet(ctx, SessionPrefix+sessionID, map[string]interface{}{
+		"user_id":    userID,
+		"device":     info.Device,
+		"user_agent": info.UserAgent,
+		"ip":         info.IP,
+		"created_at": now,
+		"last_seen":  now,
+	})
+	pipe.Expire(ctx, SessionPrefix+sessionID, ttl)
+	pipe.SAdd(ctx, userSessionsKey(userID), sessionID)
+	pipe.Expire(ctx, userSessionsKey(userID), ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", "", fmt.Errorf("failed to store session: %w", err)
+	}
+
+	rawRefreshToken, err = storeRefreshToken(ctx, userID, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+
+	return sessionID, rawRefreshToken, nil
+}
+
+// RotateRefreshToken consumes a refresh token and issues the next one for the same session
+// Returns ErrRefreshTokenReused (after revoking the session) if the token was already used
+func RotateRefreshToken(ctx context.Context, rawToken string) (userID uint, sessionID string, newRawToken string, err error) {
+	if redisClient == nil {
+		return 0, "", "", fmt.Errorf("redis client not initialized")
+	}
+
+	tokenHash := HashToken(rawToken)
+
+	// GETDEL makes the token single-use even under concurrent refreshes
+	value, err := redisClient.GetDel(ctx, RefreshTokenPrefix+tokenHash).Result()
+	if err == redis.Nil {
+		used, usedErr := redisClient.Get(ctx, UsedRefreshTokenPrefix+tokenHash).Result()
+		if usedErr == redis.Nil {
+			return 0, "", "", ErrRefreshTokenInvalid
+		}
+		if usedErr != nil {
+			return 0, "", "", fmt.Errorf("failed to check used refresh token: %w", usedErr)
+		}
+
+		reusedUserID, reusedSessionID, parseErr := parseRefreshValue(used)
+		if parseErr != nil {
+			return 0, "", "", parseErr
+		}
+		if err := RevokeSession(ctx, reusedUserID, reusedSessionID); err != nil {
+			return 0, "", "", err
+		}
+		return reusedUserID, reusedSessionID, "", ErrRefreshTokenReused
+	}
+	if err != nil {
+		return 0, "", "", fmt.Errorf("failed to get refresh token: %w", err)
+	}
+
+	userID, sessionID, err = parseRefreshValue(value)
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	ttl := RefreshTokenTTL()
+	if err := redisClient.Set(ctx, UsedRefreshTokenPrefix+tokenHash, value, ttl).Err(); err != nil {
+		return 0, "", "", fmt.Errorf("failed to mark refresh token used: %w", err)
+	}
+
+	active, err := IsSessionActive(ctx, sessionID)
+	if err != nil {
+		return 0, "", "", err
+	}
+	if !active {
+		return 0, "", "", ErrRefreshTokenInvalid
+	}
+
+	// Keep the session alive as long as it is being refreshed
+	pipe := redisClient.TxPipeline()
+	pipe.Expire(ctx, SessionPrefix+sessionID, ttl)
+	pipe.Expire(ctx, userSessionsKey(userID), ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, "", "", fmt.Errorf("failed to extend session: %w", err)
+	}
+
+	newRawToken, err = storeRefreshToken(ctx, userID, sessionID)
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	return userID, sessionID, newRawToken, nil
+}
+
+// IsSessionActive reports whether the session has not been revoked or expired
+func IsSessionActive(ctx context.Context, sessionID string) (bool, error) {
+	if redisClient == nil {
+		return false, fmt.Errorf("redis client not initialized")
+	}
+
+	n, err := redisClient.Exists(ctx, SessionPrefix+sessionID).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check session: %w", err)
+	}
+	return n > 0, nil
+}
+
+// TouchSession checks that the session is active and belongs to userID,
+// and records the request IP and time if the last touch is older than SessionTouchInterval
+func TouchSession(ctx context.Context, userID uint, sessionID string, ip string) (bool, error) {
+	if redisClient == nil {
+		return false, fmt.Errorf("redis client not initialized")
+	}
+
+	key := SessionPrefix + sessionID
+	values, err := redisClient.HMGet(ctx, key, "user_id", "last_seen").Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check session: %w", err)
+	}
+
+	owner, _ := values[0].(string)
+	if owner != strconv.FormatUint(uint64(userID), 10) {
+		return false, nil
+	}
+
+	lastSeen, _ := values[1].(string)
+	lastSeenUnix, _ := strconv.ParseInt(lastSeen, 10, 64)
+	if time.Since(time.Unix(lastSeenUnix, 0)) >= SessionTouchInterval {
+		if err := touchSessionScript.Run(ctx, redisClient, []string{key}, time.Now().Unix(), ip).Err(); err != nil && err != redis.Nil {
+			return false, fmt.Errorf("failed to update session: %w", err)
+		}
+	}
+
+	return true, nil
+}
+
+// ListSessions returns the user's active sessions, most recently used first
+func ListSessions(ctx context.Context, userID uint) ([]SessionInfo, error) {
+	if redisClient == nil {
+		return nil, fmt.Errorf("redis client not initialized")
+	}
+
+	sessionIDs, err := redisClient.SMembers(ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	sessions := make([]SessionInfo, 0, len(sessionIDs))
+	for _, sessionID := range sessionIDs {
+		fields, err := redisClient.HGetAll(ctx, SessionPrefix+sessionID).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get session: %w", err)
+		}
+
+		// Expired sessions are still in the set, drop them lazily
+		if len(fields) == 0 {
+			redisClient.SRem(ctx, userSessionsKey(userID), sessionID)
+			continue
+		}
+
+		createdAt, _ := strconv.ParseInt(fields["created_at"], 10, 64)
+		lastSeen, _ := strconv.ParseInt(fields["last_seen"], 10, 64)
+		sessions = append(sessions, SessionInfo{
+			ID:        sessionID,
+			Device:    fields["device"],
+			UserAgent: fields["user_agent"],
+			IP:        fields["ip"],
+			CreatedAt: time.Unix(createdAt, 0).UTC(),
+			LastSeen:  time.Unix(lastSeen, 0).UTC(),
+		})
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+	return sessions, nil
+}
+
+// HasSession reports whether sessionID is one of the user's sessions
+func HasSession(ctx context.Context, userID uint, sessionID string) (bool, error) {
+	if redisClient == nil {
+		return false, fmt.Errorf("redis client not initialized")
+	}
+
+	ok, err := redisClient.SIsMember(ctx, userSessionsKey(userID), sessionID).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check session: %w", err)
+	}
+	return ok, nil
+}
+
+// RevokeSession deletes a single session of the user
+func RevokeSession(ctx context.Context, userID uint, sessionID string) error {
+	if redisClient == nil {
+		return fmt.Errorf("redis client not initialized")
+	}
+
+	pipe := redisClient.TxPipeline()
+	pipe.Del(ctx, SessionPrefix+sessionID)
+	pipe.SRem(ctx, userSessionsKey(userID), sessionID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	return nil
+}
+
+// RevokeAllSessions deletes every session of the user
+func RevokeAllSessions(ctx context.Context, userID uint) error {
+	if redisClient == nil {
+		return fmt.Errorf("redis client not initialized")
+	}
+
+	sessionIDs, err := redisClient.SMembers(ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		return fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	pipe := redisClient.TxPipeline()
+	for _, sessionID := range sessionIDs {
+		pipe.Del(ctx, SessionPrefix+sessionID)
+	}
+	pipe.Del(ctx, userSessionsKey(userID))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return nil
+}
+
+// RevokeOtherSessions deletes every session of the user except keepSessionID
+func RevokeOtherSessions(ctx context.Context, userID uint, keepSessionID string) error {
+	if redisClient == nil {
+		return fmt.Errorf("redis client not initialized")
+	}
+
+	sessionIDs, err := redisClient.SMembers(ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		return fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	pipe := redisClient.TxPipeline()
+	for _, sessionID := range sessionIDs {
+		if sessionID == keepSessionID {
+			continue
+		}
+		pipe.Del(ctx, SessionPrefix+sessionID)
+		pipe.SRem(ctx, userSessionsKey(userID), sessionID)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return nil
+}
+
+func storeRefreshToken(ctx context.Context, userID uint, sessionID string) (string, error) {
+	rawToken, tokenHash, err := GenerateResetToken()
+	if err != nil {
+		return "", err
+	}
+
+	value := fmt.Sprintf("%d:%s", userID, sessionID)
+	if err := redisClient.Set(ctx, RefreshTokenPrefix+tokenHash, value, RefreshTokenTTL()).Err(); err != nil {
+		return "", fmt.Errorf("failed to store refresh token: %w", err)
+	}
+	return rawToken, nil
+}
+
+func parseRefreshValue(value string) (uint, string, error) {
+	userPart, sessionID, ok := strings.Cut(value, ":")
+	if !ok {
+		return 0, "", fmt.Errorf("malformed refresh token value")
+	}
+	userID, err := strconv.ParseUint(userPart, 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to parse user ID: %w", err)
+	}
+	return uint(userID), sessionID, nil
+}
+
+func userSessionsKey(userID uint) string {
+	return fmt.Sprintf("%s%d", UserSessionsPrefix, userID)
+}
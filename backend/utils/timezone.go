@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultTimezone is used for users who have not picked a timezone
+const DefaultTimezone = "Asia/Kolkata"
+
+// ValidateTimezone checks that name is a location in the IANA timezone database
+func ValidateTimezone(name string) error {
+	// "" and "Local" are accepted by time.LoadLocation but depend on the server
+	if name == "" || name == "Local" {
+		return fmt.Errorf("timezone must be an IANA name like Europe/Berlin")
+	}
+	if _, err := time.LoadLocation(name); err != nil {
+		return fmt.Errorf("unknown timezone: %s", name)
+	}
+	return nil
+}
+
+// LoadUserLocation returns the location for a user's timezone,
+// falling back to DefaultTimezone if it is empty or unknown
+func LoadUserLocation(name string) *time.Location {
+	if name != "" {
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc
+		}
+	}
+	loc, err := time.LoadLocation(DefaultTimezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// StartOfDay returns midnight of t's calendar date in loc
+func StartOfDay(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
@@ -0,0 +1,178 @@
+/*
+#Plan: TOTP Two-Factor Authentication
+
+Flow Overview:
+1. Enrollment generates a random 160-bit secret, stored on the user but not yet enabled
+2. The client shows the otpauth:// URI as a QR code for an authenticator app
+3. Verifying the first code enables 2FA and returns single-use recovery codes
+4. Login with a correct password returns an "mfa_required" challenge token
+   instead of an access token, stored hashed in Redis as "mfa:<hash>"
+5. The challenge is exchanged for tokens with a TOTP code or a recovery code
+
+Security:
+- Codes follow RFC 6238 (SHA-1, 6 digits, 30 second steps), one step of clock drift allowed
+- Each accepted time step is recorded in "totp_used:<userId>:<step>" so a code cannot be replayed
+- A challenge allows MFAChallengeMaxAttempts wrong codes before it is burned
+*/
+
+package utils
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	TOTPIssuer              = "Growth Tracker"
+	TOTPDigits              = 6
+	TOTPPeriod              = 30 * time.Second
+	TOTPSkew                = 1 // number of steps accepted before/after the current one
+	TOTPUsedPrefix          = "totp_used:"
+	MFAChallengePrefix      = "mfa:"
+	MFAChallengeTTL         = 5 * time.Minute
+	MFAChallengeMaxAttempts = 5
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a random base32 encoded 160-bit secret
+func GenerateTOTPSecret() (string, error) {
+	bytes := make([]byte, 20)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+	return totpEncoding.EncodeToString(bytes), nil
+}
+
+// TOTPURI builds the otpauth:// URI understood by authenticator apps
+func TOTPURI(accountName, secret string) string {
+	label := url.PathEscape(TOTPIssuer + ":" + accountName)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", TOTPIssuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprintf("%d", TOTPDigits))
+	params.Set("period", fmt.Sprintf("%d", int(TOTPPeriod.Seconds())))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// ValidateTOTP checks code against the secret at time now
+// Returns the matched time step so callers can enforce single use
+func ValidateTOTP(secret, code string, now time.Time) (step int64, ok bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != TOTPDigits {
+		return 0, false
+	}
+
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+
+	current := now.Unix() / int64(TOTPPeriod.Seconds())
+	for offset := int64(-TOTPSkew); offset <= TOTPSkew; offset++ {
+		candidate := totpCode(key, current+offset)
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(code)) == 1 {
+			return current + offset, true
+		}
+	}
+	return 0, false
+}
+
+// totpCode computes the RFC 4226 HOTP value for the given counter
+func totpCode(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, value%mod)
+}
+
+// MarkTOTPStepUsed records that the user consumed a time step
+// Returns false if the step was already used (replayed code)
+func MarkTOTPStepUsed(ctx context.Context, userID uint, step int64) (bool, error) {
+	if redisClient == nil {
+		return false, fmt.Errorf("redis client not initialized")
+	}
+
+	key := fmt.Sprintf("%s%d:%d", TOTPUsedPrefix, userID, step)
+	// Keep the marker until the step can no longer be accepted
+	ttl := TOTPPeriod * time.Duration(2*TOTPSkew+2)
+	ok, err := redisClient.SetNX(ctx, key, 1, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to record TOTP step: %w", err)
+	}
+	return ok, nil
+}
+
+// GenerateRecoveryCode returns a random code like "k3j9x-2mf8a"
+func GenerateRecoveryCode() (string, error) {
+	bytes := make([]byte, 7)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+	code := strings.ToLower(totpEncoding.EncodeToString(bytes))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// NormalizeRecoveryCode makes user input comparable to generated codes
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) == 10 && !strings.Contains(code, "-") {
+		code = code[:5] + "-" + code[5:]
+	}
+	return code
+}
+
+// StoreMFAChallenge stores a login challenge for a user who passed the password check
+// Key: "mfa:<tokenHash>", Value: "<userId>", TTL: 5 minutes
+func StoreMFAChallenge(ctx context.Context, tokenHash string, userID uint) error {
+	return storeUserToken(ctx, MFAChallengePrefix, tokenHash, userID, MFAChallengeTTL)
+}
+
+// ValidateMFAChallenge returns the userID of a pending challenge without consuming it
+// Every call counts as an attempt, after MFAChallengeMaxAttempts the challenge is deleted
+func ValidateMFAChallenge(ctx context.Context, rawToken string) (uint, error) {
+	userID, err := validateUserToken(ctx, MFAChallengePrefix, rawToken)
+	if err != nil || userID == 0 {
+		return userID, err
+	}
+
+	attemptsKey := MFAChallengePrefix + "attempts:" + HashToken(rawToken)
+	attempts, err := redisClient.Incr(ctx, attemptsKey).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count MFA attempts: %w", err)
+	}
+	redisClient.Expire(ctx, attemptsKey, MFAChallengeTTL)
+
+	if attempts > MFAChallengeMaxAttempts {
+		_ = deleteUserToken(ctx, MFAChallengePrefix, rawToken)
+		return 0, nil
+	}
+	return userID, nil
+}
+
+// ConsumeMFAChallenge deletes a challenge after a successful second factor
+func ConsumeMFAChallenge(ctx context.Context, rawToken string) (uint, error) {
+	return consumeUserToken(ctx, MFAChallengePrefix, rawToken)
+}
@@ -1,7 +1,6 @@
 package utils
 
 import (
-	"errors"
 	"fmt"
 	"os"
 	"strconv"
@@ -17,8 +16,10 @@ import (
 )
 
 type Claims struct {
-	UserID   uint   `json:"user_id"`
-	Username string `json:"username"`
+	UserID    uint   `json:"user_id"`
+	Username  string `json:"username"`
+	SessionID string `json:"sid,omitempty"`  // empty for tokens issued without a Redis session
+	Role      string `json:"role,omitempty"` // missing in tokens issued before roles existed, treated as "user"
 	jwt.RegisteredClaims
 }
 
@@ -77,41 +78,42 @@ func GetFromEnv(key string) string {
 	return os.Getenv(key)
 }
 
-func GenerateToken(user *models.User) (string, time.Time, error) {
-	now := time.Now()
+// AccessTokenTTL returns the access token lifetime from TTL_ACCESS_TOKEN (minutes)
+func AccessTokenTTL() (time.Duration, error) {
 	ttl, err := strconv.Atoi(GetFromEnv("TTL_ACCESS_TOKEN"))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(ttl) * time.Minute, nil
+}
+
+func GenerateToken(user *models.User, sessionID string) (string, time.Time, error) {
+	now := time.Now()
+	ttl, err := AccessTokenTTL()
 	if err != nil {
 		return "", time.Time{}, err
 	}
-	exp := now.Add(time.Duration(ttl) * time.Minute)
+	exp := now.Add(ttl)
 
 	claims := &Claims{
-		UserID:   user.ID,
-		Username: user.Username,
+		UserID:    user.ID,
+		Username:  user.Username,
+		SessionID: sessionID,
+		Role:      string(user.Role),
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(exp),
 			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	secretKey := GetFromEnv("JWT_SECRET_KEY")
-	if secretKey == "" {
-		return "", time.Time{}, errors.New("JWT_SECRET_KEY is not set in environment variables")
-	}
-	signed, err := token.SignedString([]byte(secretKey))
+	signed, err := signToken(claims)
 	return signed, exp, err
 }
 
 func ParseToken(tokenStr string) (*Claims, error) {
 	claims := &Claims{}
 
-	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method")
-		}
-		return []byte(GetFromEnv("JWT_SECRET_KEY")), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenStr, claims, verificationKey)
 
 	if err != nil {
 		return nil, err